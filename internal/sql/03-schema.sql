@@ -26,19 +26,55 @@ create table if not exists audit_log (
   -- attributes
   primary key(new_signature));
 
+-- ed25519_public_history
+create table if not exists ed25519_public_history (
+  -- our columns
+  user_id uuid not null check (user_id != '00000000-0000-0000-0000-000000000000'),
+  ed25519_public text not null check (ed25519_public != ''),
+  ed25519_public_digest text not null check (ed25519_public_digest != ''),
+  key_version uuid not null,
+  -- model base
+  insert_order bigint generated always as identity unique,
+  ctime bigint not null default unixtime(),
+  -- attributes
+  primary key (insert_order));
+  -- indexes
+  create index if not exists ed25519_public_history_user_id on ed25519_public_history (user_id);
+
+-- kv
+create table if not exists kv (
+  -- our columns
+  org uuid not null check (org != '00000000-0000-0000-0000-000000000000'),
+  key text not null check (key != ''),
+  value text not null check (value != ''),
+  value_digest text not null check (value_digest != ''),
+  key_version uuid not null,
+  -- model base
+  insert_order bigint generated always as identity unique,
+  ctime bigint not null default unixtime(),
+  mtime bigint not null default unixtime(),
+  signature uuid unique not null default gen_random_uuid(),
+  -- attributes
+  primary key (org, key));
+
 -- orgs
 create table if not exists orgs (
   -- our columns
   name text unique not null check (name != ''),
   owner uuid not null check (owner != '00000000-0000-0000-0000-000000000000'),
+  external_id text unique check (external_id != ''),
+  deactivate_at bigint check (deactivate_at > 0),
+  key_version uuid,
   -- model base
   id uuid unique not null default gen_random_uuid() check (id != '00000000-0000-0000-0000-000000000000'),
   insert_order bigint generated always as identity unique,
   schema_version bigint not null default 0 check (schema_version >= 0 and schema_version <= 99999),
-  status bigint not null check (status > 0 and status < 4),
+  -- 5 is archived; 4 (deleted) is for users only.
+  status bigint not null check (status in (1, 2, 3, 5)),
   ctime bigint not null default unixtime(),
   mtime bigint not null default unixtime(),
   signature uuid unique not null default gen_random_uuid(),
+  updated_by uuid check (updated_by != '00000000-0000-0000-0000-000000000000'),
   role bigint not null check (role > 0 and role < 4),
   -- attributes
   primary key (id));
@@ -64,11 +100,29 @@ create table if not exists repositories (
   -- indexes
   create unique index if not exists repositories_name_owner on repositories (name, owner);
 
+-- user_emails
+-- Secondary emails of a user. The primary email is `users.email`, whose
+-- digest is unique per org; secondaries are not unique until promoted.
+create table if not exists user_emails (
+  -- our columns
+  user_id uuid not null check (user_id != '00000000-0000-0000-0000-000000000000'),
+  email text not null check (email != ''),
+  email_digest text not null check (email_digest != ''),
+  key_version uuid not null,
+  verified boolean not null default false,
+  -- model base
+  insert_order bigint generated always as identity unique,
+  ctime bigint not null default unixtime(),
+  -- attributes
+  primary key (user_id, email_digest));
+
 -- users
 create table if not exists users (
   -- our columns
   ed25519_public text not null check (ed25519_public != ''),
   ed25519_public_digest text not null check (ed25519_public_digest != ''),
+  ed25519_private text check (ed25519_private != ''),
+  ed25519_private_digest text check (ed25519_private_digest != ''),
   display_name text not null check (display_name != ''),
   display_name_digest text not null check (display_name_digest != ''),
   email text not null check (email != ''),
@@ -80,16 +134,20 @@ create table if not exists users (
   id uuid unique not null default gen_random_uuid() check (id != '00000000-0000-0000-0000-000000000000'),
   insert_order bigint generated always as identity unique,
   schema_version bigint not null default 0 check (schema_version >= 0 and schema_version <= 99999),
-  status bigint not null check (status > 0 and status < 4),
+  status bigint not null check (status > 0 and status < 5),
   ctime bigint not null default unixtime(),
   mtime bigint not null default unixtime(),
   signature uuid unique not null default gen_random_uuid(),
+  updated_by uuid check (updated_by != '00000000-0000-0000-0000-000000000000'),
   role bigint not null check (role > 0 and role < 4),
   -- attributes
+  constraint users_ed25519_private_check
+    check ((ed25519_private is null) = (ed25519_private_digest is null)),
   primary key (id));
   --indexes
   create unique index if not exists users_email_digest_org on users (email_digest, org);
   create unique index if not exists users_ed25519_public_digest_org on users (ed25519_public_digest, org);
+  create index if not exists users_org_display_name_digest on users (org, display_name_digest);
 
 -- triggers
 create or replace function metadata_update()
@@ -120,6 +178,11 @@ before update on repositories
 for each row
 execute procedure metadata_update();
 
+create trigger update_kv
+before update on kv
+for each row
+execute procedure metadata_update();
+
 create or replace function orgs_audit_update()
 returns trigger
 as $orgs_audit_update$
@@ -143,7 +206,7 @@ begin
       new.mtime,
       old.signature,
       new.signature,
-      jsonb_build_object('old', old.owner, 'new', new.owner)
+      jsonb_build_object('old', old.owner, 'new', new.owner, 'updated_by', new.updated_by)
     );
   end if;
 
@@ -166,7 +229,7 @@ begin
       new.mtime,
       old.signature,
       new.signature,
-      jsonb_build_object('old', old.status, 'new', new.status)
+      jsonb_build_object('old', old.status, 'new', new.status, 'updated_by', new.updated_by)
     );
   end if;
 
@@ -202,7 +265,7 @@ begin
       new.mtime,
       old.signature,
       new.signature,
-      jsonb_build_object('old', old.ed25519_public_digest, 'new', new.ed25519_public_digest)
+      jsonb_build_object('old', old.ed25519_public_digest, 'new', new.ed25519_public_digest, 'updated_by', new.updated_by)
     );
   end if;
 
@@ -225,7 +288,7 @@ begin
       new.mtime,
       old.signature,
       new.signature,
-      jsonb_build_object('old', old.display_name_digest, 'new', new.display_name_digest)
+      jsonb_build_object('old', old.display_name_digest, 'new', new.display_name_digest, 'updated_by', new.updated_by)
     );
   end if;
 
@@ -248,7 +311,7 @@ begin
       new.mtime,
       old.signature,
       new.signature,
-      jsonb_build_object('old', old.key_version, 'new', new.key_version)
+      jsonb_build_object('old', old.key_version, 'new', new.key_version, 'updated_by', new.updated_by)
     );
   end if;
 
@@ -271,7 +334,7 @@ begin
       new.mtime,
       old.signature,
       new.signature,
-      jsonb_build_object('old', old.password, 'new', new.password)
+      jsonb_build_object('old', old.password, 'new', new.password, 'updated_by', new.updated_by)
     );
   end if;
 
@@ -294,7 +357,7 @@ begin
       new.mtime,
       old.signature,
       new.signature,
-      jsonb_build_object('old', old.status, 'new', new.status)
+      jsonb_build_object('old', old.status, 'new', new.status, 'updated_by', new.updated_by)
     );
   end if;
 
@@ -307,3 +370,74 @@ after update on users
 for each row
 execute procedure users_audit_update();
 
+
+-- users_history
+-- Each update of a users row archives the prior row here, so
+-- `user.ReadAsOf` can read the row as it was at a past time.
+create table if not exists users_history (
+  like users,
+  -- model base
+  history_order bigint generated always as identity unique,
+  -- attributes
+  primary key (history_order));
+  -- indexes
+  create index if not exists users_history_id on users_history (id);
+
+-- Columns are named rather than copied with `old.*`, since a migrated
+-- users_history may order them differently from users.
+create or replace function users_history_update()
+returns trigger
+as $users_history_update$
+begin
+  insert into users_history (
+    ed25519_public,
+    ed25519_public_digest,
+    ed25519_private,
+    ed25519_private_digest,
+    display_name,
+    display_name_digest,
+    email,
+    email_digest,
+    key_version,
+    org,
+    password,
+    id,
+    insert_order,
+    schema_version,
+    status,
+    ctime,
+    mtime,
+    signature,
+    updated_by,
+    role
+  )
+  values (
+    old.ed25519_public,
+    old.ed25519_public_digest,
+    old.ed25519_private,
+    old.ed25519_private_digest,
+    old.display_name,
+    old.display_name_digest,
+    old.email,
+    old.email_digest,
+    old.key_version,
+    old.org,
+    old.password,
+    old.id,
+    old.insert_order,
+    old.schema_version,
+    old.status,
+    old.ctime,
+    old.mtime,
+    old.signature,
+    old.updated_by,
+    old.role
+  );
+  return new;
+end;
+$users_history_update$ language plpgsql;
+
+create trigger users_history_update
+after update on users
+for each row
+execute procedure users_history_update();
@@ -1 +1 @@
-truncate audit_log, orgs, repositories, users;
+truncate audit_log, ed25519_public_history, kv, orgs, repositories, user_emails, users, users_history;
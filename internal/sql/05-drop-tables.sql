@@ -1,6 +1,13 @@
+drop index ed25519_public_history_user_id;
 drop index repositories_name_owner;
 drop index users_email_digest_org;
+drop index users_history_id;
+drop index users_org_display_name_digest;
 drop table audit_log;
+drop table ed25519_public_history;
+drop table kv;
 drop table orgs;
 drop table repositories;
+drop table user_emails;
 drop table users;
+drop table users_history;
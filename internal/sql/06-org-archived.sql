@@ -0,0 +1,5 @@
+-- Allow org status 5 (archived) in a database created before it
+-- existed. Archived orgs are disabled but keep their data and
+-- members; 4 (deleted) remains for users only.
+alter table orgs drop constraint if exists orgs_status_check;
+alter table orgs add constraint orgs_status_check check (status in (1, 2, 3, 5));
@@ -0,0 +1,14 @@
+-- Add secondary user emails to a database created before they
+-- existed. The primary email stays in users.email.
+create table if not exists user_emails (
+  -- our columns
+  user_id uuid not null check (user_id != '00000000-0000-0000-0000-000000000000'),
+  email text not null check (email != ''),
+  email_digest text not null check (email_digest != ''),
+  key_version uuid not null,
+  verified boolean not null default false,
+  -- model base
+  insert_order bigint generated always as identity unique,
+  ctime bigint not null default unixtime(),
+  -- attributes
+  primary key (user_id, email_digest));
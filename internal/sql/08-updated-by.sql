@@ -0,0 +1,186 @@
+-- Add updated_by to a database created before it existed, and replace
+-- the audit functions so audit_log details record it. The triggers
+-- call the functions by name and need not be recreated.
+alter table orgs add column if not exists
+  updated_by uuid check (updated_by != '00000000-0000-0000-0000-000000000000');
+alter table users add column if not exists
+  updated_by uuid check (updated_by != '00000000-0000-0000-0000-000000000000');
+
+create or replace function orgs_audit_update()
+returns trigger
+as $orgs_audit_update$
+begin
+  if old.owner is distinct from new.owner then
+    insert into audit_log (
+      audit_table,
+      audit_id,
+      audit_column,
+      old_mtime,
+      new_mtime,
+      old_signature,
+      new_signature,
+      details
+    )
+    values (
+      'orgs',
+      old.id,
+      'owner',
+      old.mtime,
+      new.mtime,
+      old.signature,
+      new.signature,
+      jsonb_build_object('old', old.owner, 'new', new.owner, 'updated_by', new.updated_by)
+    );
+  end if;
+
+  if old.status is distinct from new.status then
+    insert into audit_log (
+      audit_table,
+      audit_id,
+      audit_column,
+      old_mtime,
+      new_mtime,
+      old_signature,
+      new_signature,
+      details
+    )
+    values (
+      'orgs',
+      old.id,
+      'status',
+      old.mtime,
+      new.mtime,
+      old.signature,
+      new.signature,
+      jsonb_build_object('old', old.status, 'new', new.status, 'updated_by', new.updated_by)
+    );
+  end if;
+
+  return new;
+end;
+$orgs_audit_update$ language plpgsql;
+
+
+create or replace function users_audit_update()
+returns trigger
+as $users_audit_update$
+begin
+  if old.ed25519_public_digest is distinct from new.ed25519_public_digest then
+    insert into audit_log (
+      audit_table,
+      audit_id,
+      audit_column,
+      old_mtime,
+      new_mtime,
+      old_signature,
+      new_signature,
+      details
+    )
+    values (
+      'users',
+      old.id,
+      'ed25519_public_digest',
+      old.mtime,
+      new.mtime,
+      old.signature,
+      new.signature,
+      jsonb_build_object('old', old.ed25519_public_digest, 'new', new.ed25519_public_digest, 'updated_by', new.updated_by)
+    );
+  end if;
+
+  if old.display_name_digest is distinct from new.display_name_digest then
+    insert into audit_log (
+      audit_table,
+      audit_id,
+      audit_column,
+      old_mtime,
+      new_mtime,
+      old_signature,
+      new_signature,
+      details
+    )
+    values (
+      'users',
+      old.id,
+      'display_name_digest',
+      old.mtime,
+      new.mtime,
+      old.signature,
+      new.signature,
+      jsonb_build_object('old', old.display_name_digest, 'new', new.display_name_digest, 'updated_by', new.updated_by)
+    );
+  end if;
+
+  if old.key_version is distinct from new.key_version then
+    insert into audit_log (
+      audit_table,
+      audit_id,
+      audit_column,
+      old_mtime,
+      new_mtime,
+      old_signature,
+      new_signature,
+      details
+    )
+    values (
+      'users',
+      old.id,
+      'key_version',
+      old.mtime,
+      new.mtime,
+      old.signature,
+      new.signature,
+      jsonb_build_object('old', old.key_version, 'new', new.key_version, 'updated_by', new.updated_by)
+    );
+  end if;
+
+  if old.password is distinct from new.password then
+    insert into audit_log (
+      audit_table,
+      audit_id,
+      audit_column,
+      old_mtime,
+      new_mtime,
+      old_signature,
+      new_signature,
+      details
+    )
+    values (
+      'users',
+      old.id,
+      'password',
+      old.mtime,
+      new.mtime,
+      old.signature,
+      new.signature,
+      jsonb_build_object('old', old.password, 'new', new.password, 'updated_by', new.updated_by)
+    );
+  end if;
+
+  if old.status is distinct from new.status then
+    insert into audit_log (
+      audit_table,
+      audit_id,
+      audit_column,
+      old_mtime,
+      new_mtime,
+      old_signature,
+      new_signature,
+      details
+    )
+    values (
+      'users',
+      old.id,
+      'status',
+      old.mtime,
+      new.mtime,
+      old.signature,
+      new.signature,
+      jsonb_build_object('old', old.status, 'new', new.status, 'updated_by', new.updated_by)
+    );
+  end if;
+
+  return new;
+end;
+$users_audit_update$ language plpgsql;
+
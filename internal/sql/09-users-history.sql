@@ -0,0 +1,66 @@
+-- Add users_history and its trigger to a database created before they
+-- existed. Apply 08-updated-by.sql first: the table copies the columns
+-- of users, and the trigger names the columns present at that point.
+-- 15-user-ed25519-private.sql replaces the trigger function.
+create table if not exists users_history (
+  like users,
+  -- model base
+  history_order bigint generated always as identity unique,
+  -- attributes
+  primary key (history_order));
+  -- indexes
+  create index if not exists users_history_id on users_history (id);
+
+create or replace function users_history_update()
+returns trigger
+as $users_history_update$
+begin
+  insert into users_history (
+    ed25519_public,
+    ed25519_public_digest,
+    display_name,
+    display_name_digest,
+    email,
+    email_digest,
+    key_version,
+    org,
+    password,
+    id,
+    insert_order,
+    schema_version,
+    status,
+    ctime,
+    mtime,
+    signature,
+    updated_by,
+    role
+  )
+  values (
+    old.ed25519_public,
+    old.ed25519_public_digest,
+    old.display_name,
+    old.display_name_digest,
+    old.email,
+    old.email_digest,
+    old.key_version,
+    old.org,
+    old.password,
+    old.id,
+    old.insert_order,
+    old.schema_version,
+    old.status,
+    old.ctime,
+    old.mtime,
+    old.signature,
+    old.updated_by,
+    old.role
+  );
+  return new;
+end;
+$users_history_update$ language plpgsql;
+
+drop trigger if exists users_history_update on users;
+create trigger users_history_update
+after update on users
+for each row
+execute procedure users_history_update();
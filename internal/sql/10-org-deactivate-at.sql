@@ -0,0 +1,4 @@
+-- Add scheduled deactivation of orgs to a database created before it
+-- existed. See org.ScheduleDeactivation and runtime.ProcessScheduled.
+alter table orgs add column if not exists
+  deactivate_at bigint check (deactivate_at > 0);
@@ -0,0 +1,4 @@
+-- Add external_id to orgs in a database created before it existed.
+-- See org.ReadByExternalID.
+alter table orgs add column if not exists
+  external_id text unique check (external_id != '');
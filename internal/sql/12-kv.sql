@@ -0,0 +1,22 @@
+-- Add the kv table and its trigger to a database created before they
+-- existed. See the kv package.
+create table if not exists kv (
+  -- our columns
+  org uuid not null check (org != '00000000-0000-0000-0000-000000000000'),
+  key text not null check (key != ''),
+  value text not null check (value != ''),
+  value_digest text not null check (value_digest != ''),
+  key_version uuid not null,
+  -- model base
+  insert_order bigint generated always as identity unique,
+  ctime bigint not null default unixtime(),
+  mtime bigint not null default unixtime(),
+  signature uuid unique not null default gen_random_uuid(),
+  -- attributes
+  primary key (org, key));
+
+drop trigger if exists update_kv on kv;
+create trigger update_kv
+before update on kv
+for each row
+execute procedure metadata_update();
@@ -0,0 +1,4 @@
+-- Allow user status 4 (deleted) in a database created before it
+-- existed. Only user.Shred sets it.
+alter table users drop constraint if exists users_status_check;
+alter table users add constraint users_status_check check (status > 0 and status < 5);
@@ -0,0 +1,15 @@
+-- Add ed25519_public_history to a database created before it existed.
+-- See user.NewEd25519 and user.VerifyWithHistory.
+create table if not exists ed25519_public_history (
+  -- our columns
+  user_id uuid not null check (user_id != '00000000-0000-0000-0000-000000000000'),
+  ed25519_public text not null check (ed25519_public != ''),
+  ed25519_public_digest text not null check (ed25519_public_digest != ''),
+  key_version uuid not null,
+  -- model base
+  insert_order bigint generated always as identity unique,
+  ctime bigint not null default unixtime(),
+  -- attributes
+  primary key (insert_order));
+  -- indexes
+  create index if not exists ed25519_public_history_user_id on ed25519_public_history (user_id);
@@ -0,0 +1,67 @@
+-- Add the optional Ed25519 private key to users in a database created
+-- before it existed. See user.SetEd25519Private.
+alter table users add column if not exists
+  ed25519_private text check (ed25519_private != '');
+alter table users add column if not exists
+  ed25519_private_digest text check (ed25519_private_digest != '');
+alter table users drop constraint if exists users_ed25519_private_check;
+alter table users add constraint users_ed25519_private_check
+  check ((ed25519_private is null) = (ed25519_private_digest is null));
+
+-- users_history copies the users columns; apply 09-users-history.sql
+-- first. The new columns are appended to both tables in different
+-- positions, so the trigger names every column.
+alter table users_history add column if not exists ed25519_private text;
+alter table users_history add column if not exists ed25519_private_digest text;
+
+create or replace function users_history_update()
+returns trigger
+as $users_history_update$
+begin
+  insert into users_history (
+    ed25519_public,
+    ed25519_public_digest,
+    ed25519_private,
+    ed25519_private_digest,
+    display_name,
+    display_name_digest,
+    email,
+    email_digest,
+    key_version,
+    org,
+    password,
+    id,
+    insert_order,
+    schema_version,
+    status,
+    ctime,
+    mtime,
+    signature,
+    updated_by,
+    role
+  )
+  values (
+    old.ed25519_public,
+    old.ed25519_public_digest,
+    old.ed25519_private,
+    old.ed25519_private_digest,
+    old.display_name,
+    old.display_name_digest,
+    old.email,
+    old.email_digest,
+    old.key_version,
+    old.org,
+    old.password,
+    old.id,
+    old.insert_order,
+    old.schema_version,
+    old.status,
+    old.ctime,
+    old.mtime,
+    old.signature,
+    old.updated_by,
+    old.role
+  );
+  return new;
+end;
+$users_history_update$ language plpgsql;
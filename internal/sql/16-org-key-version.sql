@@ -0,0 +1,3 @@
+-- Add key_version to orgs in a database created before it existed.
+-- See org.Rekey.
+alter table orgs add column if not exists key_version uuid;
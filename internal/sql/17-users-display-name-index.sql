@@ -0,0 +1,3 @@
+-- Add the users display name digest index to a database created before
+-- it existed. See user.DisplayNameDigestCount.
+create index if not exists users_org_display_name_digest on users (org, display_name_digest);
@@ -0,0 +1,172 @@
+/*
+Package auth authenticates bearer tokens against the users
+they were issued to. It is separate from package runtime
+because package user depends on runtime.
+*/
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	go_jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"grokloc.com/pkg/model/status"
+	"grokloc.com/pkg/org"
+	"grokloc.com/pkg/runtime"
+	"grokloc.com/pkg/security/jwt"
+	"grokloc.com/pkg/user"
+)
+
+var (
+	ErrToken         = errors.New("token not valid")
+	ErrExpired       = errors.New("token expired")
+	ErrUserNotFound  = errors.New("token user not found")
+	ErrUserNotActive = errors.New("token user not active")
+	ErrOrgArchived   = errors.New("token user org archived")
+	ErrOrgNotActive  = errors.New("token user org not active")
+	ErrOrgNotFound   = errors.New("token user org not found")
+)
+
+// Authenticate decodes a token produced by `jwt.EncodeClaims` and reads
+// its subject from a replica. The token's `sig` claim must match the
+// user's signature, or `user.ErrTokenRevoked` is returned; a token
+// without one, such as from `jwt.Encode`, returns `ErrToken`, so a
+// token cannot escape revocation by dropping the claim.
+// The user must be active, and its org must not be archived.
+func Authenticate(
+	ctx context.Context,
+	st *runtime.State,
+	tokenStr string,
+) (*user.User, error) {
+	return AuthenticateAllowingStatus(ctx, st, tokenStr, []int{status.Active})
+}
+
+// AuthenticateAllowingStatus is `Authenticate` for flows, such as
+// email confirmation, that must accept users with a status other
+// than active. The user's status must be in `allowed`. Members of an
+// archived org are rejected with `ErrOrgArchived` whatever `allowed` is.
+func AuthenticateAllowingStatus(
+	ctx context.Context,
+	st *runtime.State,
+	tokenStr string,
+	allowed []int,
+) (*user.User, error) {
+	conn, err := st.ReadPool().Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	u, _, err := authenticate(ctx, st, conn.Conn(), tokenStr, allowed)
+	if err != nil {
+		return nil, err
+	}
+
+	archived, err := org.Archived(ctx, conn.Conn(), u.Org)
+	if err != nil {
+		return nil, err
+	}
+	if archived {
+		return nil, ErrOrgArchived
+	}
+
+	return u, nil
+}
+
+// AuthenticateWithOrg is `Authenticate` that also returns the user's
+// org, which must be active: an archived org returns `ErrOrgArchived`,
+// any other status `ErrOrgNotActive`, and a missing org
+// `ErrOrgNotFound`. If the token has an `org` claim, it must be the
+// user's org.
+func AuthenticateWithOrg(
+	ctx context.Context,
+	st *runtime.State,
+	tokenStr string,
+) (*user.User, *org.Org, error) {
+	conn, err := st.ReadPool().Acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Release()
+
+	u, token, err := authenticate(ctx, st, conn.Conn(), tokenStr, []int{status.Active})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	claims, err := jwt.Claims(token)
+	if err != nil {
+		return nil, nil, ErrToken
+	}
+	if claims.Org != uuid.Nil && claims.Org != u.Org {
+		return nil, nil, ErrToken
+	}
+
+	o, err := org.Read(ctx, conn.Conn(), u.Org)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, ErrOrgNotFound
+		}
+		return nil, nil, err
+	}
+	switch o.Status {
+	case status.Active:
+		return u, o, nil
+	case status.Archived:
+		return nil, nil, ErrOrgArchived
+	default:
+		return nil, nil, ErrOrgNotActive
+	}
+}
+
+// authenticate decodes `tokenStr`, reads its subject on `conn`, and
+// checks its `sig` claim and that its status is in `allowed`.
+func authenticate(
+	ctx context.Context,
+	st *runtime.State,
+	conn *pgx.Conn,
+	tokenStr string,
+	allowed []int,
+) (*user.User, *go_jwt.Token, error) {
+	token, err := jwt.Decode(tokenStr, st.Issuer, st.SigningKey)
+	if err != nil {
+		if errors.Is(err, jwt.ErrExpired) {
+			return nil, nil, ErrExpired
+		}
+		return nil, nil, ErrToken
+	}
+
+	sub, err := token.Claims.GetSubject()
+	if err != nil {
+		return nil, nil, ErrToken
+	}
+	id, err := runtime.ParseID(sub)
+	if err != nil {
+		return nil, nil, ErrToken
+	}
+	sig, err := jwt.Signature(token)
+	if err != nil {
+		return nil, nil, ErrToken
+	}
+
+	u, err := user.Read(ctx, conn, st.EncryptionKeys, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, ErrUserNotFound
+		}
+		return nil, nil, err
+	}
+
+	if sig != u.Signature {
+		return nil, nil, user.ErrTokenRevoked
+	}
+
+	if !slices.Contains(allowed, u.Status) {
+		return nil, nil, ErrUserNotActive
+	}
+
+	return u, token, nil
+}
@@ -0,0 +1,276 @@
+/*
+Package auth authenticates bearer tokens against the users
+they were issued to. It is separate from package runtime
+because package user depends on runtime.
+*/
+package auth
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	go_jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"grokloc.com/pkg/model/status"
+	"grokloc.com/pkg/org"
+	"grokloc.com/pkg/runtime"
+	"grokloc.com/pkg/security/jwt"
+	"grokloc.com/pkg/user"
+)
+
+var st *runtime.State
+
+func TestMain(m *testing.M) {
+	var stErr error
+	st, stErr = runtime.Unit()
+	if stErr != nil {
+		log.Fatal(stErr.Error())
+	}
+	m.Run()
+}
+
+func forTest(t *testing.T, userStatus int) *user.User {
+	conn, err := st.Master.Acquire(context.Background())
+	require.NoError(t, err, "master conn")
+	defer conn.Release()
+
+	versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+	require.NoError(t, err, "versionKey")
+
+	return user.ForTest(
+		context.Background(),
+		conn.Conn(),
+		*versionKey,
+		uuid.New(),
+		userStatus,
+	)
+}
+
+func TestAuthenticate(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		u := forTest(t, status.Active)
+
+		tokenStr := jwt.ForTest(u.ID, u.Signature, st.Issuer, st.SigningKey)
+
+		authUser, err := Authenticate(context.Background(), st, tokenStr)
+		require.NoError(t, err, "authenticate")
+		require.Equal(t, *u, *authUser, "user")
+	})
+
+	t.Run("BadToken", func(t *testing.T) {
+		t.Parallel()
+		_, err := Authenticate(context.Background(), st, "not a token")
+		require.Error(t, err, "authenticate")
+		require.Equal(t, ErrToken, err, "token err")
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		t.Parallel()
+		past := time.Now().Add(-time.Hour).Unix()
+		tokenStr, err := go_jwt.NewWithClaims(
+			go_jwt.SigningMethodHS256,
+			go_jwt.MapClaims{
+				"iss": st.Issuer,
+				"sub": uuid.NewString(),
+				"nbf": past,
+				"iat": past,
+				"exp": past + 1,
+			},
+		).SignedString(st.SigningKey)
+		require.NoError(t, err, "sign")
+
+		_, err = Authenticate(context.Background(), st, tokenStr)
+		require.Error(t, err, "authenticate")
+		require.Equal(t, ErrExpired, err, "expired err")
+	})
+
+	t.Run("UserNotFound", func(t *testing.T) {
+		t.Parallel()
+		tokenStr := jwt.ForTest(uuid.New(), uuid.New(), st.Issuer, st.SigningKey)
+
+		_, err := Authenticate(context.Background(), st, tokenStr)
+		require.Error(t, err, "authenticate")
+		require.Equal(t, ErrUserNotFound, err, "not found err")
+	})
+
+	t.Run("NoSignature", func(t *testing.T) {
+		t.Parallel()
+		u := forTest(t, status.Active)
+
+		// Without a `sig` claim the token cannot be checked for
+		// revocation, so it is rejected.
+		tokenStr, err := jwt.Encode(u.ID, st.Issuer, st.SigningKey)
+		require.NoError(t, err, "encode")
+
+		_, err = Authenticate(context.Background(), st, tokenStr)
+		require.Equal(t, ErrToken, err, "token err")
+	})
+
+	t.Run("UserNotActive", func(t *testing.T) {
+		t.Parallel()
+		u := forTest(t, status.Inactive)
+
+		tokenStr := jwt.ForTest(u.ID, u.Signature, st.Issuer, st.SigningKey)
+
+		_, err := Authenticate(context.Background(), st, tokenStr)
+		require.Error(t, err, "authenticate")
+		require.Equal(t, ErrUserNotActive, err, "not active err")
+	})
+
+	t.Run("OrgArchived", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		o, owner := org.ForTest(context.Background(), conn.Conn(), *versionKey, status.Active)
+		_, err = o.Archive(context.Background(), conn.Conn(), st.RateLimiter, nil, uuid.New())
+		require.NoError(t, err, "archive")
+
+		tokenStr := jwt.ForTest(owner.ID, owner.Signature, st.Issuer, st.SigningKey)
+
+		_, err = Authenticate(context.Background(), st, tokenStr)
+		require.Error(t, err, "authenticate")
+		require.Equal(t, ErrOrgArchived, err, "archived err")
+	})
+
+	t.Run("Revoked", func(t *testing.T) {
+		t.Parallel()
+		u := forTest(t, status.Active)
+
+		tokenStr, err := jwt.EncodeClaims(
+			u.ID,
+			uuid.New(), // not the user's signature
+			uuid.Nil,   // org owner
+			st.Issuer,
+			st.SigningKey,
+		)
+		require.NoError(t, err, "encode claims")
+
+		_, err = Authenticate(context.Background(), st, tokenStr)
+		require.Error(t, err, "authenticate")
+		require.Equal(t, user.ErrTokenRevoked, err, "revoked err")
+	})
+}
+
+func TestAuthenticateAllowingStatus(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		u := forTest(t, status.Unconfirmed)
+
+		tokenStr := jwt.ForTest(u.ID, u.Signature, st.Issuer, st.SigningKey)
+
+		authUser, err := AuthenticateAllowingStatus(
+			context.Background(),
+			st,
+			tokenStr,
+			[]int{status.Unconfirmed, status.Active},
+		)
+		require.NoError(t, err, "authenticate")
+		require.Equal(t, u.ID, authUser.ID, "id")
+	})
+
+	t.Run("UserNotActive", func(t *testing.T) {
+		t.Parallel()
+		u := forTest(t, status.Unconfirmed)
+
+		tokenStr := jwt.ForTest(u.ID, u.Signature, st.Issuer, st.SigningKey)
+
+		_, err := Authenticate(context.Background(), st, tokenStr)
+		require.Error(t, err, "authenticate")
+		require.Equal(t, ErrUserNotActive, err, "not active err")
+
+		_, err = AuthenticateAllowingStatus(
+			context.Background(),
+			st,
+			tokenStr,
+			[]int{status.Inactive},
+		)
+		require.Error(t, err, "authenticate allowing inactive")
+		require.Equal(t, ErrUserNotActive, err, "not active err")
+	})
+}
+
+func TestAuthenticateWithOrg(t *testing.T) {
+	forTestOrg := func(t *testing.T, orgStatus int) (*org.Org, *user.User) {
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		return org.ForTest(context.Background(), conn.Conn(), *versionKey, orgStatus)
+	}
+
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		o, owner := forTestOrg(t, status.Active)
+
+		authUser, authOrg, err := AuthenticateWithOrg(
+			context.Background(),
+			st,
+			jwt.ForTest(owner.ID, owner.Signature, st.Issuer, st.SigningKey),
+		)
+		require.NoError(t, err, "authenticate")
+		require.Equal(t, owner.ID, authUser.ID, "user")
+		require.Equal(t, o, authOrg, "org")
+
+		// An org claim must match the user's org.
+		c := jwt.NewClaims(owner.ID, st.Issuer)
+		c.Signature = owner.Signature
+		c.Org = uuid.New()
+		tokenStr, err := c.Encode(st.SigningKey)
+		require.NoError(t, err, "encode")
+		_, _, err = AuthenticateWithOrg(context.Background(), st, tokenStr)
+		require.Equal(t, ErrToken, err, "org claim")
+	})
+
+	t.Run("OrgNotActive", func(t *testing.T) {
+		t.Parallel()
+		_, owner := forTestOrg(t, status.Inactive)
+
+		_, _, err := AuthenticateWithOrg(
+			context.Background(),
+			st,
+			jwt.ForTest(owner.ID, owner.Signature, st.Issuer, st.SigningKey),
+		)
+		require.Equal(t, ErrOrgNotActive, err, "not active err")
+	})
+
+	t.Run("OrgArchived", func(t *testing.T) {
+		t.Parallel()
+		o, owner := forTestOrg(t, status.Active)
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+		_, err = o.Archive(context.Background(), conn.Conn(), st.RateLimiter, nil, uuid.New())
+		require.NoError(t, err, "archive")
+
+		_, _, err = AuthenticateWithOrg(
+			context.Background(),
+			st,
+			jwt.ForTest(owner.ID, owner.Signature, st.Issuer, st.SigningKey),
+		)
+		require.Equal(t, ErrOrgArchived, err, "archived err")
+	})
+
+	t.Run("OrgNotFound", func(t *testing.T) {
+		t.Parallel()
+		u := forTest(t, status.Active)
+
+		_, _, err := AuthenticateWithOrg(
+			context.Background(),
+			st,
+			jwt.ForTest(u.ID, u.Signature, st.Issuer, st.SigningKey),
+		)
+		require.Equal(t, ErrOrgNotFound, err, "not found err")
+	})
+}
@@ -0,0 +1,111 @@
+/*
+Package cache provides an in-memory LRU cache with expiring entries.
+*/
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache is an LRU cache of at most `size` entries, each of which
+// expires `ttl` after it is set. It is safe for concurrent use. All
+// methods of a nil Cache are no-ops, so a nil Cache disables caching.
+type Cache[K comparable, V any] struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // Front is most recently used.
+	entries map[K]*list.Element
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// New returns a Cache holding at most `size` entries for `ttl` each.
+func New[K comparable, V any](size int, ttl time.Duration) *Cache[K, V] {
+	return &Cache[K, V]{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[K]*list.Element),
+	}
+}
+
+// Get returns the value for `k` if it is present and not expired.
+func (c *Cache[K, V]) Get(k K) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[k]
+	if !ok {
+		return zero, false
+	}
+	e := el.Value.(*entry[K, V])
+	if time.Now().After(e.expires) {
+		c.remove(el)
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return e.value, true
+}
+
+// Set adds or replaces the value for `k`, evicting the least recently
+// used entry if the cache is full.
+func (c *Cache[K, V]) Set(k K, v V) {
+	if c == nil || c.size < 1 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.entries[k]; ok {
+		e := el.Value.(*entry[K, V])
+		e.value = v
+		e.expires = expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[k] = c.order.PushFront(&entry[K, V]{key: k, value: v, expires: expires})
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Delete removes `k`.
+func (c *Cache[K, V]) Delete(k K) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[k]; ok {
+		c.remove(el)
+	}
+}
+
+// Len returns the number of entries, including expired entries not
+// yet removed.
+func (c *Cache[K, V]) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *Cache[K, V]) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*entry[K, V]).key)
+}
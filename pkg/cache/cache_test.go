@@ -0,0 +1,67 @@
+/*
+Package cache provides an in-memory LRU cache with expiring entries.
+*/
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		c := New[string, int](2, time.Minute)
+		c.Set("a", 1)
+		v, ok := c.Get("a")
+		require.True(t, ok, "get")
+		require.Equal(t, 1, v, "value")
+
+		c.Set("a", 2)
+		v, ok = c.Get("a")
+		require.True(t, ok, "get")
+		require.Equal(t, 2, v, "replaced")
+		require.Equal(t, 1, c.Len(), "len")
+
+		c.Delete("a")
+		_, ok = c.Get("a")
+		require.False(t, ok, "deleted")
+	})
+
+	t.Run("Evict", func(t *testing.T) {
+		t.Parallel()
+		c := New[string, int](2, time.Minute)
+		c.Set("a", 1)
+		c.Set("b", 2)
+		_, ok := c.Get("a") // b is now least recently used.
+		require.True(t, ok, "get")
+		c.Set("c", 3)
+		require.Equal(t, 2, c.Len(), "len")
+		_, ok = c.Get("b")
+		require.False(t, ok, "evicted")
+		_, ok = c.Get("a")
+		require.True(t, ok, "kept")
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		t.Parallel()
+		c := New[string, int](2, time.Millisecond)
+		c.Set("a", 1)
+		time.Sleep(5 * time.Millisecond)
+		_, ok := c.Get("a")
+		require.False(t, ok, "expired")
+		require.Equal(t, 0, c.Len(), "removed")
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		t.Parallel()
+		var c *Cache[string, int]
+		c.Set("a", 1)
+		_, ok := c.Get("a")
+		require.False(t, ok, "get")
+		c.Delete("a")
+		require.Equal(t, 0, c.Len(), "len")
+	})
+}
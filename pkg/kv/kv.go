@@ -0,0 +1,91 @@
+/*
+Package kv provides an encrypted key-value store scoped
+by org in the `kv` database table.
+*/
+package kv
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"grokloc.com/pkg/security/crypt"
+	"grokloc.com/pkg/security/digest"
+	pkg_key "grokloc.com/pkg/security/key"
+)
+
+// valueLabel derives the key that encrypts values from the versioned key.
+const valueLabel = "kv_value"
+
+// Set encrypts `value` and stores it under `key` in `org`, replacing
+// any value already stored there.
+func Set(
+	ctx context.Context,
+	conn *pgx.Conn,
+	versionedKey pkg_key.Versioned,
+	org uuid.UUID,
+	key string,
+	value string,
+) error {
+	encryptedValue, err := crypt.Encrypt(
+		value,
+		pkg_key.DeriveField(versionedKey.Key, valueLabel),
+	)
+	if err != nil {
+		return err
+	}
+
+	const query = `
+	insert into kv
+	(org, key, value, value_digest, key_version)
+	values
+	($1, $2, $3, $4, $5)
+	on conflict (org, key) do update
+	set value = excluded.value,
+	value_digest = excluded.value_digest,
+	key_version = excluded.key_version
+	`
+
+	_, err = conn.Exec(
+		ctx,
+		query,
+		org,
+		key,
+		encryptedValue,
+		digest.SHA256Hex(value),
+		versionedKey.Version,
+	)
+	return err
+}
+
+// Get returns the decrypted value stored under `key` in `org`.
+func Get(
+	ctx context.Context,
+	conn *pgx.Conn,
+	m pkg_key.KeyProvider,
+	org uuid.UUID,
+	key string,
+) (string, error) {
+	const query = `select value, value_digest, key_version
+		from kv
+		where org = $1 and key = $2`
+
+	var encryptedValue, valueDigest string
+	var keyVersion uuid.UUID
+	err := conn.QueryRow(ctx, query, org, key).
+		Scan(&encryptedValue, &valueDigest, &keyVersion)
+	if err != nil {
+		return "", err
+	}
+
+	versionedKey, err := m.Get(keyVersion)
+	if err != nil {
+		return "", err
+	}
+
+	return crypt.Decrypt(
+		encryptedValue,
+		valueDigest,
+		pkg_key.DeriveField(versionedKey.Key, valueLabel),
+	)
+}
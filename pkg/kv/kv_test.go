@@ -0,0 +1,176 @@
+/*
+Package kv provides an encrypted key-value store scoped
+by org in the `kv` database table.
+*/
+package kv
+
+import (
+	"context"
+	"log"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+	"grokloc.com/pkg/runtime"
+	pkg_key "grokloc.com/pkg/security/key"
+)
+
+var st *runtime.State
+
+func TestMain(m *testing.M) {
+	var stErr error
+	st, stErr = runtime.Unit()
+	if stErr != nil {
+		log.Fatal(stErr.Error())
+	}
+	m.Run()
+}
+
+func TestSetGet(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		key := uuid.NewString()
+		value := uuid.NewString()
+
+		err = Set(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			org,
+			key,
+			value,
+		)
+		require.NoError(t, err, "set")
+
+		readValue, err := Get(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			org,
+			key,
+		)
+		require.NoError(t, err, "get")
+		require.Equal(t, value, readValue, "round trip")
+
+		// Value is not stored in plaintext.
+		var storedValue string
+		err = conn.QueryRow(
+			context.Background(),
+			`select value from kv where org = $1 and key = $2`,
+			org,
+			key,
+		).Scan(&storedValue)
+		require.NoError(t, err, "select value")
+		require.NotEqual(t, value, storedValue, "encrypted")
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		key := uuid.NewString()
+		value := uuid.NewString()
+
+		for _, v := range []string{uuid.NewString(), value} {
+			err = Set(
+				context.Background(),
+				conn.Conn(),
+				*versionKey,
+				org,
+				key,
+				v,
+			)
+			require.NoError(t, err, "set")
+		}
+
+		readValue, err := Get(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			org,
+			key,
+		)
+		require.NoError(t, err, "get")
+		require.Equal(t, value, readValue, "overwritten")
+	})
+
+	t.Run("OrgScope", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		key := uuid.NewString()
+		err = Set(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			key,
+			uuid.NewString(),
+		)
+		require.NoError(t, err, "set")
+
+		// Same key in another org is not found.
+		_, err = Get(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			uuid.New(),
+			key,
+		)
+		require.Error(t, err, "get")
+		require.Equal(t, pgx.ErrNoRows, err, "not found")
+	})
+
+	t.Run("KeyNotFound", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		key := uuid.NewString()
+		err = Set(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			org,
+			key,
+			uuid.NewString(),
+		)
+		require.NoError(t, err, "set")
+
+		// Decryption key will not be found in an empty provider.
+		_, err = Get(
+			context.Background(),
+			conn.Conn(),
+			pkg_key.NewMemoryProvider(),
+			org,
+			key,
+		)
+		require.Error(t, err, "empty keys")
+		require.Equal(t, pkg_key.ErrNotFound, err, "not found")
+	})
+}
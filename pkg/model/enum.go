@@ -0,0 +1,27 @@
+/*
+Package model provides types shared by the database models.
+*/
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrCorruptEnum = errors.New("column value not a known constant")
+
+// CorruptEnumError is returned when a row read from the db has a value
+// in an enum column, such as `status` or `role`, outside the known
+// constants. It matches `ErrCorruptEnum` with `errors.Is`.
+type CorruptEnumError struct {
+	Field string
+	Value int
+}
+
+func (e *CorruptEnumError) Error() string {
+	return fmt.Sprintf("%s: %s %d", ErrCorruptEnum, e.Field, e.Value)
+}
+
+func (e *CorruptEnumError) Unwrap() error {
+	return ErrCorruptEnum
+}
@@ -0,0 +1,24 @@
+/*
+Package model provides types shared by the database models.
+*/
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorruptEnumError(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		var err error = &CorruptEnumError{Field: "status", Value: 99}
+		require.ErrorIs(t, err, ErrCorruptEnum, "is")
+		require.Contains(t, err.Error(), "status 99", "names field and value")
+
+		var enumErr *CorruptEnumError
+		require.True(t, errors.As(err, &enumErr), "as")
+		require.Equal(t, "status", enumErr.Field, "field")
+	})
+}
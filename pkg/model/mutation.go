@@ -0,0 +1,20 @@
+/*
+Package model provides types shared by the database models.
+*/
+package model
+
+import (
+	"github.com/google/uuid"
+)
+
+// MutationResult is returned by update methods, which also update
+// their receiver, so callers building change events or optimistic-lock
+// loops need not diff the struct.
+type MutationResult struct {
+	// Modified is false if the update was a no-op and no row changed.
+	Modified bool
+
+	// Mtime and Signature of the row after the update.
+	Mtime     int64
+	Signature uuid.UUID
+}
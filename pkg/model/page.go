@@ -0,0 +1,40 @@
+/*
+Package model provides types shared by the database models.
+*/
+package model
+
+import (
+	"errors"
+)
+
+var (
+	ErrCursor = errors.New("page cursor must not be negative")
+	ErrLimit  = errors.New("page limit must be positive")
+)
+
+// Page is one page of a keyset-paginated listing.
+type Page[T any] struct {
+	Items []T
+
+	// Next is the cursor to pass to fetch the following page.
+	Next int64
+
+	// HasMore is true if the following page has at least one item.
+	HasMore bool
+}
+
+// NewPage builds a `Page` from `rows` selected with a limit of `limit+1`;
+// the extra row, if present, is trimmed and only sets `HasMore`. `cursor`
+// extracts the keyset value from an item. `after` is the cursor that
+// selected `rows`, and is retained as `Next` when `rows` is empty.
+func NewPage[T any](rows []T, after int64, limit int, cursor func(T) int64) *Page[T] {
+	page := &Page[T]{Items: rows, Next: after}
+	if len(rows) > limit {
+		page.Items = rows[:limit]
+		page.HasMore = true
+	}
+	if len(page.Items) > 0 {
+		page.Next = cursor(page.Items[len(page.Items)-1])
+	}
+	return page
+}
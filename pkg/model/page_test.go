@@ -0,0 +1,38 @@
+/*
+Package model provides types shared by the database models.
+*/
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPage(t *testing.T) {
+	cursor := func(i int64) int64 { return i }
+
+	t.Run("HasMore", func(t *testing.T) {
+		t.Parallel()
+		page := NewPage([]int64{4, 5, 6}, 3, 2, cursor)
+		require.Equal(t, []int64{4, 5}, page.Items, "items")
+		require.Equal(t, int64(5), page.Next, "next")
+		require.True(t, page.HasMore, "has more")
+	})
+
+	t.Run("Last", func(t *testing.T) {
+		t.Parallel()
+		page := NewPage([]int64{4, 5}, 3, 2, cursor)
+		require.Equal(t, []int64{4, 5}, page.Items, "items")
+		require.Equal(t, int64(5), page.Next, "next")
+		require.False(t, page.HasMore, "has more")
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		t.Parallel()
+		page := NewPage([]int64{}, 3, 2, cursor)
+		require.Empty(t, page.Items, "items")
+		require.Equal(t, int64(3), page.Next, "next")
+		require.False(t, page.HasMore, "has more")
+	})
+}
@@ -1,11 +1,49 @@
 /*
 Package role provides model role constants that
 map to database values.
+
+Users and orgs both have a role column with the same
+range of values, but the meaning differs. A user role is
+a privilege level. An org role is the class of the org.
 */
 package role
 
+// User roles.
 const (
 	Normal = 1
 	Admin  = 2
 	Test   = 3
 )
+
+// Org roles. These reuse the user role values so that the
+// database checks are shared, but name the class of an org.
+const (
+	// OrgTenant is an org for a customer of the deployment.
+	OrgTenant = Normal
+
+	// OrgSystem is an org for the operator of the deployment.
+	OrgSystem = Admin
+
+	// OrgTest is an org created by test automation.
+	OrgTest = Test
+)
+
+// Valid returns true if r is a user role.
+func Valid(r int) bool {
+	return r == Normal || r == Admin || r == Test
+}
+
+// ValidOrg returns true if r is an org role.
+func ValidOrg(r int) bool {
+	return r == OrgTenant || r == OrgSystem || r == OrgTest
+}
+
+// OrgOwner returns the user role for the owner of an org
+// with org role r. Owners administer their org, except in
+// test orgs where all users have the test role.
+func OrgOwner(r int) int {
+	if r == OrgTest {
+		return Test
+	}
+	return Admin
+}
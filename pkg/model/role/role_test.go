@@ -0,0 +1,38 @@
+/*
+Package role provides model role constants that
+map to database values.
+*/
+package role
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRole(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		t.Parallel()
+		require.True(t, Valid(Normal), "normal")
+		require.True(t, Valid(Admin), "admin")
+		require.True(t, Valid(Test), "test")
+		require.False(t, Valid(0), "zero")
+		require.False(t, Valid(99), "out of range")
+	})
+
+	t.Run("ValidOrg", func(t *testing.T) {
+		t.Parallel()
+		require.True(t, ValidOrg(OrgTenant), "tenant")
+		require.True(t, ValidOrg(OrgSystem), "system")
+		require.True(t, ValidOrg(OrgTest), "test")
+		require.False(t, ValidOrg(0), "zero")
+		require.False(t, ValidOrg(99), "out of range")
+	})
+
+	t.Run("OrgOwner", func(t *testing.T) {
+		t.Parallel()
+		require.Equal(t, Admin, OrgOwner(OrgTenant), "tenant")
+		require.Equal(t, Admin, OrgOwner(OrgSystem), "system")
+		require.Equal(t, Test, OrgOwner(OrgTest), "test")
+	})
+}
@@ -8,4 +8,16 @@ const (
 	Unconfirmed = 1
 	Active      = 2
 	Inactive    = 3
+	Deleted     = 4 // Users only, see `user.Shred`.
+	Archived    = 5 // Orgs only, see `org.Archive`.
 )
+
+// Valid returns true if s is a user status.
+func Valid(s int) bool {
+	return s == Unconfirmed || s == Active || s == Inactive || s == Deleted
+}
+
+// ValidOrg returns true if s is an org status.
+func ValidOrg(s int) bool {
+	return s == Unconfirmed || s == Active || s == Inactive || s == Archived
+}
@@ -0,0 +1,34 @@
+/*
+Package status provides model status constants that
+map to database values.
+*/
+package status
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatus(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		t.Parallel()
+		require.True(t, Valid(Unconfirmed), "unconfirmed")
+		require.True(t, Valid(Active), "active")
+		require.True(t, Valid(Inactive), "inactive")
+		require.True(t, Valid(Deleted), "deleted")
+		require.False(t, Valid(Archived), "archived")
+		require.False(t, Valid(0), "zero")
+		require.False(t, Valid(99), "out of range")
+	})
+
+	t.Run("ValidOrg", func(t *testing.T) {
+		t.Parallel()
+		require.True(t, ValidOrg(Unconfirmed), "unconfirmed")
+		require.True(t, ValidOrg(Active), "active")
+		require.True(t, ValidOrg(Inactive), "inactive")
+		require.True(t, ValidOrg(Archived), "archived")
+		require.False(t, ValidOrg(Deleted), "deleted")
+		require.False(t, ValidOrg(0), "zero")
+	})
+}
@@ -0,0 +1,148 @@
+/*
+Package org provides utilities to create, read, and update
+rows in the `orgs` database table.
+*/
+package org
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"grokloc.com/pkg/security/key"
+	"grokloc.com/pkg/user"
+)
+
+// exportPageSize is the number of members read per query by `Export`.
+const exportPageSize = 100
+
+// ExportBundle is the JSON document written by `Export`.
+type ExportBundle struct {
+	Org     ExportOrg      `json:"org"`
+	Members []ExportMember `json:"members"`
+}
+
+// ExportOrg is the org in an `ExportBundle`. Internal metadata such as
+// the signature and key version is left out.
+type ExportOrg struct {
+	ID           uuid.UUID `json:"id"`
+	Name         string    `json:"name"`
+	Owner        uuid.UUID `json:"owner"`
+	ExternalID   *string   `json:"external_id"`
+	DeactivateAt *int64    `json:"deactivate_at"`
+	Ctime        int64     `json:"ctime"`
+	Mtime        int64     `json:"mtime"`
+	Role         int       `json:"role"`
+	Status       int       `json:"status"`
+}
+
+// ExportMember is a user in an `ExportBundle`. PII is decrypted and
+// the password hash is left out.
+type ExportMember struct {
+	ID            uuid.UUID `json:"id"`
+	DisplayName   string    `json:"display_name"`
+	Ed25519Public string    `json:"ed25519_public"`
+	Email         string    `json:"email"`
+	Org           uuid.UUID `json:"org"`
+	Ctime         int64     `json:"ctime"`
+	Mtime         int64     `json:"mtime"`
+	Role          int       `json:"role"`
+	Status        int       `json:"status"`
+}
+
+// Export writes org `id` and all of its members to `w` as an
+// `ExportBundle` JSON document. Everything is read in one read-only
+// transaction for a consistent snapshot, and members are read and
+// written a page at a time so large orgs are not held in memory;
+// returning an `*ExportBundle` instead would require holding them all.
+func Export(
+	ctx context.Context,
+	conn *pgx.Conn,
+	m key.KeyProvider,
+	id uuid.UUID,
+	w io.Writer,
+) error {
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) // nolint:errcheck
+
+	org, err := Read(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	orgJSON, err := json.Marshal(ExportOrg{
+		ID:           org.ID,
+		Name:         org.Name,
+		Owner:        org.Owner,
+		ExternalID:   org.ExternalID,
+		DeactivateAt: org.DeactivateAt,
+		Ctime:        org.Ctime,
+		Mtime:        org.Mtime,
+		Role:         org.Role,
+		Status:       org.Status,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, `{"org":`+string(orgJSON)+`,"members":[`)
+	if err != nil {
+		return err
+	}
+
+	first := true
+	var after int64
+	for {
+		page, err := user.ListByOrg(ctx, tx, m, id, after, exportPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, u := range page.Items {
+			memberJSON, err := json.Marshal(ExportMember{
+				ID:            u.ID,
+				DisplayName:   u.DisplayName,
+				Ed25519Public: u.Ed25519Public,
+				Email:         u.Email,
+				Org:           u.Org,
+				Ctime:         u.Ctime,
+				Mtime:         u.Mtime,
+				Role:          u.Role,
+				Status:        u.Status,
+			})
+			if err != nil {
+				return err
+			}
+			if !first {
+				_, err = io.WriteString(w, ",")
+				if err != nil {
+					return err
+				}
+			}
+			first = false
+			_, err = w.Write(memberJSON)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !page.HasMore {
+			break
+		}
+		after = page.Next
+	}
+
+	_, err = io.WriteString(w, "]}")
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
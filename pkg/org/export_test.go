@@ -0,0 +1,208 @@
+/*
+Package org provides utilities to create, read, and update
+rows in the `orgs` database table.
+*/
+package org
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+	"grokloc.com/pkg/model/status"
+	"grokloc.com/pkg/user"
+)
+
+func TestExport(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org, owner := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			status.Active,
+		)
+		members := []*user.User{owner}
+		for range 2 {
+			members = append(members, user.ForTest(
+				context.Background(),
+				conn.Conn(),
+				*versionKey,
+				org.ID,
+				status.Active,
+			))
+		}
+
+		var buf bytes.Buffer
+		err = Export(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			org.ID,
+			&buf,
+		)
+		require.NoError(t, err, "export")
+
+		for _, member := range members {
+			require.NotContains(t, buf.String(), member.Password, "redacted")
+		}
+
+		var bundle ExportBundle
+		err = json.Unmarshal(buf.Bytes(), &bundle)
+		require.NoError(t, err, "unmarshal")
+		require.Equal(t, org.ID, bundle.Org.ID, "id")
+		require.Equal(t, org.Name, bundle.Org.Name, "name")
+		require.Equal(t, org.Owner, bundle.Org.Owner, "owner")
+		require.Equal(t, org.Status, bundle.Org.Status, "status")
+		require.Len(t, bundle.Members, len(members), "members")
+		for i, member := range members {
+			require.Equal(t, member.ID, bundle.Members[i].ID, "id")
+			require.Equal(t, member.DisplayName,
+				bundle.Members[i].DisplayName, "display name")
+			require.Equal(t, member.Email, bundle.Members[i].Email, "email")
+			require.Equal(t, member.Ed25519Public,
+				bundle.Members[i].Ed25519Public, "ed25519 public")
+		}
+	})
+
+	t.Run("Shredded", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org, _ := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			status.Active,
+		)
+		shredded := user.ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			org.ID,
+			status.Active,
+		)
+		email := shredded.Email
+		err = shredded.Shred(context.Background(), conn.Conn(), st.StatusSink, *versionKey)
+		require.NoError(t, err, "shred")
+
+		var buf bytes.Buffer
+		err = Export(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			org.ID,
+			&buf,
+		)
+		require.NoError(t, err, "export")
+		require.NotContains(t, buf.String(), email, "shredded email")
+
+		var bundle ExportBundle
+		err = json.Unmarshal(buf.Bytes(), &bundle)
+		require.NoError(t, err, "unmarshal")
+		require.Len(t, bundle.Members, 2, "members")
+		require.Equal(t, shredded.ID, bundle.Members[1].ID, "id")
+		require.Equal(t, status.Deleted, bundle.Members[1].Status, "status")
+	})
+
+	t.Run("Keys", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org, _ := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			status.Active,
+		)
+
+		var buf bytes.Buffer
+		err = Export(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			org.ID,
+			&buf,
+		)
+		require.NoError(t, err, "export")
+
+		var doc struct {
+			Org     map[string]json.RawMessage   `json:"org"`
+			Members []map[string]json.RawMessage `json:"members"`
+		}
+		err = json.Unmarshal(buf.Bytes(), &doc)
+		require.NoError(t, err, "unmarshal")
+
+		orgKeys := make([]string, 0, len(doc.Org))
+		for k := range doc.Org {
+			orgKeys = append(orgKeys, k)
+		}
+		require.ElementsMatch(t, []string{
+			"id",
+			"name",
+			"owner",
+			"external_id",
+			"deactivate_at",
+			"ctime",
+			"mtime",
+			"role",
+			"status",
+		}, orgKeys, "org keys")
+
+		require.Len(t, doc.Members, 1, "members")
+		memberKeys := make([]string, 0, len(doc.Members[0]))
+		for k := range doc.Members[0] {
+			memberKeys = append(memberKeys, k)
+		}
+		require.ElementsMatch(t, []string{
+			"id",
+			"display_name",
+			"ed25519_public",
+			"email",
+			"org",
+			"ctime",
+			"mtime",
+			"role",
+			"status",
+		}, memberKeys, "member keys")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.RandomReplica().Acquire(context.Background())
+		require.NoError(t, err, "replica conn")
+		defer conn.Release()
+
+		var buf bytes.Buffer
+		err = Export(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			uuid.New(),
+			&buf,
+		)
+		require.Error(t, err, "export")
+		require.Equal(t, pgx.ErrNoRows, err, "not found")
+	})
+}
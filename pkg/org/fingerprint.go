@@ -0,0 +1,35 @@
+/*
+Package org provides utilities to create, read, and update
+rows in the `orgs` database table.
+*/
+package org
+
+import (
+	"fmt"
+
+	"grokloc.com/pkg/security/digest"
+)
+
+// Canonical returns a stable serialization of the fields that define
+// `o`: id, name, owner, role, status, and schema version, one per line
+// in that order. Metadata like `Mtime` and `Signature` is excluded, so
+// equal canonical forms mean equal org state. `Name` is quoted so no
+// name can be confused with a field boundary.
+func (o *Org) Canonical() []byte {
+	return fmt.Appendf(nil,
+		"id=%s\nname=%q\nowner=%s\nrole=%d\nstatus=%d\nschema_version=%d\n",
+		o.ID,
+		o.Name,
+		o.Owner,
+		o.Role,
+		o.Status,
+		o.SchemaVersion,
+	)
+}
+
+// Fingerprint returns the hex-encoded sha256 digest of `o.Canonical()`.
+// It changes exactly when the canonical fields change, so clients can
+// compare fingerprints instead of orgs, and it is suitable as an ETag.
+func Fingerprint(o *Org) string {
+	return digest.SHA256Hex(string(o.Canonical()))
+}
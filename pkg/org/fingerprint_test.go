@@ -0,0 +1,49 @@
+/*
+Package org provides utilities to create, read, and update
+rows in the `orgs` database table.
+*/
+package org
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"grokloc.com/pkg/model/role"
+	"grokloc.com/pkg/model/status"
+)
+
+func TestFingerprint(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		o := &Org{
+			ID:            uuid.New(),
+			Name:          "org",
+			Owner:         uuid.New(),
+			Role:          role.Normal,
+			SchemaVersion: SchemaVersion,
+			Status:        status.Active,
+		}
+		fp := Fingerprint(o)
+		require.Len(t, fp, 64, "sha256 hex")
+
+		c := *o
+		c.Mtime = 1
+		c.Signature = uuid.New()
+		require.Equal(t, fp, Fingerprint(&c), "metadata excluded")
+
+		for name, mutate := range map[string]func(*Org){
+			"id":             func(o *Org) { o.ID = uuid.New() },
+			"name":           func(o *Org) { o.Name = "org2" },
+			"owner":          func(o *Org) { o.Owner = uuid.New() },
+			"role":           func(o *Org) { o.Role = role.Test },
+			"status":         func(o *Org) { o.Status = status.Inactive },
+			"schema_version": func(o *Org) { o.SchemaVersion++ },
+			"name boundary":  func(o *Org) { o.Name = "org\nowner=" + o.Owner.String() },
+		} {
+			c := *o
+			mutate(&c)
+			require.NotEqual(t, fp, Fingerprint(&c), name)
+		}
+	})
+}
@@ -6,12 +6,18 @@ package org
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
-	"grokloc.com/pkg/model/role"
+	"grokloc.com/pkg/cache"
+	"grokloc.com/pkg/model"
+	pkg_role "grokloc.com/pkg/model/role"
 	pkg_status "grokloc.com/pkg/model/status"
 	"grokloc.com/pkg/postgresql"
+	"grokloc.com/pkg/runtime"
 	"grokloc.com/pkg/security/ed25519"
 	"grokloc.com/pkg/security/key"
 	"grokloc.com/pkg/security/password"
@@ -22,102 +28,280 @@ const (
 	SchemaVersion = 0
 )
 
+var (
+	ErrOwnerNotFound  = errors.New("org owner not found")
+	ErrOwnerNotActive = errors.New("org owner not active")
+	ErrName           = errors.New("org name empty")
+	ErrRole           = errors.New("org role not valid")
+	ErrStatus         = errors.New("org status not valid")
+	ErrRequired       = errors.New("org required value missing")
+	ErrExternalID     = errors.New("org external id empty")
+	ErrNotFound       = errors.New("org not found")
+	ErrNotArchived    = errors.New("org not archived")
+)
+
+// InsertStage names the step of `Insert` that failed.
+type InsertStage string
+
+const (
+	InsertStageBegin  InsertStage = "begin"
+	InsertStageOwner  InsertStage = "owner"
+	InsertStageOrg    InsertStage = "org"
+	InsertStageCommit InsertStage = "commit"
+)
+
+// InsertError is returned by `Insert` and `Upsert` when a database step
+// fails, so callers can tell an owner conflict from an org conflict.
+type InsertError struct {
+	Stage InsertStage
+	Err   error
+}
+
+func (e *InsertError) Error() string {
+	return "org insert " + string(e.Stage) + ": " + e.Err.Error()
+}
+
+func (e *InsertError) Unwrap() error {
+	return e.Err
+}
+
 type Org struct {
-	ID    uuid.UUID `db:"id"` // Generated.
-	Name  string    `db:"name"`
-	Owner uuid.UUID `db:"owner"`
+	ID         uuid.UUID `db:"id"` // Generated.
+	Name       string    `db:"name"`
+	Owner      uuid.UUID `db:"owner"`
+	ExternalID *string   `db:"external_id"` // Optional.
+
+	// DeactivateAt is when `runtime.ProcessScheduled` sets the org
+	// inactive, if scheduled. Unixtime.
+	DeactivateAt *int64 `db:"deactivate_at"`
+
+	// KeyVersion is the key version its members were moved to by the
+	// last `Rekey`, if any.
+	KeyVersion *uuid.UUID `db:"key_version"`
 
 	// Metadata.
-	Ctime         int64     `db:"ctime"` // Unixtime.
-	Mtime         int64     `db:"mtime"` // Unixtime.
-	InsertOrder   int64     `db:"insert_order"`
-	Role          int       `db:"role"`
-	SchemaVersion int       `db:"schema_version"`
-	Signature     uuid.UUID `db:"signature"` // Generated.
-	Status        int       `db:"status"`
+	Ctime         int64      `db:"ctime"` // Unixtime.
+	Mtime         int64      `db:"mtime"` // Unixtime.
+	InsertOrder   int64      `db:"insert_order"`
+	Role          int        `db:"role"` // Org class, see `role.ValidOrg`.
+	SchemaVersion int        `db:"schema_version"`
+	Signature     uuid.UUID  `db:"signature"` // Generated.
+	Status        int        `db:"status"`
+	UpdatedBy     *uuid.UUID `db:"updated_by"` // Actor of the last Update*.
+}
+
+// CreateParams are the values needed to insert an org and its owner.
+type CreateParams struct {
+	Name               string
+	OwnerID            uuid.UUID // Optional, generated if `uuid.Nil`.
+	OwnerVersionKey    key.Versioned
+	OwnerDisplayName   string
+	OwnerEd25519Public string
+	OwnerEmail         string
+	OwnerPassword      string // Argon2 hash.
+	Role               int    // Org role; the owner gets `role.OrgOwner`.
+	Status             int
+
+	// Timeout, if positive, bounds the insert transaction, for
+	// example `State.ExecTimeout`. It is in addition to any deadline
+	// of the context passed to `Insert`.
+	Timeout time.Duration
 }
 
+// validate checks the params that can be checked without a database
+// round trip.
+func (p CreateParams) validate() error {
+	if p.Name == "" {
+		return ErrName
+	}
+	if !pkg_role.ValidOrg(p.Role) {
+		return ErrRole
+	}
+	if !pkg_status.ValidOrg(p.Status) {
+		return ErrStatus
+	}
+	return nil
+}
+
+// Insert adds a new Org and its owner to the database and returns them.
 func Insert(
 	ctx context.Context,
-	conn *pgx.Conn,
-	name string,
-	ownerVersionKey key.Versioned,
-	ownerDisplayName string,
-	ownerEd25519Public string,
-	ownerEmail string,
-	ownerPassword string,
-	role int,
-	schemaVersion int,
-	status int,
+	conn postgresql.DB,
+	params CreateParams,
+) (*Org, *user.User, error) {
+	return insert(ctx, conn, nil, params)
+}
+
+// Upsert returns the org with `externalID` and its owner if it exists,
+// or inserts it with `params` if not. The returned bool is true if the
+// org was inserted. Retrying an Upsert never creates a duplicate org.
+func Upsert(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	externalID string,
+	params CreateParams,
+) (*Org, *user.User, bool, error) {
+	if externalID == "" {
+		return nil, nil, false, ErrExternalID
+	}
+
+	org, owner, err := readByExternalID(ctx, conn, m, externalID)
+	if err == nil {
+		return org, owner, false, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, false, err
+	}
+
+	org, owner, err = insert(ctx, conn, &externalID, params)
+	if err == nil {
+		return org, owner, true, nil
+	}
+	if !postgresql.UniqueConstraint(err) {
+		return nil, nil, false, err
+	}
+
+	// A concurrent Upsert may have inserted the org first.
+	org, owner, readErr := readByExternalID(ctx, conn, m, externalID)
+	if readErr != nil {
+		return nil, nil, false, err
+	}
+	return org, owner, false, nil
+}
+
+func insert(
+	ctx context.Context,
+	conn postgresql.DB,
+	externalID *string,
+	params CreateParams,
 ) (*Org, *user.User, error) {
+	err := params.validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	id := uuid.New()
 
+	// Roll back with a context free of the timeout, so that the
+	// rollback still runs once the timeout expires.
+	rollbackCtx := context.WithoutCancel(ctx)
+	if params.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, params.Timeout)
+		defer cancel()
+	}
+
 	tx, err := conn.Begin(ctx)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, &InsertError{Stage: InsertStageBegin, Err: err}
+	}
+	defer tx.Rollback(rollbackCtx) // nolint:errcheck
+
+	ownerID := params.OwnerID
+	if ownerID == uuid.Nil {
+		ownerID = uuid.New()
 	}
-	defer tx.Rollback(ctx) // nolint:errcheck
 
-	// Owner is initially unconfirmed until org
-	// itself is inserted.
-	owner, err := user.Insert(
+	// The owner is inserted active: nothing outside the statement sees
+	// it before its org exists.
+	owner, values, err := user.PrepareInsert(
 		ctx,
-		conn,
-		ownerVersionKey,
-		ownerDisplayName,
-		ownerEd25519Public,
-		ownerEmail,
+		ownerID,
+		params.OwnerVersionKey,
+		params.OwnerDisplayName,
+		params.OwnerEd25519Public,
+		params.OwnerEmail,
 		id,
-		ownerPassword,
-		role,
+		params.OwnerPassword,
+		pkg_role.OrgOwner(params.Role),
 		user.SchemaVersion,
-		pkg_status.Unconfirmed,
+		pkg_status.Active,
 	)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, &InsertError{Stage: InsertStageOwner, Err: err}
 	}
 
+	// The owner and org are inserted in one statement. The org is
+	// selected from the owner's insert, so an owner id conflict inserts
+	// neither and returns no row.
 	const query = `
-	insert into orgs
-	(id, name, owner, role, schema_version, status)
-	values
-	($1, $2, $3, $4, $5, $6)
+	with owner as (
+		insert into users
+		(` + user.InsertColumns + `)
+		values
+		($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+		on conflict (id) do nothing
+		returning id, ctime, mtime, insert_order, signature
+	), o as (
+		insert into orgs
+		(id, name, owner, external_id, role, schema_version, status)
+		select $9::uuid, $14::text, owner.id, $15::text, $16::bigint, $17::bigint, $18::bigint
+		from owner
+		returning *
+	)
+	select o.*,
+		owner.ctime as owner_ctime,
+		owner.mtime as owner_mtime,
+		owner.insert_order as owner_insert_order,
+		owner.signature as owner_signature
+	from o, owner
 	`
-
-	result, err := tx.Exec(
-		ctx,
-		query,
-		id, name, owner.ID, role, SchemaVersion, status,
+	args := append(
+		values,
+		params.Name,
+		externalID,
+		params.Role,
+		SchemaVersion,
+		params.Status,
 	)
+	rows, err := tx.Query(ctx, query, args...)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, insertError(err)
 	}
-	if result.RowsAffected() != 1 {
-		return nil, nil, postgresql.ErrRowsAffected
+	type orgOwnerRow struct {
+		Org
+		OwnerCtime       int64     `db:"owner_ctime"`
+		OwnerMtime       int64     `db:"owner_mtime"`
+		OwnerInsertOrder int64     `db:"owner_insert_order"`
+		OwnerSignature   uuid.UUID `db:"owner_signature"`
 	}
-
-	org, err := Read(ctx, tx.Conn(), id)
+	row, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[orgOwnerRow])
 	if err != nil {
-		return nil, nil, err
-	}
-
-	// Now that org is inserted, make owner active.
-	err = owner.UpdateStatus(ctx, tx.Conn(), pkg_status.Active)
-	if err != nil {
-		return nil, nil, err
+		return nil, nil, insertError(err)
 	}
+	org := row.Org
+	owner.Ctime = row.OwnerCtime
+	owner.Mtime = row.OwnerMtime
+	owner.InsertOrder = row.OwnerInsertOrder
+	owner.Signature = row.OwnerSignature
 
 	err = tx.Commit(ctx)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, &InsertError{Stage: InsertStageCommit, Err: err}
 	}
 
-	return org, owner, nil
+	return &org, owner, nil
 }
 
+// insertError wraps an error from the statement of `insert` in an
+// `InsertError` whose stage is the table that caused it.
+func insertError(err error) error {
+	if table, ok := postgresql.TableName(err); errors.Is(err, pgx.ErrNoRows) || (ok && table == "users") {
+		return &InsertError{Stage: InsertStageOwner, Err: user.MapInsertError(err)}
+	}
+	// Values not caught by `validate`.
+	if postgresql.NotNullConstraint(err) {
+		err = fmt.Errorf("%w: %w", ErrRequired, err)
+	}
+	return &InsertError{Stage: InsertStageOrg, Err: err}
+}
+
+// Read selects the org with `id`. A row with an unknown status or role
+// returns a `*model.CorruptEnumError`.
 func Read(
 	ctx context.Context,
-	conn *pgx.Conn,
+	conn postgresql.DB,
 	id uuid.UUID,
 ) (*Org, error) {
 	const query = `select * from orgs where id = @id`
@@ -126,44 +310,727 @@ func Read(
 	if err != nil {
 		return nil, err
 	}
+	return collectOne(rows)
+}
+
+// collectOne collects the single row of a `select * from orgs` query
+// and checks its enums.
+func collectOne(rows pgx.Rows) (*Org, error) {
+	org, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Org])
+	if err != nil {
+		return nil, err
+	}
+
+	err = org.checkEnums()
+	if err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// ReadWithOwner returns org `id`, its owner, and its member count,
+// pipelined with `runtime.Pipeline` so the three queries take one
+// round trip. A missing org returns `ErrNotFound`, and a missing owner
+// `ErrOwnerNotFound`.
+func ReadWithOwner(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	id uuid.UUID,
+) (*Org, *user.User, int64, error) {
+	var org *Org
+	var owner *user.User
+	var members int64
+
+	err := runtime.Pipeline(ctx, conn, func(b *pgx.Batch) {
+		b.Queue(`select * from orgs where id = $1`, id).
+			Query(func(rows pgx.Rows) error {
+				var err error
+				org, err = collectOne(rows)
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ErrNotFound
+				}
+				return err
+			})
+		b.Queue(`select * from users
+			where id = (select owner from orgs where id = $1)`, id).
+			Query(func(rows pgx.Rows) error {
+				var err error
+				owner, err = user.CollectOne(rows, m)
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ErrOwnerNotFound
+				}
+				return err
+			})
+		b.Queue(`select count(*) from users where org = $1`, id).
+			QueryRow(func(row pgx.Row) error {
+				return row.Scan(&members)
+			})
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return org, owner, members, nil
+}
+
+// checkEnums returns a `*model.CorruptEnumError` if the status or role
+// of a row read from the db is not a known org constant.
+func (o *Org) checkEnums() error {
+	if !pkg_status.ValidOrg(o.Status) {
+		return &model.CorruptEnumError{Field: "status", Value: o.Status}
+	}
+	if !pkg_role.ValidOrg(o.Role) {
+		return &model.CorruptEnumError{Field: "role", Value: o.Role}
+	}
+	return nil
+}
+
+// Cache is a `runtime.OrgCache` of org rows. Only `ReadCached` reads
+// through it; `Read`, `ReadWithOwner`, `ReadReplica`, `ReadByExternalID`,
+// `List`, and `Export` always read the database. The org mutations and
+// `runtime.ProcessScheduled` take the cache and evict the orgs they
+// change. A nil Cache caches nothing.
+type Cache = cache.Cache[uuid.UUID, Org]
+
+// NewCache returns a `Cache` of up to `size` orgs, each kept for `ttl`.
+func NewCache(size int, ttl time.Duration) *Cache {
+	return cache.New[uuid.UUID, Org](size, ttl)
+}
+
+// ReadCached is `Read` through `c`. A miss reads from the database and
+// fills the cache; with a nil `c` it always reads from the database.
+// Every org mutation given the same `c` evicts the org, so the next
+// `ReadCached` sees it.
+//
+// Do not call it inside a transaction that may roll back, as it could
+// cache an uncommitted row.
+func ReadCached(
+	ctx context.Context,
+	conn postgresql.DB,
+	c *Cache,
+	id uuid.UUID,
+) (*Org, error) {
+	if org, ok := c.Get(id); ok {
+		return &org, nil
+	}
+
+	org, err := Read(ctx, conn, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache a copy so callers cannot modify the cached org.
+	c.Set(id, *org)
+	return org, nil
+}
+
+// ReadReplica is `Read` on a connection from `st.HealthyReplica`; it
+// never uses the master, and returns `runtime.ErrNoReplica` if no
+// replica is healthy. A miss, which may be replication lag, returns
+// `ErrNotFound`.
+func ReadReplica(
+	ctx context.Context,
+	st *runtime.State,
+	id uuid.UUID,
+) (*Org, error) {
+	replica, err := st.HealthyReplica()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := replica.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	org, err := Read(ctx, conn.Conn(), id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// ReadByExternalID selects the org with `externalID`, as set by `Upsert`.
+func ReadByExternalID(
+	ctx context.Context,
+	conn postgresql.DB,
+	externalID string,
+) (*Org, error) {
+	if externalID == "" {
+		return nil, ErrExternalID
+	}
+
+	const query = `select * from orgs where external_id = @external_id`
+	args := pgx.NamedArgs{"external_id": externalID}
+	rows, err := conn.Query(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
 
 	org, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Org])
 	if err != nil {
 		return nil, err
 	}
 
+	err = org.checkEnums()
+	if err != nil {
+		return nil, err
+	}
+
 	return &org, nil
 }
 
+func readByExternalID(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	externalID string,
+) (*Org, *user.User, error) {
+	org, err := ReadByExternalID(ctx, conn, externalID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owner, err := user.Read(ctx, conn, m, org.Owner)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return org, owner, nil
+}
+
+// List selects up to `limit` orgs with an insert order greater than
+// `afterInsertOrder`. Pass the returned `Next` as `afterInsertOrder`
+// to get the following page.
+func List(
+	ctx context.Context,
+	conn postgresql.DB,
+	afterInsertOrder int64,
+	limit int,
+) (*model.Page[Org], error) {
+	if limit < 1 {
+		return nil, model.ErrLimit
+	}
+
+	// Select one extra row to learn if there is a following page.
+	const query = `select * from orgs
+		where insert_order > @after
+		order by insert_order asc
+		limit @limit`
+	args := pgx.NamedArgs{
+		"after": afterInsertOrder,
+		"limit": limit + 1,
+	}
+	rows, err := conn.Query(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	orgs, err := pgx.CollectRows(rows, pgx.RowToStructByName[Org])
+	if err != nil {
+		return nil, err
+	}
+	for i := range orgs {
+		err = orgs[i].checkEnums()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return model.NewPage(orgs, afterInsertOrder, limit, func(o Org) int64 {
+		return o.InsertOrder
+	}), nil
+}
+
 func (o *Org) UpdateStatus(
 	ctx context.Context,
-	conn *pgx.Conn,
+	conn postgresql.DB,
+	l runtime.RateLimiter,
+	c *Cache,
+	actor uuid.UUID,
 	status int,
-) error {
+) (model.MutationResult, error) {
+	err := runtime.CheckRateLimit(ctx, l, o.ID)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+	// Evict even if the update fails; a miss only costs a read.
+	defer c.Delete(o.ID)
+
 	const query = `update orgs
-		set status = $1 
-		where id = $2
-		returning mtime, signature, status`
+		set status = $1,
+		updated_by = $2
+		where id = $3
+		returning mtime, signature, status, updated_by`
 
-	return conn.QueryRow(
+	err = conn.QueryRow(
 		ctx,
 		query,
 		status,
+		actor,
+		o.ID,
+	).
+		Scan(
+			&o.Mtime,
+			&o.Signature,
+			&o.Status,
+			&o.UpdatedBy,
+		)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+	return o.mutationResult(), nil
+}
+
+// mutationResult is the result of an update that changed the row.
+func (o *Org) mutationResult() model.MutationResult {
+	return model.MutationResult{
+		Modified:  true,
+		Mtime:     o.Mtime,
+		Signature: o.Signature,
+	}
+}
+
+// ScheduleDeactivation sets the org to be made inactive by
+// `runtime.ProcessScheduled` once `at` has passed, replacing any
+// earlier schedule.
+func (o *Org) ScheduleDeactivation(
+	ctx context.Context,
+	conn postgresql.DB,
+	l runtime.RateLimiter,
+	c *Cache,
+	actor uuid.UUID,
+	at time.Time,
+) (model.MutationResult, error) {
+	err := runtime.CheckRateLimit(ctx, l, o.ID)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+	defer c.Delete(o.ID)
+
+	const query = `update orgs
+		set deactivate_at = $1,
+		updated_by = $2
+		where id = $3
+		returning mtime, signature, deactivate_at, updated_by`
+
+	err = conn.QueryRow(
+		ctx,
+		query,
+		at.Unix(),
+		actor,
 		o.ID,
 	).
+		Scan(
+			&o.Mtime,
+			&o.Signature,
+			&o.DeactivateAt,
+			&o.UpdatedBy,
+		)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+	return o.mutationResult(), nil
+}
+
+// CancelDeactivation clears a deactivation scheduled with
+// `ScheduleDeactivation` that has not yet been processed.
+func (o *Org) CancelDeactivation(
+	ctx context.Context,
+	conn postgresql.DB,
+	l runtime.RateLimiter,
+	c *Cache,
+	actor uuid.UUID,
+) (model.MutationResult, error) {
+	err := runtime.CheckRateLimit(ctx, l, o.ID)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+	defer c.Delete(o.ID)
+
+	const query = `update orgs
+		set deactivate_at = null,
+		updated_by = $1
+		where id = $2
+		returning mtime, signature, deactivate_at, updated_by`
+
+	err = conn.QueryRow(ctx, query, actor, o.ID).
+		Scan(
+			&o.Mtime,
+			&o.Signature,
+			&o.DeactivateAt,
+			&o.UpdatedBy,
+		)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+	return o.mutationResult(), nil
+}
+
+// Archive sets the org to `status.Archived`, which disables it without
+// removing any data: it and its members can still be read, but
+// `auth.Authenticate` rejects its members. `Unarchive` reverses it.
+func (o *Org) Archive(
+	ctx context.Context,
+	conn postgresql.DB,
+	l runtime.RateLimiter,
+	c *Cache,
+	actor uuid.UUID,
+) (model.MutationResult, error) {
+	err := runtime.CheckRateLimit(ctx, l, o.ID)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+	defer c.Delete(o.ID)
+
+	const query = `update orgs
+		set status = $1,
+		updated_by = $2
+		where id = $3
+		returning mtime, signature, status, updated_by`
+
+	err = conn.QueryRow(ctx, query, pkg_status.Archived, actor, o.ID).
 		Scan(
 			&o.Mtime,
 			&o.Signature,
 			&o.Status,
+			&o.UpdatedBy,
 		)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+	return o.mutationResult(), nil
+}
+
+// Unarchive makes an org archived with `Archive` active again. An org
+// that is not archived returns `ErrNotArchived` and is unchanged.
+func (o *Org) Unarchive(
+	ctx context.Context,
+	conn postgresql.DB,
+	l runtime.RateLimiter,
+	c *Cache,
+	actor uuid.UUID,
+) (model.MutationResult, error) {
+	err := runtime.CheckRateLimit(ctx, l, o.ID)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+	defer c.Delete(o.ID)
+
+	const query = `update orgs
+		set status = $1,
+		updated_by = $2
+		where id = $3 and status = $4
+		returning mtime, signature, status, updated_by`
+
+	err = conn.QueryRow(ctx, query, pkg_status.Active, actor, o.ID, pkg_status.Archived).
+		Scan(
+			&o.Mtime,
+			&o.Signature,
+			&o.Status,
+			&o.UpdatedBy,
+		)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.MutationResult{}, ErrNotArchived
+	}
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+	return o.mutationResult(), nil
+}
+
+// Archived reports whether org `id` is archived. An org with no row is
+// not archived, so users created without one are unaffected.
+func Archived(
+	ctx context.Context,
+	conn postgresql.DB,
+	id uuid.UUID,
+) (bool, error) {
+	const query = `select status from orgs where id = $1`
+
+	var status int
+	err := conn.QueryRow(ctx, query, id).Scan(&status)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return status == pkg_status.Archived, nil
+}
+
+// Rekey re-encrypts the PII of every member of org `id` under
+// `newKey` and records its version as the org's `KeyVersion`, returning
+// the number of members changed. Members, with their secondary emails
+// and archived Ed25519 public keys, are decrypted with `m`. It is one
+// transaction, so an org is never left partially rotated. Shredded
+// members have no PII and are skipped.
+func Rekey(
+	ctx context.Context,
+	conn postgresql.DB,
+	c *Cache,
+	actor uuid.UUID,
+	m key.KeyProvider,
+	id uuid.UUID,
+	newKey key.Versioned,
+) (int, error) {
+	defer c.Delete(id)
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx) // nolint:errcheck
+
+	const orgQuery = `update orgs
+		set key_version = $1,
+		updated_by = $2
+		where id = $3`
+
+	result, err := tx.Exec(ctx, orgQuery, newKey.Version, actor, id)
+	if err != nil {
+		return 0, err
+	}
+	if result.RowsAffected() == 0 {
+		return 0, ErrNotFound
+	}
+
+	const membersQuery = `select id from users
+		where org = $1 and status != $2
+		order by insert_order
+		for update`
+
+	rows, err := tx.Query(ctx, membersQuery, id, pkg_status.Deleted)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return 0, err
+	}
+
+	for _, memberID := range ids {
+		member, err := user.Read(ctx, tx, m, memberID)
+		if err != nil {
+			return 0, err
+		}
+		// ReEncrypt needs a stored private key decrypted first.
+		if member.Ed25519PrivateEncrypted != nil {
+			_, err = member.Ed25519Private(m)
+			if err != nil {
+				return 0, err
+			}
+		}
+		err = member.ReEncrypt(ctx, tx, actor, m, newKey)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+// ValidateOwner returns `ErrOwnerNotFound` if the owner of org `id` has
+// no users row, or `ErrOwnerNotActive` if the owner is not active.
+func ValidateOwner(
+	ctx context.Context,
+	conn postgresql.DB,
+	id uuid.UUID,
+) error {
+	const query = `select u.id, u.status
+		from orgs o left join users u on u.id = o.owner
+		where o.id = $1`
+
+	var ownerID *uuid.UUID
+	var ownerStatus *int
+	err := conn.QueryRow(ctx, query, id).Scan(&ownerID, &ownerStatus)
+	if err != nil {
+		return err
+	}
+	if ownerID == nil {
+		return ErrOwnerNotFound
+	}
+	if *ownerStatus != pkg_status.Active {
+		return ErrOwnerNotActive
+	}
+
+	return nil
+}
+
+// Orphans returns the ids of orgs whose owner is missing or not active.
+func Orphans(
+	ctx context.Context,
+	conn postgresql.DB,
+) ([]uuid.UUID, error) {
+	const query = `select o.id
+		from orgs o left join users u on u.id = o.owner
+		where u.id is null or u.status != $1
+		order by o.insert_order`
+
+	rows, err := conn.Query(ctx, query, pkg_status.Active)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
+
+// OrgStats summarizes an org and its members.
+type OrgStats struct {
+	Status   int // Org status.
+	Members  int64
+	ByStatus map[int]int64 // Member status -> count.
+	ByRole   map[int]int64 // Member role -> count.
+}
+
+// Stats returns the member counts of org `id`, or `ErrNotFound`.
+func Stats(
+	ctx context.Context,
+	conn postgresql.DB,
+	id uuid.UUID,
+) (*OrgStats, error) {
+	stats := &OrgStats{
+		ByStatus: make(map[int]int64),
+		ByRole:   make(map[int]int64),
+	}
+
+	const orgQuery = `select status from orgs where id = $1`
+	err := conn.QueryRow(ctx, orgQuery, id).Scan(&stats.Status)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	const membersQuery = `select status, role, count(*)
+		from users
+		where org = $1
+		group by status, role`
+	rows, err := conn.Query(ctx, membersQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var memberStatus, memberRole int
+		var count int64
+		err = rows.Scan(&memberStatus, &memberRole, &count)
+		if err != nil {
+			return nil, err
+		}
+		stats.Members += count
+		stats.ByStatus[memberStatus] += count
+		stats.ByRole[memberRole] += count
+	}
+
+	return stats, rows.Err()
+}
+
+// EnsureByName returns the org named `name` and its owner, creating an
+// active test org as `ForTestWith` does if there is none. The returned
+// bool is true if the org was created. Like `Upsert`, a concurrent call
+// that creates the org first is not an error.
+func EnsureByName(
+	ctx context.Context,
+	conn postgresql.DB,
+	st *runtime.State,
+	name string,
+) (*Org, *user.User, bool, error) {
+	org, owner, err := readByName(ctx, conn, st.EncryptionKeys, name)
+	if err == nil {
+		return org, owner, false, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, false, err
+	}
+
+	ownerVersionKey, err := st.VersionedKey(st.EncryptionKeyVersion)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	ownerEd25519PublicPEM, _, err := ed25519.Random()
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	org, owner, err = Insert(ctx, conn, CreateParams{
+		Name:               name,
+		OwnerVersionKey:    *ownerVersionKey,
+		OwnerDisplayName:   uuid.NewString(),
+		OwnerEd25519Public: ownerEd25519PublicPEM,
+		OwnerEmail:         uuid.NewString(),
+		OwnerPassword:      password.Random(),
+		Role:               pkg_role.OrgTest,
+		Status:             pkg_status.Active,
+	})
+	if err == nil {
+		return org, owner, true, nil
+	}
+	if !postgresql.UniqueConstraint(err) {
+		return nil, nil, false, err
+	}
+
+	// A concurrent EnsureByName may have inserted the org first.
+	org, owner, readErr := readByName(ctx, conn, st.EncryptionKeys, name)
+	if readErr != nil {
+		return nil, nil, false, err
+	}
+	return org, owner, false, nil
+}
+
+func readByName(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	name string,
+) (*Org, *user.User, error) {
+	rows, err := conn.Query(ctx, `select * from orgs where name = $1`, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	org, err := collectOne(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owner, err := user.Read(ctx, conn, m, org.Owner)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return org, owner, nil
 }
 
 // ForTest creates a new instance of a Org for test automation only.
 func ForTest(
 	ctx context.Context,
-	conn *pgx.Conn,
+	conn postgresql.DB,
 	ownerVersionKey key.Versioned,
 	status int,
+) (*Org, *user.User) {
+	return ForTestWith(ctx, conn, ownerVersionKey, uuid.NewString(), status)
+}
+
+// ForTestWith is `ForTest` with a known org name, for tests that
+// assert on it. Owner values are still random.
+func ForTestWith(
+	ctx context.Context,
+	conn postgresql.DB,
+	ownerVersionKey key.Versioned,
+	name string,
+	status int,
 ) (*Org, *user.User) {
 	ownerEd25519PublicPEM, _, err := ed25519.Random()
 	if err != nil {
@@ -171,17 +1038,18 @@ func ForTest(
 	}
 
 	org, owner, err := Insert(
-		context.Background(),
+		ctx,
 		conn,
-		uuid.NewString(),
-		ownerVersionKey,
-		uuid.NewString(), // owner display name
-		ownerEd25519PublicPEM,
-		uuid.NewString(),  // owner email
-		password.Random(), // password
-		role.Test,
-		SchemaVersion,
-		status,
+		CreateParams{
+			Name:               name,
+			OwnerVersionKey:    ownerVersionKey,
+			OwnerDisplayName:   uuid.NewString(),
+			OwnerEd25519Public: ownerEd25519PublicPEM,
+			OwnerEmail:         uuid.NewString(),
+			OwnerPassword:      password.Random(),
+			Role:               pkg_role.OrgTest,
+			Status:             status,
+		},
 	)
 	if err != nil {
 		panic(err.Error())
@@ -6,19 +6,24 @@ package org
 
 import (
 	"context"
+	"errors"
 	"log"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/require"
+	"grokloc.com/pkg/model"
 	"grokloc.com/pkg/model/role"
 	"grokloc.com/pkg/model/status"
 	"grokloc.com/pkg/postgresql"
 	"grokloc.com/pkg/runtime"
 	"grokloc.com/pkg/security/ed25519"
 	"grokloc.com/pkg/security/password"
+	"grokloc.com/pkg/testsupport"
+	"grokloc.com/pkg/user"
 )
 
 var st *runtime.State
@@ -54,15 +59,16 @@ func TestInsert(t *testing.T) {
 		org, owner, err := Insert(
 			context.Background(),
 			conn.Conn(),
-			name,
-			*ownerVersionKey,
-			ownerDisplayName,
-			ownerEd25519PublicPEM,
-			ownerEmail,
-			ownerPassword,
-			role.Test,
-			SchemaVersion,
-			status.Active,
+			CreateParams{
+				Name:               name,
+				OwnerVersionKey:    *ownerVersionKey,
+				OwnerDisplayName:   ownerDisplayName,
+				OwnerEd25519Public: ownerEd25519PublicPEM,
+				OwnerEmail:         ownerEmail,
+				OwnerPassword:      ownerPassword,
+				Role:               role.OrgTest,
+				Status:             status.Active,
+			},
 		)
 
 		require.NoError(t, err, "insert")
@@ -82,7 +88,201 @@ func TestInsert(t *testing.T) {
 		require.True(t, before <= org.Mtime, "mtime")
 		require.True(t, org.InsertOrder > 0, "insert order")
 		require.Equal(t, org.Ctime, org.Mtime, "time")
-		require.Equal(t, role.Test, org.Role, "role")
+		require.Equal(t, role.OrgTest, org.Role, "role")
+	})
+
+	t.Run("OwnerID", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		params := func(ownerID uuid.UUID) CreateParams {
+			ownerEd25519PublicPEM, _, err := ed25519.Random()
+			require.NoError(t, err, "generate ed25519")
+			return CreateParams{
+				Name:               uuid.NewString(),
+				OwnerID:            ownerID,
+				OwnerVersionKey:    *ownerVersionKey,
+				OwnerDisplayName:   uuid.NewString(),
+				OwnerEd25519Public: ownerEd25519PublicPEM,
+				OwnerEmail:         uuid.NewString(),
+				OwnerPassword:      password.Random(),
+				Role:               role.OrgTest,
+				Status:             status.Active,
+			}
+		}
+
+		ownerID := uuid.New()
+		org, owner, err := Insert(
+			context.Background(),
+			conn.Conn(),
+			params(ownerID),
+		)
+		require.NoError(t, err, "insert")
+		require.Equal(t, ownerID, owner.ID, "owner id")
+		require.Equal(t, ownerID, org.Owner, "org owner")
+
+		_, _, err = Insert(
+			context.Background(),
+			conn.Conn(),
+			params(ownerID),
+		)
+		require.Error(t, err, "owner id conflict")
+		require.ErrorIs(t, err, user.ErrIDInUse, "err")
+		var insertErr *InsertError
+		require.ErrorAs(t, err, &insertErr, "insert err")
+		require.Equal(t, InsertStageOwner, insertErr.Stage, "stage")
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		t.Parallel()
+		lockConn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer lockConn.Release()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerEd25519PublicPEM, _, err := ed25519.Random()
+		require.NoError(t, err, "generate ed25519")
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		// An uncommitted org with the same name makes the insert wait
+		// on the unique index until the timeout expires.
+		name := uuid.NewString()
+		tx, err := lockConn.Conn().Begin(context.Background())
+		require.NoError(t, err, "begin")
+		defer tx.Rollback(context.Background()) // nolint:errcheck
+		_, err = tx.Exec(context.Background(),
+			`insert into orgs (name, owner, role, schema_version, status)
+			values ($1, $2, $3, $4, $5)`,
+			name, uuid.New(), role.OrgTest, SchemaVersion, status.Active)
+		require.NoError(t, err, "blocking insert")
+
+		ownerID := uuid.New()
+		timeout := 100 * time.Millisecond
+		start := time.Now()
+		_, _, err = Insert(
+			context.Background(),
+			conn.Conn(),
+			CreateParams{
+				Name:               name,
+				OwnerID:            ownerID,
+				OwnerVersionKey:    *ownerVersionKey,
+				OwnerDisplayName:   uuid.NewString(),
+				OwnerEd25519Public: ownerEd25519PublicPEM,
+				OwnerEmail:         uuid.NewString(),
+				OwnerPassword:      password.Random(),
+				Role:               role.OrgTest,
+				Status:             status.Active,
+				Timeout:            timeout,
+			},
+		)
+		require.Error(t, err, "insert")
+		require.True(t,
+			pgconn.Timeout(err) || errors.Is(err, context.DeadlineExceeded),
+			"timeout err: %v", err)
+		require.Less(t, time.Since(start), timeout+testsupport.CancelGrace, "prompt")
+
+		// The owner inserted before the timeout was rolled back.
+		checkConn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer checkConn.Release()
+		_, err = user.Read(
+			context.Background(),
+			checkConn.Conn(),
+			st.EncryptionKeys,
+			ownerID,
+		)
+		require.Equal(t, pgx.ErrNoRows, err, "no owner")
+	})
+
+	t.Run("TenantOwner", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerEd25519PublicPEM, _, err := ed25519.Random()
+		require.NoError(t, err, "generate ed25519")
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org, owner, err := Insert(
+			context.Background(),
+			conn.Conn(),
+			CreateParams{
+				Name:               uuid.NewString(),
+				OwnerVersionKey:    *ownerVersionKey,
+				OwnerDisplayName:   uuid.NewString(),
+				OwnerEd25519Public: ownerEd25519PublicPEM,
+				OwnerEmail:         uuid.NewString(),
+				OwnerPassword:      password.Random(),
+				Role:               role.OrgTenant,
+				Status:             status.Active,
+			},
+		)
+
+		require.NoError(t, err, "insert")
+		require.Equal(t, role.OrgTenant, org.Role, "org role")
+		require.Equal(t, role.Admin, owner.Role, "owner role")
+	})
+
+	t.Run("Role", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerEd25519PublicPEM, _, err := ed25519.Random()
+		require.NoError(t, err, "generate ed25519")
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		_, _, err = Insert(
+			context.Background(),
+			conn.Conn(),
+			CreateParams{
+				Name:               uuid.NewString(),
+				OwnerVersionKey:    *ownerVersionKey,
+				OwnerDisplayName:   uuid.NewString(),
+				OwnerEd25519Public: ownerEd25519PublicPEM,
+				OwnerEmail:         uuid.NewString(),
+				OwnerPassword:      password.Random(),
+				Role:               99,
+				Status:             status.Active,
+			},
+		)
+
+		require.Error(t, err, "insert")
+		require.Equal(t, ErrRole, err, "role err")
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		t.Parallel()
+		valid := CreateParams{
+			Name:   uuid.NewString(),
+			Role:   role.OrgTest,
+			Status: status.Active,
+		}
+		require.NoError(t, valid.validate(), "valid")
+
+		params := valid
+		params.Name = ""
+		require.Equal(t, ErrName, params.validate(), "name err")
+
+		params = valid
+		params.Role = 0
+		require.Equal(t, ErrRole, params.validate(), "role err")
+
+		params = valid
+		params.Status = status.Deleted
+		require.Equal(t, ErrStatus, params.validate(), "status err")
 	})
 
 	t.Run("Conflict", func(t *testing.T) {
@@ -102,15 +302,16 @@ func TestInsert(t *testing.T) {
 		_, _, err = Insert(
 			context.Background(),
 			conn.Conn(),
-			name,
-			*ownerVersionKey,
-			uuid.NewString(),
-			ownerEd25519PublicPEM,
-			uuid.NewString(),
-			uuid.NewString(),
-			role.Test,
-			SchemaVersion,
-			status.Active,
+			CreateParams{
+				Name:               name,
+				OwnerVersionKey:    *ownerVersionKey,
+				OwnerDisplayName:   uuid.NewString(),
+				OwnerEd25519Public: ownerEd25519PublicPEM,
+				OwnerEmail:         uuid.NewString(),
+				OwnerPassword:      uuid.NewString(),
+				Role:               role.OrgTest,
+				Status:             status.Active,
+			},
 		)
 
 		require.NoError(t, err, "insert")
@@ -118,19 +319,23 @@ func TestInsert(t *testing.T) {
 		_, _, err = Insert(
 			context.Background(),
 			conn.Conn(),
-			name,
-			*ownerVersionKey,
-			uuid.NewString(),
-			ownerEd25519PublicPEM,
-			uuid.NewString(),
-			uuid.NewString(),
-			role.Test,
-			SchemaVersion,
-			status.Active,
+			CreateParams{
+				Name:               name,
+				OwnerVersionKey:    *ownerVersionKey,
+				OwnerDisplayName:   uuid.NewString(),
+				OwnerEd25519Public: ownerEd25519PublicPEM,
+				OwnerEmail:         uuid.NewString(),
+				OwnerPassword:      uuid.NewString(),
+				Role:               role.OrgTest,
+				Status:             status.Active,
+			},
 		)
 
 		require.Error(t, err, "name conflict")
 		require.True(t, postgresql.UniqueConstraint(err), "err")
+		var insertErr *InsertError
+		require.ErrorAs(t, err, &insertErr, "insert err")
+		require.Equal(t, InsertStageOrg, insertErr.Stage, "stage")
 	})
 }
 
@@ -198,14 +403,19 @@ func TestUpdateStatus(t *testing.T) {
 		signature := org.Signature
 		require.Equal(t, status.Active, org.Status)
 
-		err = org.UpdateStatus(
+		actor := uuid.New()
+		_, err = org.UpdateStatus(
 			context.Background(),
 			conn.Conn(),
+			st.RateLimiter,
+			nil,
+			actor,
 			status.Inactive,
 		)
 
 		require.NoError(t, err, "update status")
 		require.Equal(t, status.Inactive, org.Status, "status")
+		require.Equal(t, &actor, org.UpdatedBy, "updated by")
 		require.True(t, mtime <= org.Mtime, "mtime")
 		require.NotEqual(t, signature, org.Signature, "signature")
 
@@ -219,6 +429,34 @@ func TestUpdateStatus(t *testing.T) {
 		require.Equal(t, *org, *readOrg, "round trip")
 	})
 
+	t.Run("NoRateLimiter", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org, _ := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*ownerVersionKey,
+			status.Active,
+		)
+
+		_, err = org.UpdateStatus(
+			context.Background(),
+			conn.Conn(),
+			nil,
+			nil,
+			uuid.New(),
+			status.Inactive,
+		)
+		require.Equal(t, runtime.ErrNoRateLimiter, err, "no limiter")
+		require.Equal(t, status.Active, org.Status, "status unchanged")
+	})
+
 	t.Run("BadStatus", func(t *testing.T) {
 		t.Parallel()
 		conn, err := st.RandomReplica().Acquire(context.Background())
@@ -236,13 +474,817 @@ func TestUpdateStatus(t *testing.T) {
 		)
 
 		status := org.Status
-		err = org.UpdateStatus(
+		actor := uuid.New()
+		_, err = org.UpdateStatus(
 			context.Background(),
 			conn.Conn(),
+			st.RateLimiter,
+			nil,
+			actor,
 			99,
 		)
 
 		require.Error(t, err, "update status")
 		require.Equal(t, status, org.Status, "status unchanged")
 	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		t.Parallel()
+		lockConn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer lockConn.Release()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org, _ := ForTest(
+			context.Background(),
+			lockConn.Conn(),
+			*versionKey,
+			status.Active,
+		)
+
+		// Hold a row lock so the update blocks until its context expires.
+		tx, err := testsupport.LockRow(
+			context.Background(),
+			lockConn.Conn(),
+			"orgs",
+			org.ID,
+		)
+		require.NoError(t, err, "lock row")
+		defer tx.Rollback(context.Background()) // nolint:errcheck
+
+		testsupport.AssertCancels(t, 100*time.Millisecond,
+			func(ctx context.Context) error {
+				_, err := org.UpdateStatus(ctx, conn.Conn(), st.RateLimiter, nil, uuid.New(), status.Inactive)
+				return err
+			})
+	})
+}
+
+func TestList(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org, _ := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*ownerVersionKey,
+			status.Active,
+		)
+
+		// Other tests may insert orgs concurrently, but none can be
+		// ordered between org and its predecessor.
+		page, err := List(
+			context.Background(),
+			conn.Conn(),
+			org.InsertOrder-1,
+			1,
+		)
+
+		require.NoError(t, err, "list")
+		require.Len(t, page.Items, 1, "items")
+		require.Equal(t, *org, page.Items[0], "org")
+		require.Equal(t, org.InsertOrder, page.Next, "next")
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.RandomReplica().Acquire(context.Background())
+		require.NoError(t, err, "replica conn")
+		defer conn.Release()
+
+		_, err = List(
+			context.Background(),
+			conn.Conn(),
+			0,
+			0,
+		)
+
+		require.Error(t, err, "zero limit")
+		require.Equal(t, model.ErrLimit, err, "limit err")
+	})
+}
+
+func TestValidateOwner(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org, _ := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*ownerVersionKey,
+			status.Active,
+		)
+
+		err = ValidateOwner(context.Background(), conn.Conn(), org.ID)
+		require.NoError(t, err, "validate owner")
+
+		orphans, err := Orphans(context.Background(), conn.Conn())
+		require.NoError(t, err, "orphans")
+		require.NotContains(t, orphans, org.ID, "not orphan")
+	})
+
+	t.Run("NotActive", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org, owner := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*ownerVersionKey,
+			status.Active,
+		)
+
+		actor := uuid.New()
+		_, err = owner.UpdateStatus(
+			context.Background(),
+			conn.Conn(),
+			st.StatusSink,
+			actor,
+			status.Inactive,
+		)
+		require.NoError(t, err, "update owner status")
+
+		err = ValidateOwner(context.Background(), conn.Conn(), org.ID)
+		require.Error(t, err, "validate owner")
+		require.Equal(t, ErrOwnerNotActive, err, "not active")
+
+		orphans, err := Orphans(context.Background(), conn.Conn())
+		require.NoError(t, err, "orphans")
+		require.Contains(t, orphans, org.ID, "orphan")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org, owner := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*ownerVersionKey,
+			status.Active,
+		)
+
+		_, err = conn.Exec(
+			context.Background(),
+			`delete from users where id = $1`,
+			owner.ID,
+		)
+		require.NoError(t, err, "delete owner")
+
+		err = ValidateOwner(context.Background(), conn.Conn(), org.ID)
+		require.Error(t, err, "validate owner")
+		require.Equal(t, ErrOwnerNotFound, err, "not found")
+
+		orphans, err := Orphans(context.Background(), conn.Conn())
+		require.NoError(t, err, "orphans")
+		require.Contains(t, orphans, org.ID, "orphan")
+	})
+
+	t.Run("OrgNotFound", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.RandomReplica().Acquire(context.Background())
+		require.NoError(t, err, "replica conn")
+		defer conn.Release()
+
+		err = ValidateOwner(context.Background(), conn.Conn(), uuid.New())
+		require.Error(t, err, "validate owner")
+		require.Equal(t, pgx.ErrNoRows, err, "not found")
+	})
+}
+
+func TestUpsert(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		params := func() CreateParams {
+			ownerEd25519PublicPEM, _, err := ed25519.Random()
+			require.NoError(t, err, "generate ed25519")
+			return CreateParams{
+				Name:               uuid.NewString(),
+				OwnerVersionKey:    *ownerVersionKey,
+				OwnerDisplayName:   uuid.NewString(),
+				OwnerEd25519Public: ownerEd25519PublicPEM,
+				OwnerEmail:         uuid.NewString(),
+				OwnerPassword:      password.Random(),
+				Role:               role.OrgTest,
+				Status:             status.Active,
+			}
+		}
+
+		externalID := uuid.NewString()
+		org, owner, created, err := Upsert(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			externalID,
+			params(),
+		)
+
+		require.NoError(t, err, "create")
+		require.True(t, created, "created")
+		require.NotNil(t, org.ExternalID, "external id")
+		require.Equal(t, externalID, *org.ExternalID, "external id")
+		require.Equal(t, owner.ID, org.Owner, "owner")
+
+		// A retry with other params returns the existing org.
+		existingOrg, existingOwner, created, err := Upsert(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			externalID,
+			params(),
+		)
+
+		require.NoError(t, err, "fetch")
+		require.False(t, created, "fetched")
+		require.Equal(t, *org, *existingOrg, "org")
+		require.Equal(t, *owner, *existingOwner, "owner")
+	})
+
+	t.Run("ExternalID", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		_, _, _, err = Upsert(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			"",
+			CreateParams{},
+		)
+
+		require.Error(t, err, "empty external id")
+		require.Equal(t, ErrExternalID, err, "external id err")
+	})
+}
+
+func TestForTestWith(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+		name := uuid.NewString()
+		org, owner := ForTestWith(
+			context.Background(),
+			conn.Conn(),
+			*ownerVersionKey,
+			name,
+			status.Active,
+		)
+
+		require.Equal(t, name, org.Name, "name")
+		require.Equal(t, status.Active, org.Status, "status")
+		require.Equal(t, owner.ID, org.Owner, "owner")
+	})
+}
+
+func TestReadReplica(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+		org, _ := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*ownerVersionKey,
+			status.Active,
+		)
+
+		readOrg, err := ReadReplica(context.Background(), st, org.ID)
+		require.NoError(t, err, "read replica")
+		require.Equal(t, *org, *readOrg, "round trip")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		t.Parallel()
+		_, err := ReadReplica(context.Background(), st, uuid.New())
+		require.Error(t, err, "read replica")
+		require.Equal(t, ErrNotFound, err, "not found")
+	})
+}
+
+func TestReadCached(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+		org, _ := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*ownerVersionKey,
+			status.Active,
+		)
+
+		c := NewCache(10, time.Minute)
+		ctx := context.Background()
+		readOrg, err := ReadCached(ctx, conn.Conn(), c, org.ID)
+		require.NoError(t, err, "read cached")
+		require.Equal(t, *org, *readOrg, "round trip")
+		require.Equal(t, 1, c.Len(), "cached")
+
+		// Modifying a returned org does not modify the cache.
+		readOrg.Name = ""
+		readOrg, err = ReadCached(ctx, conn.Conn(), c, org.ID)
+		require.NoError(t, err, "read cached")
+		require.Equal(t, *org, *readOrg, "cached copy")
+
+		actor := uuid.New()
+		_, err = readOrg.UpdateStatus(ctx, conn.Conn(), st.RateLimiter, c, actor, status.Inactive)
+		require.NoError(t, err, "update status")
+		require.Equal(t, 0, c.Len(), "invalidated")
+
+		readOrg, err = ReadCached(ctx, conn.Conn(), c, org.ID)
+		require.NoError(t, err, "read cached")
+		require.Equal(t, status.Inactive, readOrg.Status, "status")
+
+		_, err = readOrg.Archive(ctx, conn.Conn(), st.RateLimiter, c, uuid.New())
+		require.NoError(t, err, "archive")
+		require.Equal(t, 0, c.Len(), "invalidated")
+
+		readOrg, err = ReadCached(ctx, conn.Conn(), c, org.ID)
+		require.NoError(t, err, "read cached")
+		require.Equal(t, status.Archived, readOrg.Status, "status")
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+		org, _ := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*ownerVersionKey,
+			status.Active,
+		)
+
+		readOrg, err := ReadCached(context.Background(), conn.Conn(), nil, org.ID)
+		require.NoError(t, err, "read cached")
+		require.Equal(t, *org, *readOrg, "round trip")
+	})
+}
+
+func TestStats(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+		org, _ := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			status.Active,
+		)
+		for _, memberStatus := range []int{status.Active, status.Inactive} {
+			_ = user.ForTest(
+				context.Background(),
+				conn.Conn(),
+				*versionKey,
+				org.ID,
+				memberStatus,
+			)
+		}
+
+		stats, err := Stats(context.Background(), conn.Conn(), org.ID)
+		require.NoError(t, err, "stats")
+		require.Equal(t, status.Active, stats.Status, "org status")
+		require.Equal(t, int64(3), stats.Members, "members")
+		require.Equal(t,
+			map[int]int64{status.Active: 2, status.Inactive: 1},
+			stats.ByStatus,
+			"by status")
+		require.Equal(t, map[int]int64{role.Test: 3}, stats.ByRole, "by role")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.RandomReplica().Acquire(context.Background())
+		require.NoError(t, err, "replica conn")
+		defer conn.Release()
+
+		_, err = Stats(context.Background(), conn.Conn(), uuid.New())
+		require.Error(t, err, "stats")
+		require.Equal(t, ErrNotFound, err, "not found")
+	})
+}
+
+func TestReadByExternalID(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerEd25519PublicPEM, _, err := ed25519.Random()
+		require.NoError(t, err, "generate ed25519")
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		externalID := uuid.NewString()
+		org, _, _, err := Upsert(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			externalID,
+			CreateParams{
+				Name:               uuid.NewString(),
+				OwnerVersionKey:    *ownerVersionKey,
+				OwnerDisplayName:   uuid.NewString(),
+				OwnerEd25519Public: ownerEd25519PublicPEM,
+				OwnerEmail:         uuid.NewString(),
+				OwnerPassword:      password.Random(),
+				Role:               role.OrgTest,
+				Status:             status.Active,
+			},
+		)
+		require.NoError(t, err, "upsert")
+
+		readOrg, err := ReadByExternalID(context.Background(), conn.Conn(), externalID)
+		require.NoError(t, err, "read")
+		require.Equal(t, *org, *readOrg, "round trip")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.RandomReplica().Acquire(context.Background())
+		require.NoError(t, err, "replica conn")
+		defer conn.Release()
+
+		_, err = ReadByExternalID(context.Background(), conn.Conn(), uuid.NewString())
+		require.Error(t, err, "read")
+		require.Equal(t, pgx.ErrNoRows, err, "not found")
+
+		_, err = ReadByExternalID(context.Background(), conn.Conn(), "")
+		require.Error(t, err, "empty")
+		require.Equal(t, ErrExternalID, err, "external id err")
+	})
+}
+
+func TestScheduleDeactivation(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+		due, _ := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			status.Active,
+		)
+		later, _ := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			status.Active,
+		)
+		cancelled, _ := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			status.Active,
+		)
+
+		at := time.Now().Add(-time.Minute)
+		result, err := due.ScheduleDeactivation(context.Background(), conn.Conn(), st.RateLimiter, nil, uuid.New(), at)
+		require.NoError(t, err, "schedule due")
+		require.True(t, result.Modified, "modified")
+		require.Equal(t, due.Signature, result.Signature, "signature")
+		require.Equal(t, at.Unix(), *due.DeactivateAt, "deactivate at")
+
+		_, err = later.ScheduleDeactivation(context.Background(), conn.Conn(), st.RateLimiter, nil, uuid.New(),
+			time.Now().Add(time.Hour))
+		require.NoError(t, err, "schedule later")
+
+		_, err = cancelled.ScheduleDeactivation(context.Background(), conn.Conn(), st.RateLimiter, nil, uuid.New(), at)
+		require.NoError(t, err, "schedule cancelled")
+		result, err = cancelled.CancelDeactivation(context.Background(), conn.Conn(), st.RateLimiter, nil, uuid.New())
+		require.NoError(t, err, "cancel")
+		require.Equal(t, cancelled.Mtime, result.Mtime, "mtime")
+		require.Nil(t, cancelled.DeactivateAt, "cancelled")
+
+		c := NewCache(10, time.Minute)
+		_, err = ReadCached(context.Background(), conn.Conn(), c, due.ID)
+		require.NoError(t, err, "read cached")
+
+		n, err := runtime.ProcessScheduled(context.Background(), conn.Conn(), c)
+		require.NoError(t, err, "process")
+		require.GreaterOrEqual(t, n, int64(1), "processed")
+		_, ok := c.Get(due.ID)
+		require.False(t, ok, "due evicted")
+
+		readDue, err := Read(context.Background(), conn.Conn(), due.ID)
+		require.NoError(t, err, "read due")
+		require.Equal(t, status.Inactive, readDue.Status, "due status")
+		require.Nil(t, readDue.DeactivateAt, "due cleared")
+
+		readLater, err := Read(context.Background(), conn.Conn(), later.ID)
+		require.NoError(t, err, "read later")
+		require.Equal(t, status.Active, readLater.Status, "later status")
+		require.Equal(t, later.DeactivateAt, readLater.DeactivateAt, "later scheduled")
+
+		readCancelled, err := Read(context.Background(), conn.Conn(), cancelled.ID)
+		require.NoError(t, err, "read cancelled")
+		require.Equal(t, status.Active, readCancelled.Status, "cancelled status")
+	})
+}
+
+func TestCheckEnums(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		o := Org{Status: status.Active, Role: role.OrgTenant}
+		require.NoError(t, o.checkEnums(), "valid")
+
+		// Deleted is a user status only.
+		o.Status = status.Deleted
+		err := o.checkEnums()
+		require.ErrorIs(t, err, model.ErrCorruptEnum, "status")
+		require.Equal(t, &model.CorruptEnumError{Field: "status", Value: status.Deleted}, err, "status field")
+
+		o.Status = status.Active
+		o.Role = 99
+		err = o.checkEnums()
+		require.Equal(t, &model.CorruptEnumError{Field: "role", Value: 99}, err, "role field")
+	})
+}
+
+func TestRekey(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+		org, owner := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			status.Active,
+		)
+		member := user.ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			org.ID,
+			status.Active,
+		)
+		shredded := user.ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			org.ID,
+			status.Active,
+		)
+		err = shredded.Shred(context.Background(), conn.Conn(), st.StatusSink, *versionKey)
+		require.NoError(t, err, "shred")
+
+		var newKeyVersion uuid.UUID
+		for keyVersion := range st.EncryptionKeys {
+			if keyVersion != versionKey.Version {
+				newKeyVersion = keyVersion
+				break
+			}
+		}
+		newKey, err := st.EncryptionKeys.Get(newKeyVersion)
+		require.NoError(t, err, "newKey")
+
+		n, err := Rekey(
+			context.Background(),
+			conn.Conn(),
+			nil,
+			uuid.New(),
+			st.EncryptionKeys,
+			org.ID,
+			*newKey,
+		)
+		require.NoError(t, err, "rekey")
+		require.Equal(t, 2, n, "members")
+
+		readOrg, err := Read(context.Background(), conn.Conn(), org.ID)
+		require.NoError(t, err, "read org")
+		require.Equal(t, &newKeyVersion, readOrg.KeyVersion, "org key version")
+
+		for _, u := range []*user.User{owner, member} {
+			readUser, err := user.Read(
+				context.Background(),
+				conn.Conn(),
+				st.EncryptionKeys,
+				u.ID,
+			)
+			require.NoError(t, err, "read user")
+			require.Equal(t, newKeyVersion, readUser.KeyVersion, "user key version")
+			require.Equal(t, u.Email, readUser.Email, "email")
+		}
+
+		_, err = Rekey(
+			context.Background(),
+			conn.Conn(),
+			nil,
+			uuid.New(),
+			st.EncryptionKeys,
+			uuid.New(),
+			*newKey,
+		)
+		require.Equal(t, ErrNotFound, err, "missing org")
+	})
+}
+
+func TestArchive(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org, owner := ForTest(context.Background(), conn.Conn(), *ownerVersionKey, status.Active)
+
+		result, err := org.Unarchive(context.Background(), conn.Conn(), st.RateLimiter, nil, uuid.New())
+		require.Equal(t, ErrNotArchived, err, "not archived")
+		require.False(t, result.Modified, "not modified")
+
+		actor := uuid.New()
+		result, err = org.Archive(context.Background(), conn.Conn(), st.RateLimiter, nil, actor)
+		require.NoError(t, err, "archive")
+		require.True(t, result.Modified, "modified")
+		require.Equal(t, org.Signature, result.Signature, "signature")
+		require.Equal(t, status.Archived, org.Status, "status")
+		require.Equal(t, &actor, org.UpdatedBy, "updated by")
+
+		// Archived orgs and their members are still readable.
+		readOrg, err := Read(context.Background(), conn.Conn(), org.ID)
+		require.NoError(t, err, "read")
+		require.Equal(t, status.Archived, readOrg.Status, "read status")
+		_, err = user.Read(context.Background(), conn.Conn(), st.EncryptionKeys, owner.ID)
+		require.NoError(t, err, "read owner")
+
+		archived, err := Archived(context.Background(), conn.Conn(), org.ID)
+		require.NoError(t, err, "archived")
+		require.True(t, archived, "is archived")
+
+		result, err = org.Unarchive(context.Background(), conn.Conn(), st.RateLimiter, nil, uuid.New())
+		require.NoError(t, err, "unarchive")
+		require.True(t, result.Modified, "modified")
+		require.Equal(t, org.Mtime, result.Mtime, "mtime")
+		require.Equal(t, status.Active, org.Status, "active")
+
+		archived, err = Archived(context.Background(), conn.Conn(), org.ID)
+		require.NoError(t, err, "archived")
+		require.False(t, archived, "not archived")
+
+		archived, err = Archived(context.Background(), conn.Conn(), uuid.New())
+		require.NoError(t, err, "missing org")
+		require.False(t, archived, "missing org not archived")
+	})
+}
+
+func TestReadWithOwner(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org, owner := ForTest(context.Background(), conn.Conn(), *ownerVersionKey, status.Active)
+		_ = user.ForTest(context.Background(), conn.Conn(), *ownerVersionKey, org.ID, status.Active)
+
+		readOrg, readOwner, members, err := ReadWithOwner(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			org.ID,
+		)
+		require.NoError(t, err, "read with owner")
+		require.Equal(t, org, readOrg, "org")
+		require.Equal(t, owner.ID, readOwner.ID, "owner")
+		require.Equal(t, owner.Email, readOwner.Email, "owner decrypted")
+		require.Equal(t, int64(2), members, "members")
+
+		_, _, _, err = ReadWithOwner(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			uuid.New(),
+		)
+		require.Equal(t, ErrNotFound, err, "missing org")
+	})
+}
+
+// BenchmarkReadWithOwner compares the pipelined `ReadWithOwner` with
+// the same three queries sent one after another. The difference grows
+// with the round trip time, so point `POSTGRES_APP_URL` at a remote
+// database to see it.
+func BenchmarkReadWithOwner(b *testing.B) {
+	conn, err := st.Master.Acquire(context.Background())
+	require.NoError(b, err, "master conn")
+	defer conn.Release()
+
+	ownerVersionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+	require.NoError(b, err, "versionKey")
+	org, _ := ForTest(context.Background(), conn.Conn(), *ownerVersionKey, status.Active)
+
+	b.Run("Pipelined", func(b *testing.B) {
+		for b.Loop() {
+			_, _, _, err := ReadWithOwner(context.Background(), conn.Conn(), st.EncryptionKeys, org.ID)
+			require.NoError(b, err, "read with owner")
+		}
+	})
+
+	b.Run("Sequential", func(b *testing.B) {
+		for b.Loop() {
+			o, err := Read(context.Background(), conn.Conn(), org.ID)
+			require.NoError(b, err, "read")
+			_, err = user.Read(context.Background(), conn.Conn(), st.EncryptionKeys, o.Owner)
+			require.NoError(b, err, "read owner")
+			var members int64
+			err = conn.QueryRow(context.Background(), `select count(*) from users where org = $1`, o.ID).Scan(&members)
+			require.NoError(b, err, "members")
+		}
+	})
+}
+
+func TestEnsureByName(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		name := uuid.NewString()
+		org, owner, created, err := EnsureByName(context.Background(), conn.Conn(), st, name)
+		require.NoError(t, err, "create")
+		require.True(t, created, "created")
+		require.Equal(t, name, org.Name, "name")
+		require.Equal(t, org.Owner, owner.ID, "owner")
+
+		again, againOwner, created, err := EnsureByName(context.Background(), conn.Conn(), st, name)
+		require.NoError(t, err, "existing")
+		require.False(t, created, "not created")
+		require.Equal(t, org, again, "same org")
+		require.Equal(t, owner.ID, againOwner.ID, "same owner")
+	})
 }
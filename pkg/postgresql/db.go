@@ -0,0 +1,28 @@
+/*
+Package postgresql provides utilties for decoding errors.
+*/
+package postgresql
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// DB is the part of a connection the models use. `*pgx.Conn`,
+// `pgx.Tx`, and `*pgxpool.Pool` satisfy it, so model functions can run
+// inside a caller's transaction, and tests can substitute a mock to
+// reach error paths that are hard to trigger against Postgres.
+type DB interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
+var (
+	_ DB = (*pgx.Conn)(nil)
+	_ DB = (pgx.Tx)(nil)
+)
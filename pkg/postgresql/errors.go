@@ -32,3 +32,34 @@ func NotNullConstraint(err error) bool {
 	}
 	return false
 }
+
+// SequenceExhausted will try to match the db error for a sequence, such
+// as an identity column's, that has reached its maximum value.
+func SequenceExhausted(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// https://www.postgresql.org/docs/current/errcodes-appendix.html
+		return pgErr.Code == "2200H"
+	}
+	return false
+}
+
+// ConstraintName returns the name of the constraint violated by `err`,
+// if it is a db error naming one.
+func ConstraintName(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.ConstraintName != "" {
+		return pgErr.ConstraintName, true
+	}
+	return "", false
+}
+
+// TableName returns the name of the table of the row that violated a
+// constraint in `err`, if it is a db error naming one.
+func TableName(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.TableName != "" {
+		return pgErr.TableName, true
+	}
+	return "", false
+}
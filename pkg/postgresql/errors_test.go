@@ -0,0 +1,56 @@
+/*
+Package postgresql provides utilties for decoding errors.
+*/
+package postgresql
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConstraintName(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		pgErr := &pgconn.PgError{Code: "23505", ConstraintName: "users_email_digest_org"}
+		name, ok := ConstraintName(fmt.Errorf("insert: %w", pgErr))
+		require.True(t, ok, "wrapped")
+		require.Equal(t, "users_email_digest_org", name, "name")
+		require.True(t, UniqueConstraint(pgErr), "unique")
+
+		_, ok = ConstraintName(&pgconn.PgError{Code: "57014"})
+		require.False(t, ok, "no constraint")
+
+		_, ok = ConstraintName(errors.New("not a db error"))
+		require.False(t, ok, "not db")
+	})
+}
+
+func TestTableName(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		pgErr := &pgconn.PgError{Code: "23505", TableName: "users"}
+		name, ok := TableName(fmt.Errorf("insert: %w", pgErr))
+		require.True(t, ok, "wrapped")
+		require.Equal(t, "users", name, "name")
+
+		_, ok = TableName(&pgconn.PgError{Code: "57014"})
+		require.False(t, ok, "no table")
+
+		_, ok = TableName(errors.New("not a db error"))
+		require.False(t, ok, "not db")
+	})
+}
+
+func TestSequenceExhausted(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		pgErr := &pgconn.PgError{Code: "2200H"}
+		require.True(t, SequenceExhausted(fmt.Errorf("insert: %w", pgErr)), "wrapped")
+		require.False(t, SequenceExhausted(&pgconn.PgError{Code: "23505"}), "other code")
+		require.False(t, SequenceExhausted(errors.New("not a db error")), "not db")
+	})
+}
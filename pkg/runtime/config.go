@@ -0,0 +1,166 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	ConnTimeoutEnvKey      = "CONN_TIMEOUT"
+	ExecTimeoutEnvKey      = "EXEC_TIMEOUT"
+	Argon2TimeCostEnvKey   = "ARGON2_TIME_COST"
+	Argon2MemoryCostEnvKey = "ARGON2_MEMORY_COST"
+	LogLevelEnvKey         = "LOG_LEVEL"
+
+	DefaultConnTimeout = 1000 * time.Millisecond
+	DefaultExecTimeout = 1000 * time.Millisecond
+	DefaultLogLevel    = slog.LevelError
+)
+
+var ErrLogLevel = errors.New("log level not recognized")
+
+// Config holds the settings read from the environment. Encryption and
+// signing keys are not configured here; each level provides its own.
+type Config struct {
+	// Level is from `LEVEL`; it is optional here and required by `New`.
+	Level string
+
+	// PostgresAppURL is from `POSTGRES_APP_URL`; required.
+	PostgresAppURL string
+
+	// RepositoryBase is from `REPOSITORY_BASE`; required, and must exist.
+	RepositoryBase string
+
+	// StatementCacheMode is from `STATEMENT_CACHE_MODE`; defaults to
+	// "prepare". See `State.StatementCacheMode`.
+	StatementCacheMode pgx.QueryExecMode
+
+	// ConnTimeout and ExecTimeout are from `CONN_TIMEOUT` and
+	// `EXEC_TIMEOUT` in `time.ParseDuration` format.
+	ConnTimeout time.Duration
+	ExecTimeout time.Duration
+
+	// Argon2TimeCost and Argon2MemoryCost are from `ARGON2_TIME_COST`
+	// and `ARGON2_MEMORY_COST`. Zero keeps the level's default.
+	Argon2TimeCost   uint32
+	Argon2MemoryCost uint32
+
+	// LogLevel is from `LOG_LEVEL`; defaults to `DefaultLogLevel`.
+	// See `ParseLogLevel`.
+	LogLevel slog.Level
+}
+
+// ParseLogLevel maps "debug", "info", "warn", and "error" to their
+// `slog.Level`. Anything else returns `ErrLogLevel`.
+func ParseLogLevel(s string) (slog.Level, error) {
+	switch s {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, ErrLogLevel
+	}
+}
+
+// LoadConfig reads and validates all settings. If any are missing or
+// malformed, the returned error joins one `ErrEnvVar` per variable.
+func LoadConfig() (*Config, error) {
+	cfg := &Config{
+		StatementCacheMode: pgx.QueryExecModeCacheStatement,
+		ConnTimeout:        DefaultConnTimeout,
+		ExecTimeout:        DefaultExecTimeout,
+		LogLevel:           DefaultLogLevel,
+	}
+	var errs []error
+	envErr := func(k string) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrEnvVar, k))
+	}
+
+	cfg.Level = os.Getenv(LevelEnvKey)
+
+	dbUrl, dbUrlOK := os.LookupEnv(PostgresAppUrlEnvKey)
+	if !dbUrlOK {
+		envErr(PostgresAppUrlEnvKey)
+	} else if _, err := pgxpool.ParseConfig(dbUrl); err != nil {
+		// The parse error may contain the password.
+		errs = append(errs, fmt.Errorf("%w: %s: %w",
+			ErrEnvVar, PostgresAppUrlEnvKey, redactError(dbUrl, err)))
+	}
+	cfg.PostgresAppURL = dbUrl
+
+	repositoryBase, repositoryBaseOK := os.LookupEnv(RepositoryBaseEnvKey)
+	if _, err := os.Stat(repositoryBase); !repositoryBaseOK || err != nil {
+		envErr(RepositoryBaseEnvKey)
+	}
+	cfg.RepositoryBase = repositoryBase
+
+	if s, ok := os.LookupEnv(StatementCacheModeEnvKey); ok {
+		mode, err := ParseStatementCacheMode(s)
+		if err != nil {
+			envErr(StatementCacheModeEnvKey)
+		}
+		cfg.StatementCacheMode = mode
+	}
+
+	if s, ok := os.LookupEnv(LogLevelEnvKey); ok {
+		level, err := ParseLogLevel(s)
+		if err != nil {
+			envErr(LogLevelEnvKey)
+		}
+		cfg.LogLevel = level
+	}
+
+	// Slices rather than maps, so errors are joined in a fixed order.
+	for _, d := range []struct {
+		key string
+		ptr *time.Duration
+	}{
+		{ConnTimeoutEnvKey, &cfg.ConnTimeout},
+		{ExecTimeoutEnvKey, &cfg.ExecTimeout},
+	} {
+		if s, ok := os.LookupEnv(d.key); ok {
+			v, err := time.ParseDuration(s)
+			if err != nil || v <= 0 {
+				envErr(d.key)
+			}
+			*d.ptr = v
+		}
+	}
+
+	for _, u := range []struct {
+		key string
+		ptr *uint32
+	}{
+		{Argon2TimeCostEnvKey, &cfg.Argon2TimeCost},
+		{Argon2MemoryCostEnvKey, &cfg.Argon2MemoryCost},
+	} {
+		if s, ok := os.LookupEnv(u.key); ok {
+			v, err := strconv.ParseUint(s, 10, 32)
+			if err != nil || v == 0 {
+				envErr(u.key)
+			}
+			*u.ptr = uint32(v)
+		}
+	}
+
+	if len(errs) != 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,108 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// unsetenv unsets `k` for the duration of the test.
+func unsetenv(t *testing.T, k string) {
+	t.Setenv(k, "") // Restores the prior value after the test.
+	require.NoError(t, os.Unsetenv(k), "unsetenv")
+}
+
+// Not parallel, since these tests modify the environment.
+func TestLoadConfig(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Setenv(PostgresAppUrlEnvKey, "postgres://grokloc@localhost:5432/app")
+		t.Setenv(RepositoryBaseEnvKey, t.TempDir())
+		t.Setenv(StatementCacheModeEnvKey, "describe")
+		t.Setenv(ConnTimeoutEnvKey, "2s")
+		unsetenv(t, ExecTimeoutEnvKey)
+		t.Setenv(Argon2TimeCostEnvKey, "3")
+		unsetenv(t, Argon2MemoryCostEnvKey)
+		t.Setenv(LogLevelEnvKey, "debug")
+
+		cfg, err := LoadConfig()
+		require.NoError(t, err, "load")
+		require.Equal(t, pgx.QueryExecModeCacheDescribe, cfg.StatementCacheMode, "mode")
+		require.Equal(t, 2*time.Second, cfg.ConnTimeout, "conn timeout")
+		require.Equal(t, DefaultExecTimeout, cfg.ExecTimeout, "exec timeout default")
+		require.Equal(t, uint32(3), cfg.Argon2TimeCost, "time cost")
+		require.Equal(t, uint32(0), cfg.Argon2MemoryCost, "memory cost default")
+		require.Equal(t, slog.LevelDebug, cfg.LogLevel, "log level")
+
+		unsetenv(t, LogLevelEnvKey)
+		cfg, err = LoadConfig()
+		require.NoError(t, err, "load")
+		require.Equal(t, DefaultLogLevel, cfg.LogLevel, "log level default")
+	})
+
+	t.Run("Aggregated", func(t *testing.T) {
+		unsetenv(t, PostgresAppUrlEnvKey)
+		t.Setenv(RepositoryBaseEnvKey, "/does/not/exist")
+		t.Setenv(StatementCacheModeEnvKey, "simple")
+		t.Setenv(ConnTimeoutEnvKey, "soon")
+		t.Setenv(ExecTimeoutEnvKey, "-1s")
+		t.Setenv(Argon2TimeCostEnvKey, "0")
+		t.Setenv(Argon2MemoryCostEnvKey, "lots")
+		t.Setenv(LogLevelEnvKey, "loud")
+
+		_, err := LoadConfig()
+		require.Error(t, err, "load")
+		require.True(t, errors.Is(err, ErrEnvVar), "env var err")
+
+		// Errors are in a fixed order, so the message is stable.
+		var want []string
+		for _, k := range []string{
+			PostgresAppUrlEnvKey,
+			RepositoryBaseEnvKey,
+			StatementCacheModeEnvKey,
+			LogLevelEnvKey,
+			ConnTimeoutEnvKey,
+			ExecTimeoutEnvKey,
+			Argon2TimeCostEnvKey,
+			Argon2MemoryCostEnvKey,
+		} {
+			want = append(want, ErrEnvVar.Error()+": "+k)
+		}
+		require.Equal(t, strings.Join(want, "\n"), err.Error(), "ordered")
+
+		unsetenv(t, ExecTimeoutEnvKey)
+		_, err = LoadConfig()
+		require.Error(t, err, "load")
+		require.NotContains(t, err.Error(), ExecTimeoutEnvKey, "not named")
+	})
+}
+
+func TestParseLogLevel(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		for s, want := range map[string]slog.Level{
+			"debug": slog.LevelDebug,
+			"info":  slog.LevelInfo,
+			"warn":  slog.LevelWarn,
+			"error": slog.LevelError,
+		} {
+			level, err := ParseLogLevel(s)
+			require.NoError(t, err, s)
+			require.Equal(t, want, level, s)
+		}
+
+		for _, s := range []string{"", "ERROR", "verbose"} {
+			_, err := ParseLogLevel(s)
+			require.Equal(t, ErrLogLevel, err, "%q", s)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrLSN = errors.New("wal lsn malformed")
+
+// WriteToken records the master's WAL position after a write. A replica
+// that has replayed up to `LSN` sees the write. The zero token is
+// satisfied by any replica.
+type WriteToken struct {
+	LSN uint64
+}
+
+// String formats the token's LSN as postgres does, e.g. "16/B374D848".
+func (w WriteToken) String() string {
+	return fmt.Sprintf("%X/%X", w.LSN>>32, uint32(w.LSN))
+}
+
+// parseLSN parses an LSN in the "hi/lo" hex form postgres prints.
+func parseLSN(s string) (uint64, error) {
+	hi, lo, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, ErrLSN
+	}
+	h, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, ErrLSN
+	}
+	l, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, ErrLSN
+	}
+	return h<<32 | l, nil
+}
+
+// NewWriteToken returns a `WriteToken` for the current WAL position of
+// `conn`, which must be a master connection. Call it after the write
+// has committed; inside a transaction the commit is not yet covered.
+func NewWriteToken(ctx context.Context, conn *pgx.Conn) (WriteToken, error) {
+	var s string
+	err := conn.QueryRow(ctx, `select pg_current_wal_lsn()::text`).Scan(&s)
+	if err != nil {
+		return WriteToken{}, err
+	}
+	lsn, err := parseLSN(s)
+	if err != nil {
+		return WriteToken{}, err
+	}
+	return WriteToken{LSN: lsn}, nil
+}
+
+// ReadAtLeast runs `fn` on a connection that sees every write up to
+// `token`: the first replica whose replay LSN has reached the token,
+// or the master if none has. This gives read-your-writes without
+// sending every read to the master.
+func ReadAtLeast(
+	ctx context.Context,
+	st *State,
+	token WriteToken,
+	fn func(*pgx.Conn) error,
+) error {
+	if token.LSN == 0 {
+		return withPool(ctx, st.ReadPool(), fn)
+	}
+	for _, replica := range st.Replicas {
+		if replica == st.Master {
+			continue
+		}
+		caughtUp, err := replayedTo(ctx, replica, token)
+		if err != nil {
+			st.Logger.Warn("replica replay lsn", "err", err)
+			continue
+		}
+		if caughtUp {
+			return withPool(ctx, replica, fn)
+		}
+	}
+	return withPool(ctx, st.Master, fn)
+}
+
+// replayedTo reports whether the standby `pool` has replayed up to
+// `token`. A pool that is not a standby reports false.
+func replayedTo(ctx context.Context, pool *pgxpool.Pool, token WriteToken) (bool, error) {
+	var s *string
+	err := pool.QueryRow(ctx, `select pg_last_wal_replay_lsn()::text`).Scan(&s)
+	if err != nil {
+		return false, err
+	}
+	if s == nil {
+		return false, nil
+	}
+	lsn, err := parseLSN(*s)
+	if err != nil {
+		return false, err
+	}
+	return lsn >= token.LSN, nil
+}
+
+func withPool(ctx context.Context, pool *pgxpool.Pool, fn func(*pgx.Conn) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	return fn(conn.Conn())
+}
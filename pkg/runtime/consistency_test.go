@@ -0,0 +1,55 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteToken(t *testing.T) {
+	t.Run("ParseLSN", func(t *testing.T) {
+		t.Parallel()
+		for _, s := range []string{"0/0", "16/B374D848", "FFFFFFFF/FFFFFFFF"} {
+			lsn, err := parseLSN(s)
+			require.NoError(t, err, s)
+			require.Equal(t, s, WriteToken{LSN: lsn}.String(), "round trip")
+		}
+		lsn, err := parseLSN("1/0")
+		require.NoError(t, err, "parse")
+		require.Equal(t, uint64(1)<<32, lsn, "hi")
+
+		for _, s := range []string{"", "16", "16/", "G/0", "100000000/0"} {
+			_, err := parseLSN(s)
+			require.Equal(t, ErrLSN, err, s)
+		}
+	})
+
+	t.Run("ReadAtLeast", func(t *testing.T) {
+		t.Parallel()
+		st, err := Unit()
+		require.NoError(t, err, "unit")
+		defer st.Close() // nolint:errcheck
+
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		token, err := NewWriteToken(context.Background(), conn.Conn())
+		conn.Release()
+		require.NoError(t, err, "write token")
+		require.NotZero(t, token.LSN, "lsn")
+
+		// The unit replica is the master, so the read falls back to it.
+		called := false
+		err = ReadAtLeast(context.Background(), st, token, func(c *pgx.Conn) error {
+			called = true
+			return c.Ping(context.Background())
+		})
+		require.NoError(t, err, "read at least")
+		require.True(t, called, "called")
+	})
+}
@@ -0,0 +1,23 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidID = errors.New("id is not a valid uuid")
+
+// ParseID parses an id from an untrusted source, such as a path parameter.
+// The nil uuid is rejected since it usually means the client omitted the id.
+func ParseID(s string) (uuid.UUID, error) {
+	id, err := uuid.Parse(s)
+	if err != nil || id == uuid.Nil {
+		return uuid.Nil, ErrInvalidID
+	}
+	return id, nil
+}
@@ -0,0 +1,36 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseID(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		id := uuid.New()
+		parsed, err := ParseID(id.String())
+		require.NoError(t, err, "parse")
+		require.Equal(t, id, parsed, "round trip")
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseID("not-a-uuid")
+		require.Error(t, err, "malformed")
+		require.Equal(t, ErrInvalidID, err, "invalid id")
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseID(uuid.Nil.String())
+		require.Error(t, err, "nil")
+		require.Equal(t, ErrInvalidID, err, "invalid id")
+	})
+}
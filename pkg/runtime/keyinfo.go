@@ -0,0 +1,36 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"grokloc.com/pkg/security/digest"
+)
+
+// AlgHS256 is the JWS `alg` of `SigningKey`.
+const AlgHS256 = "HS256"
+
+// KeyInfo describes a key that verifies tokens, without its material,
+// as a JWKS-style discovery endpoint advertises it.
+type KeyInfo struct {
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+}
+
+// kidLength is the number of hex characters of the key digest used as
+// the kid.
+const kidLength = 16
+
+// SigningKeyInfo returns the kid and algorithm of each active signing
+// key. The kid is a prefix of the key's digest, so it identifies the
+// key without revealing it.
+func (s *State) SigningKeyInfo() []KeyInfo {
+	if len(s.SigningKey) == 0 {
+		return nil
+	}
+	return []KeyInfo{{
+		Kid: digest.SHA256Hex(string(s.SigningKey))[:kidLength],
+		Alg: AlgHS256,
+	}}
+}
@@ -0,0 +1,32 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"grokloc.com/pkg/security/key"
+)
+
+func TestSigningKeyInfo(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		st := &State{SigningKey: key.Random()}
+		info := st.SigningKeyInfo()
+		require.Len(t, info, 1, "keys")
+		require.Equal(t, AlgHS256, info[0].Alg, "alg")
+		require.Len(t, info[0].Kid, kidLength, "kid")
+		require.NotContains(t, hex.EncodeToString(st.SigningKey), info[0].Kid, "no material")
+
+		// The kid is stable for a key and differs between keys.
+		require.Equal(t, info, st.SigningKeyInfo(), "stable")
+		other := &State{SigningKey: key.Random()}
+		require.NotEqual(t, info[0].Kid, other.SigningKeyInfo()[0].Kid, "distinct")
+
+		require.Empty(t, (&State{}).SigningKeyInfo(), "no key")
+	})
+}
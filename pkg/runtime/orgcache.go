@@ -0,0 +1,17 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"github.com/google/uuid"
+)
+
+// OrgCache caches org rows by id. It is implemented by `org.Cache`;
+// this package cannot name `org.Org`, so it only needs to evict. It is
+// passed explicitly to `ProcessScheduled`, as an `org.Cache` is to the
+// org mutations, so each changed org is evicted.
+type OrgCache interface {
+	Delete(id uuid.UUID)
+}
@@ -0,0 +1,32 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"grokloc.com/pkg/cache"
+)
+
+func TestOrgCache(t *testing.T) {
+	t.Run("Delete", func(t *testing.T) {
+		t.Parallel()
+		id := uuid.New()
+
+		c := cache.New[uuid.UUID, string](10, time.Minute)
+		c.Set(id, "org")
+		var oc OrgCache = c
+		oc.Delete(id)
+		_, ok := c.Get(id)
+		require.False(t, ok, "evicted")
+
+		// A nil cache evicts nothing.
+		oc = (*cache.Cache[uuid.UUID, string])(nil)
+		oc.Delete(id)
+	})
+}
@@ -0,0 +1,33 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"grokloc.com/pkg/postgresql"
+)
+
+// BatchFn queues one or more queries on `b`, each with a callback that
+// handles its result; see `pgx.QueuedQuery`.
+type BatchFn func(b *pgx.Batch)
+
+// Pipeline sends the queries queued by `fns` to `conn` as one batch, so
+// they share a single network round trip, then runs their callbacks in
+// order. Outside a transaction the batch runs in one implicit
+// transaction, so the queries see the same snapshot. The first error,
+// from a query or a callback, is returned and later callbacks are not
+// run.
+func Pipeline(ctx context.Context, conn postgresql.DB, fns ...BatchFn) error {
+	b := &pgx.Batch{}
+	for _, fn := range fns {
+		fn(b)
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return conn.SendBatch(ctx, b).Close()
+}
@@ -0,0 +1,22 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		t.Parallel()
+		// Nothing is queued, so nothing is sent on the nil conn.
+		err := Pipeline(context.Background(), nil, func(*pgx.Batch) {})
+		require.NoError(t, err, "empty")
+	})
+}
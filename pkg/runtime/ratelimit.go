@@ -0,0 +1,93 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrRateLimited   = errors.New("org rate limit exceeded")
+	ErrNoRateLimiter = errors.New("rate limiter required")
+)
+
+// RateLimiter decides whether `org` may make another mutation, so one
+// tenant cannot starve the others.
+type RateLimiter interface {
+	Allow(ctx context.Context, org uuid.UUID) bool
+}
+
+// NoRateLimit is a `RateLimiter` that allows every call. Mutations
+// require a limiter, so pass it to disable rate limiting explicitly.
+type NoRateLimit struct{}
+
+// Allow returns true.
+func (NoRateLimit) Allow(context.Context, uuid.UUID) bool {
+	return true
+}
+
+// CheckRateLimit returns `ErrRateLimited` if `l` denies `org`. A nil
+// `l` returns `ErrNoRateLimiter`, so a missing limiter is an error
+// rather than a silent bypass.
+func CheckRateLimit(ctx context.Context, l RateLimiter, org uuid.UUID) error {
+	if l == nil {
+		return ErrNoRateLimiter
+	}
+	if !l.Allow(ctx, org) {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// TokenBucket is a `RateLimiter` with a bucket per org. Each bucket
+// holds up to `burst` tokens, refills at `rate` tokens per second,
+// and each allowed call takes one token.
+type TokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[uuid.UUID]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a `TokenBucket` allowing `rate` calls per
+// second per org, with bursts of up to `burst`.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[uuid.UUID]*bucket),
+	}
+}
+
+// Allow takes a token from the bucket of `org` if it has one.
+func (t *TokenBucket) Allow(_ context.Context, org uuid.UUID) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	b, ok := t.buckets[org]
+	if !ok {
+		b = &bucket{tokens: t.burst, last: now}
+		t.buckets[org] = b
+	}
+	b.tokens = min(t.burst, b.tokens+now.Sub(b.last).Seconds()*t.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,47 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	t.Run("TokenBucket", func(t *testing.T) {
+		t.Parallel()
+		l := NewTokenBucket(0.001, 3)
+		org := uuid.New()
+		for range 3 {
+			require.True(t, l.Allow(context.Background(), org), "burst")
+		}
+		require.False(t, l.Allow(context.Background(), org), "exhausted")
+		require.True(t, l.Allow(context.Background(), uuid.New()), "other org")
+
+		// Buckets refill over time.
+		l = NewTokenBucket(1000, 1)
+		require.True(t, l.Allow(context.Background(), org), "first")
+		time.Sleep(5 * time.Millisecond)
+		require.True(t, l.Allow(context.Background(), org), "refilled")
+	})
+
+	t.Run("Check", func(t *testing.T) {
+		t.Parallel()
+		org := uuid.New()
+		require.Equal(t, ErrNoRateLimiter,
+			CheckRateLimit(context.Background(), nil, org), "nil limiter")
+		require.NoError(t,
+			CheckRateLimit(context.Background(), NoRateLimit{}, org), "no limit")
+
+		l := NewTokenBucket(0.001, 1)
+		require.NoError(t, CheckRateLimit(context.Background(), l, org), "allowed")
+		require.Equal(t, ErrRateLimited,
+			CheckRateLimit(context.Background(), l, org), "limited")
+	})
+}
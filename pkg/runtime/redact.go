@@ -0,0 +1,77 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redacted = "xxxxx"
+
+var ErrDatabaseURL = errors.New("database url not valid")
+
+var (
+	// userinfoPassword matches the password of a URL that `url.Parse`
+	// rejects, such as one with a malformed port.
+	userinfoPassword = regexp.MustCompile(`://[^:/@]*:([^@]*)@`)
+
+	// dsnPassword matches the password of a key=value connection string.
+	dsnPassword = regexp.MustCompile(`password=('[^']*'|\S*)`)
+)
+
+// RedactURL returns `s`, a database URL or key=value connection string,
+// with any password masked, for use in logs and errors.
+func RedactURL(s string) string {
+	if u, err := url.Parse(s); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			return u.Redacted()
+		}
+		return s
+	}
+	s = userinfoPassword.ReplaceAllString(s, "://"+redacted+"@")
+	return dsnPassword.ReplaceAllString(s, "password="+redacted)
+}
+
+// redactedError is an error from parsing a URL, with the URL's
+// password removed from its message. The cause is not kept, as its
+// message and fields may hold the password; it unwraps to
+// `ErrDatabaseURL` instead.
+type redactedError struct {
+	msg string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return ErrDatabaseURL
+}
+
+// redactError removes the password of `s` from the message of `err`.
+func redactError(s string, err error) error {
+	msg := err.Error()
+	if password := urlPassword(s); password != "" {
+		msg = strings.ReplaceAll(msg, password, redacted)
+	}
+	return &redactedError{msg: msg}
+}
+
+func urlPassword(s string) string {
+	if u, err := url.Parse(s); err == nil && u.User != nil {
+		password, _ := u.User.Password()
+		return password
+	}
+	if m := userinfoPassword.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+	if m := dsnPassword.FindStringSubmatch(s); m != nil {
+		return strings.Trim(m[1], "'")
+	}
+	return ""
+}
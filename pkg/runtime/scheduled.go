@@ -0,0 +1,44 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"grokloc.com/pkg/model/status"
+	"grokloc.com/pkg/postgresql"
+)
+
+// ProcessScheduled sets inactive each org whose deactivation, scheduled
+// with `org.ScheduleDeactivation`, is due, clears the schedule, and
+// returns the number of orgs changed. Archived orgs stay archived, but
+// their schedule is cleared. Run it periodically on a master
+// connection or pool; each run is a single statement, so concurrent runs are
+// safe. Changed orgs are evicted from `c`; a nil `c` evicts nothing.
+func ProcessScheduled(ctx context.Context, conn postgresql.DB, c OrgCache) (int64, error) {
+	const query = `update orgs
+		set status = case when status = $2 then status else $1 end,
+		deactivate_at = null,
+		updated_by = null
+		where deactivate_at <= unixtime()
+		returning id`
+
+	rows, err := conn.Query(ctx, query, status.Inactive, status.Archived)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return 0, err
+	}
+	if c != nil {
+		for _, id := range ids {
+			c.Delete(id)
+		}
+	}
+	return int64(len(ids)), nil
+}
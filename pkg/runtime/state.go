@@ -5,27 +5,41 @@ communicating with the execution environment.
 package runtime
 
 import (
+	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"log/slog"
 	"math/big"
-	"os"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/matthewhartstonge/argon2"
 	"grokloc.com/pkg/security/key"
+	"grokloc.com/pkg/security/password"
 )
 
 const (
 	LevelEnvKey          = "LEVEL"
 	PostgresAppUrlEnvKey = "POSTGRES_APP_URL"
 	RepositoryBaseEnvKey = "REPOSITORY_BASE"
+
+	// StatementCacheModeEnvKey optionally selects `State.StatementCacheMode`;
+	// see `ParseStatementCacheMode`.
+	StatementCacheModeEnvKey = "STATEMENT_CACHE_MODE"
 )
 
-var ErrEnvVar = errors.New("environment variable not found or malformed")
+var (
+	ErrEnvVar             = errors.New("environment variable not found or malformed")
+	ErrLevel              = errors.New("level not supported")
+	ErrNoReplica          = errors.New("no healthy replica")
+	ErrNilConfig          = errors.New("config is nil")
+	ErrStatementCacheMode = errors.New("statement cache mode not recognized")
+)
 
 // State contains all environment-specific runtime definitions.
 type State struct {
@@ -42,6 +56,39 @@ type State struct {
 	ExecTimeout time.Duration
 	DefaultRole int
 
+	// StatementCacheMode is how the pools send queries:
+	//
+	//   - "prepare" (the pgx default) prepares each query once per
+	//     connection, so a repeated query like
+	//     `select * from users where id = @id` costs one round trip
+	//     after the first. Prepared statements are lost when a
+	//     connection is closed, and do not work behind a
+	//     transaction-pooling proxy.
+	//   - "describe" caches only the parameter and result types per
+	//     connection. It works behind proxies, at the cost of the
+	//     server planning each execution.
+	//   - "exec" caches nothing. Each query is sent as one
+	//     extended-protocol message, with parameter types inferred
+	//     from the Go argument types rather than described by the
+	//     server, so it adds no round trip but relies on that
+	//     inference being correct.
+	StatementCacheMode pgx.QueryExecMode
+
+	// health records replicas found down by `CheckReplicas`. Nil
+	// treats every replica as healthy.
+	health *replicaHealth
+
+	// RateLimiter limits mutations per org. Mutations take it as an
+	// argument and fail with `ErrNoRateLimiter` if it is nil; use
+	// `NoRateLimit` to disable rate limiting.
+	RateLimiter RateLimiter
+
+	// StatusSink receives user status transitions. Mutations that
+	// change status take it as an argument and fail with
+	// `ErrNoStatusSink` if it is nil; use `DiscardStatus` to disable
+	// auditing.
+	StatusSink StatusSink
+
 	// Repository related.
 	RepositoryBase string
 
@@ -50,41 +97,249 @@ type State struct {
 	// Password hash config.
 	Argon2Config argon2.Config
 
+	// PasswordLimiter caps concurrent password verification.
+	PasswordLimiter *password.Limiter
+
 	// SigningKey signs JWTs.
 	SigningKey []byte
 
+	// Issuer is the JWT `iss` claim.
+	Issuer string
+
 	// EncryptionKeyVersion is the version of the current encryption key.
 	EncryptionKeyVersion uuid.UUID
 
 	// EncryptionKeys is a map of version -> key for all keys
 	// available for use, including keys no longer in rotation.
 	EncryptionKeys key.VersionedMap
+
+	// versionedKeys memoizes `VersionedKey`. Nil disables it.
+	versionedKeys *sync.Map
+}
+
+// PromoteKey makes the key with `version` in `EncryptionKeys` the
+// current key, returning `key.ErrNotFound` if there is none. It lets
+// tests exercise key rotation without rebuilding the `State`.
+func (s *State) PromoteKey(version uuid.UUID) error {
+	if _, ok := s.EncryptionKeys[version]; !ok {
+		return key.ErrNotFound
+	}
+	s.EncryptionKeyVersion = version
+	return nil
+}
+
+// VersionedKey is `EncryptionKeys.Get` without the allocation of a
+// new `key.Versioned` on each call: the first lookup of each version is
+// stored and returned thereafter. Callers must not modify the result.
+// Versions are never reassigned to a different key, so a stored value
+// stays correct; a version removed from `EncryptionKeys` is no longer
+// returned.
+func (s *State) VersionedKey(v uuid.UUID) (*key.Versioned, error) {
+	if s.versionedKeys == nil {
+		return s.EncryptionKeys.Get(v)
+	}
+	if _, ok := s.EncryptionKeys[v]; !ok {
+		return nil, key.ErrNotFound
+	}
+	if cached, ok := s.versionedKeys.Load(v); ok {
+		return cached.(*key.Versioned), nil
+	}
+	versioned, err := s.EncryptionKeys.Get(v)
+	if err != nil {
+		return nil, err
+	}
+	cached, _ := s.versionedKeys.LoadOrStore(v, versioned)
+	return cached.(*key.Versioned), nil
 }
 
 // RandomReplica selects a random replica.
 func (s *State) RandomReplica() *pgxpool.Pool {
-	l := len(s.Replicas)
-	if l == 0 {
+	if len(s.Replicas) == 0 {
 		panic("no replicas")
 	}
+	return randomPool(s.Replicas)
+}
+
+func randomPool(pools []*pgxpool.Pool) *pgxpool.Pool {
+	l := len(pools)
 	n, err := rand.Int(rand.Reader, big.NewInt(int64(l)))
 	if err != nil {
 		panic(fmt.Sprintf("random replica index in 0:%v", l))
 	}
-	return s.Replicas[n.Int64()]
+	return pools[n.Int64()]
+}
+
+// FallbackWarnInterval is the minimum time between warnings logged by
+// `ReadPool` when it falls back to the master.
+const FallbackWarnInterval = time.Minute
+
+type replicaHealth struct {
+	mu       sync.Mutex
+	down     map[*pgxpool.Pool]bool
+	lastWarn time.Time
+}
+
+func newReplicaHealth() *replicaHealth {
+	return &replicaHealth{down: make(map[*pgxpool.Pool]bool)}
+}
+
+// HealthyReplica selects a random replica not found down by the last
+// `CheckReplicas`, or returns `ErrNoReplica` if there is none. Unlike
+// `ReadPool`, it never returns the master.
+func (s *State) HealthyReplica() (*pgxpool.Pool, error) {
+	if replica := s.healthyReplica(); replica != nil {
+		return replica, nil
+	}
+	return nil, ErrNoReplica
+}
+
+func (s *State) healthyReplica() *pgxpool.Pool {
+	if len(s.Replicas) == 0 {
+		return nil
+	}
+	if s.health == nil {
+		return randomPool(s.Replicas)
+	}
+
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	healthy := make([]*pgxpool.Pool, 0, len(s.Replicas))
+	for _, replica := range s.Replicas {
+		if !s.health.down[replica] {
+			healthy = append(healthy, replica)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	return randomPool(healthy)
+}
+
+// ReadPool is `HealthyReplica`, but if there is none, it returns the
+// master and logs a warning at most once per `FallbackWarnInterval`,
+// since reads are then competing with writes. Use it only where a
+// read may degrade to the master, such as authentication.
+func (s *State) ReadPool() *pgxpool.Pool {
+	if replica := s.healthyReplica(); replica != nil {
+		return replica
+	}
+	if s.health == nil {
+		return s.Master
+	}
+
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	if s.Logger != nil && time.Since(s.health.lastWarn) >= FallbackWarnInterval {
+		s.health.lastWarn = time.Now()
+		s.Logger.Warn("no healthy replicas, reading from master",
+			"replicas", len(s.Replicas))
+	}
+	return s.Master
+}
+
+// CheckReplicas pings each replica, waiting at most `ConnTimeout` for
+// each if it is set, and records which are down for `ReadPool`. It
+// returns the number that are healthy. Run it periodically; a replica
+// stays down until a later check succeeds. A `State` not built by
+// `New` or `Unit` records nothing.
+func (s *State) CheckReplicas(ctx context.Context) int {
+	down := make(map[*pgxpool.Pool]bool, len(s.Replicas))
+	for _, replica := range s.Replicas {
+		pingCtx, cancel := ctx, context.CancelFunc(func() {})
+		if s.ConnTimeout > 0 {
+			pingCtx, cancel = context.WithTimeout(ctx, s.ConnTimeout)
+		}
+		err := replica.Ping(pingCtx)
+		cancel()
+		if err != nil {
+			down[replica] = true
+		}
+	}
+
+	if s.health == nil {
+		return len(s.Replicas) - len(down)
+	}
+	s.health.mu.Lock()
+	s.health.down = down
+	s.health.mu.Unlock()
+	return len(s.Replicas) - len(down)
+}
+
+// ReplicaForKey selects the replica that `k`, such as a session id,
+// hashes to, so a client's sequential reads see the same replica's
+// snapshot. An empty `k` selects a random replica.
+func (s *State) ReplicaForKey(k string) *pgxpool.Pool {
+	if k == "" {
+		return s.RandomReplica()
+	}
+	l := len(s.Replicas)
+	if l == 0 {
+		panic("no replicas")
+	}
+	h := fnv.New64a()
+	h.Write([]byte(k))
+	return s.Replicas[h.Sum64()%uint64(l)]
+}
+
+// AcquireMasterTimed acquires a connection from the master pool and
+// also returns how long the acquisition took.
+func (s *State) AcquireMasterTimed(ctx context.Context) (*pgxpool.Conn, time.Duration, error) {
+	start := time.Now()
+	conn, err := s.Master.Acquire(ctx)
+	return conn, time.Since(start), err
+}
+
+// PoolStats returns a snapshot of the statistics of each pool, keyed
+// by "master" and "replica" suffixed with the replica index.
+func (s *State) PoolStats() map[string]*pgxpool.Stat {
+	stats := make(map[string]*pgxpool.Stat, len(s.Replicas)+1)
+	if s.Master != nil {
+		stats["master"] = s.Master.Stat()
+	}
+	for i := range s.Replicas {
+		stats[fmt.Sprintf("replica%d", i)] = s.Replicas[i].Stat()
+	}
+	return stats
+}
+
+// ParseStatementCacheMode maps "prepare", "describe", or "exec" to
+// the corresponding `pgx.QueryExecMode`.
+func ParseStatementCacheMode(s string) (pgx.QueryExecMode, error) {
+	switch s {
+	case "prepare":
+		return pgx.QueryExecModeCacheStatement, nil
+	case "describe":
+		return pgx.QueryExecModeCacheDescribe, nil
+	case "exec":
+		return pgx.QueryExecModeExec, nil
+	default:
+		return 0, ErrStatementCacheMode
+	}
 }
 
 // New produces a new `State` instance for the level set in
-// environment variable `LEVEL`.
+// environment variable `LEVEL`; see `NewForLevel`.
 func New() (*State, error) {
-	level, levelOK := os.LookupEnv(LevelEnvKey)
-	if !levelOK {
-		return nil, ErrEnvVar
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Level == "" {
+		return nil, fmt.Errorf("%w: %s", ErrEnvVar, LevelEnvKey)
 	}
-	if level == "unit" {
-		return Unit()
+	return NewForLevel(cfg.Level, cfg)
+}
+
+// NewForLevel produces a new `State` instance for `level` from `cfg`,
+// ignoring `cfg.Level` and the environment. An unsupported level
+// returns `ErrLevel`, and a nil `cfg` returns `ErrNilConfig`.
+func NewForLevel(level string, cfg *Config) (*State, error) {
+	switch level {
+	case "unit":
+		return unit(cfg)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrLevel, level)
 	}
-	panic("environment not supported")
 }
 
 // Close can be run when a `State` instance is no longer needed.
@@ -0,0 +1,227 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/require"
+	"grokloc.com/pkg/security/key"
+)
+
+func TestPools(t *testing.T) {
+	t.Run("AcquireMasterTimed", func(t *testing.T) {
+		t.Parallel()
+		st, err := Unit()
+		require.NoError(t, err, "unit")
+		defer st.Close() // nolint:errcheck
+
+		conn, elapsed, err := st.AcquireMasterTimed(context.Background())
+		require.NoError(t, err, "acquire")
+		defer conn.Release()
+		require.Positive(t, elapsed, "elapsed")
+
+		stats := st.PoolStats()
+		require.Contains(t, stats, "master", "master")
+		require.Contains(t, stats, "replica0", "replica")
+		require.Equal(t, int32(1), stats["master"].AcquiredConns(), "acquired")
+		require.Equal(t, int64(1), stats["master"].AcquireCount(), "acquire count")
+	})
+}
+
+func TestNewForLevel(t *testing.T) {
+	t.Run("Unsupported", func(t *testing.T) {
+		t.Parallel()
+		for _, level := range []string{"", "prod", "Unit"} {
+			_, err := NewForLevel(level, &Config{})
+			require.ErrorIs(t, err, ErrLevel, level)
+		}
+	})
+
+	t.Run("Unit", func(t *testing.T) {
+		t.Parallel()
+		// The level is dispatched on even though the config is unusable.
+		_, err := NewForLevel("unit", &Config{PostgresAppURL: "postgres://%zz"})
+		require.Error(t, err, "bad url")
+		require.NotErrorIs(t, err, ErrLevel, "level supported")
+
+		_, err = NewForLevel("unit", nil)
+		require.Equal(t, ErrNilConfig, err, "nil config")
+	})
+}
+
+func TestParseStatementCacheMode(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		for s, mode := range map[string]pgx.QueryExecMode{
+			"prepare":  pgx.QueryExecModeCacheStatement,
+			"describe": pgx.QueryExecModeCacheDescribe,
+			"exec":     pgx.QueryExecModeExec,
+		} {
+			parsed, err := ParseStatementCacheMode(s)
+			require.NoError(t, err, "parse")
+			require.Equal(t, mode, parsed, s)
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		t.Parallel()
+		_, err := ParseStatementCacheMode("simple")
+		require.Error(t, err, "parse")
+		require.Equal(t, ErrStatementCacheMode, err, "mode err")
+	})
+}
+
+func TestReplicaForKey(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		st := &State{Replicas: []*pgxpool.Pool{{}, {}, {}}}
+		for range 10 {
+			k := uuid.NewString()
+			require.Same(t, st.ReplicaForKey(k), st.ReplicaForKey(k), "stable")
+		}
+		require.Contains(t, st.Replicas, st.ReplicaForKey(""), "random")
+	})
+
+	t.Run("NoReplicas", func(t *testing.T) {
+		t.Parallel()
+		st := &State{}
+		require.Panics(t, func() { st.ReplicaForKey("k") }, "no replicas")
+	})
+}
+
+func TestVersionedKey(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		current := uuid.New()
+		st := &State{
+			EncryptionKeys: key.VersionedMap{current: key.Random()},
+			versionedKeys:  new(sync.Map),
+		}
+
+		a, err := st.VersionedKey(current)
+		require.NoError(t, err, "get")
+		require.Equal(t, current, a.Version, "version")
+		require.Equal(t, st.EncryptionKeys[current], a.Key, "key")
+		b, err := st.VersionedKey(current)
+		require.NoError(t, err, "get again")
+		require.Same(t, a, b, "memoized")
+
+		_, err = st.VersionedKey(uuid.New())
+		require.Equal(t, key.ErrNotFound, err, "absent")
+
+		delete(st.EncryptionKeys, current)
+		_, err = st.VersionedKey(current)
+		require.Equal(t, key.ErrNotFound, err, "removed")
+
+		uncached := &State{EncryptionKeys: key.VersionedMap{current: key.Random()}}
+		_, err = uncached.VersionedKey(current)
+		require.NoError(t, err, "uncached")
+	})
+}
+
+// versionedKeySink keeps benchmark results on the heap, as they are
+// when passed on to decryption.
+var versionedKeySink *key.Versioned
+
+// BenchmarkVersionedKey compares `EncryptionKeys.Get`, which allocates
+// a `key.Versioned` per call, with the memoized `VersionedKey`.
+func BenchmarkVersionedKey(b *testing.B) {
+	current := uuid.New()
+	st := &State{
+		EncryptionKeys: key.VersionedMap{current: key.Random()},
+		versionedKeys:  new(sync.Map),
+	}
+
+	b.Run("Get", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			versioned, err := st.EncryptionKeys.Get(current)
+			if err != nil {
+				b.Fatal(err)
+			}
+			versionedKeySink = versioned
+		}
+	})
+
+	b.Run("VersionedKey", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			versioned, err := st.VersionedKey(current)
+			if err != nil {
+				b.Fatal(err)
+			}
+			versionedKeySink = versioned
+		}
+	})
+}
+
+func TestPromoteKey(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		current, next := uuid.New(), uuid.New()
+		st := &State{
+			EncryptionKeyVersion: current,
+			EncryptionKeys: key.VersionedMap{
+				current: key.Random(),
+				next:    key.Random(),
+			},
+		}
+
+		require.NoError(t, st.PromoteKey(next), "promote")
+		require.Equal(t, next, st.EncryptionKeyVersion, "current")
+
+		err := st.PromoteKey(uuid.New())
+		require.Error(t, err, "absent")
+		require.Equal(t, key.ErrNotFound, err, "not found err")
+		require.Equal(t, next, st.EncryptionKeyVersion, "unchanged")
+	})
+}
+
+func TestReadPool(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		// Pools connect lazily, so these need no db until pinged.
+		newPool := func() *pgxpool.Pool {
+			pool, err := pgxpool.New(context.Background(), "postgres://grokloc@127.0.0.1:1/app")
+			require.NoError(t, err, "pool")
+			return pool
+		}
+		st := &State{
+			Master:      newPool(),
+			Replicas:    []*pgxpool.Pool{newPool(), newPool()},
+			ConnTimeout: time.Second,
+			health:      newReplicaHealth(),
+		}
+		defer st.Close() // nolint:errcheck
+
+		require.Contains(t, st.Replicas, st.ReadPool(), "unchecked replica")
+
+		require.Zero(t, st.CheckReplicas(context.Background()), "healthy")
+		require.Equal(t, st.Master, st.ReadPool(), "fallback")
+		_, err := st.HealthyReplica()
+		require.Equal(t, ErrNoReplica, err, "no healthy replica")
+
+		st.health.mu.Lock()
+		delete(st.health.down, st.Replicas[1])
+		st.health.mu.Unlock()
+		require.Equal(t, st.Replicas[1], st.ReadPool(), "healthy replica")
+		replica, err := st.HealthyReplica()
+		require.NoError(t, err, "healthy replica")
+		require.Equal(t, st.Replicas[1], replica, "healthy replica")
+
+		// No replicas at all also falls back.
+		noReplicas := &State{Master: st.Master, health: newReplicaHealth()}
+		require.Equal(t, st.Master, noReplicas.ReadPool(), "no replicas")
+		_, err = noReplicas.HealthyReplica()
+		require.Equal(t, ErrNoReplica, err, "no replicas")
+	})
+}
@@ -0,0 +1,55 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrNoStatusSink = errors.New("status sink required")
+
+// StatusChange records one status transition of a user, for audit.
+type StatusChange struct {
+	UserID uuid.UUID
+	From   int
+	To     int
+	At     time.Time
+}
+
+// StatusSink receives `StatusChange` records. `Record` is called after
+// the update statement succeeds but possibly inside the caller's
+// transaction, so a sink must not block and cannot veto the change.
+type StatusSink interface {
+	Record(ctx context.Context, c StatusChange)
+}
+
+// StatusSinkFunc adapts a function to a `StatusSink`.
+type StatusSinkFunc func(ctx context.Context, c StatusChange)
+
+// Record calls `f`.
+func (f StatusSinkFunc) Record(ctx context.Context, c StatusChange) {
+	f(ctx, c)
+}
+
+// DiscardStatus is a `StatusSink` that drops every record. Mutations
+// that change status require a sink, so pass it to disable auditing
+// explicitly.
+type DiscardStatus struct{}
+
+// Record does nothing.
+func (DiscardStatus) Record(context.Context, StatusChange) {}
+
+// CheckStatusSink returns `ErrNoStatusSink` if `s` is nil, so a missing
+// sink is an error rather than a silently skipped audit record.
+func CheckStatusSink(s StatusSink) error {
+	if s == nil {
+		return ErrNoStatusSink
+	}
+	return nil
+}
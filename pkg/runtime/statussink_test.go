@@ -0,0 +1,35 @@
+/*
+Package runtime provides types and utilties for
+communicating with the execution environment.
+*/
+package runtime
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusSink(t *testing.T) {
+	t.Run("Required", func(t *testing.T) {
+		t.Parallel()
+		require.Equal(t, ErrNoStatusSink, CheckStatusSink(nil), "nil sink")
+		require.NoError(t, CheckStatusSink(DiscardStatus{}), "discard")
+	})
+
+	t.Run("Func", func(t *testing.T) {
+		t.Parallel()
+		c := StatusChange{UserID: uuid.New(), From: 1, To: 2, At: time.Now()}
+
+		var got []StatusChange
+		sink := StatusSinkFunc(func(_ context.Context, c StatusChange) {
+			got = append(got, c)
+		})
+		require.NoError(t, CheckStatusSink(sink), "func")
+		sink.Record(context.Background(), c)
+		require.Equal(t, []StatusChange{c}, got, "recorded")
+	})
+}
@@ -8,36 +8,53 @@ import (
 	"context"
 	"log/slog"
 	"os"
-	"time"
+	go_runtime "runtime"
+	"sync"
 
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/matthewhartstonge/argon2"
 	"grokloc.com/pkg/model/role"
+	"grokloc.com/pkg/security/jwt"
 	"grokloc.com/pkg/security/key"
+	"grokloc.com/pkg/security/password"
 )
 
+// Unit produces a `State` for unit tests from `LoadConfig`.
 func Unit() (*State, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return unit(cfg)
+}
+
+func unit(cfg *Config) (*State, error) {
+	if cfg == nil {
+		return nil, ErrNilConfig
+	}
+
 	logger := slog.New(slog.NewJSONHandler(
 		os.Stderr,
-		&slog.HandlerOptions{AddSource: true, Level: slog.LevelError},
+		&slog.HandlerOptions{AddSource: true, Level: cfg.LogLevel},
 	))
 
-	dbUrl, dbUrlOK := os.LookupEnv(PostgresAppUrlEnvKey)
-	if !dbUrlOK {
-		return nil, ErrEnvVar
-	}
-
-	_, dbUrlParseErr := pgconn.ParseConfig(dbUrl)
-	if dbUrlParseErr != nil {
-		return nil, ErrEnvVar
+	poolConfig, err := pgxpool.ParseConfig(cfg.PostgresAppURL)
+	if err != nil {
+		err = redactError(cfg.PostgresAppURL, err)
+		logger.Error("parse db url",
+			"url", RedactURL(cfg.PostgresAppURL), "err", err)
+		return nil, err
 	}
+	poolConfig.ConnConfig.DefaultQueryExecMode = cfg.StatementCacheMode
 
 	ctx := context.Background()
 
-	master, poolErr := pgxpool.New(ctx, dbUrl)
+	master, poolErr := pgxpool.NewWithConfig(ctx, poolConfig)
 	if poolErr != nil {
+		poolErr = redactError(cfg.PostgresAppURL, poolErr)
+		logger.Error("connect db",
+			"url", RedactURL(cfg.PostgresAppURL), "err", poolErr)
 		return nil, poolErr
 	}
 
@@ -46,14 +63,11 @@ func Unit() (*State, error) {
 
 	argon2Config := argon2.DefaultConfig()
 	argon2Config.TimeCost = 1
-
-	repositoryBase, repositoryBaseOK := os.LookupEnv(RepositoryBaseEnvKey)
-	if !repositoryBaseOK {
-		return nil, ErrEnvVar
+	if cfg.Argon2TimeCost != 0 {
+		argon2Config.TimeCost = cfg.Argon2TimeCost
 	}
-	_, repositoryBaseErr := os.Stat(repositoryBase)
-	if repositoryBaseErr != nil {
-		return nil, repositoryBaseErr
+	if cfg.Argon2MemoryCost != 0 {
+		argon2Config.MemoryCost = cfg.Argon2MemoryCost
 	}
 
 	currentEncryptionKey := key.Versioned{
@@ -64,6 +78,12 @@ func Unit() (*State, error) {
 	encryptionKeys[currentEncryptionKey.Version] = currentEncryptionKey.Key
 	encryptionKeys[uuid.New()] = key.Random()
 	encryptionKeys[uuid.New()] = key.Random()
+	err = encryptionKeys.Validate(currentEncryptionKey.Version)
+	if err != nil {
+		master.Close()
+		logger.Error("encryption keys", "err", err)
+		return nil, err
+	}
 
 	st := &State{
 		Logger: logger,
@@ -73,17 +93,26 @@ func Unit() (*State, error) {
 
 		Master:      master,
 		Replicas:    replicas,
-		ConnTimeout: time.Duration(1000 * time.Millisecond),
-		ExecTimeout: time.Duration(1000 * time.Millisecond),
+		health:      newReplicaHealth(),
+		ConnTimeout: cfg.ConnTimeout,
+		ExecTimeout: cfg.ExecTimeout,
 		DefaultRole: role.Test,
 
-		RepositoryBase: repositoryBase,
+		StatementCacheMode: cfg.StatementCacheMode,
+
+		RateLimiter: NoRateLimit{},
+		StatusSink:  DiscardStatus{},
+
+		RepositoryBase: cfg.RepositoryBase,
 
-		Argon2Config: argon2Config,
-		SigningKey:   key.Random(),
+		Argon2Config:    argon2Config,
+		PasswordLimiter: password.NewLimiter(go_runtime.NumCPU()),
+		SigningKey:      key.Random(),
+		Issuer:          jwt.DefaultIssuer,
 
 		EncryptionKeyVersion: currentEncryptionKey.Version,
 		EncryptionKeys:       encryptionKeys,
+		versionedKeys:        new(sync.Map),
 	}
 	return st, nil
 }
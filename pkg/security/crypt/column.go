@@ -0,0 +1,59 @@
+/*
+Package crypt contains crytographic utilities.
+*/
+package crypt
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var ErrColumnType = errors.New("encrypted column value not text")
+
+// Column is a `sql.Scanner`, which pgx supports as a scan target, that
+// decrypts a column produced by `Encrypt` as it is scanned, so a query
+// cannot read an encrypted column and forget to decrypt it.
+//
+// `Key` and `Digest` must be known before the scan. List the digest
+// column before the encrypted one, so that pgx scans it first:
+//
+//	var d string
+//	c := crypt.Column{Key: k, Digest: &d}
+//	err := row.Scan(&d, &c)
+//
+// When the key depends on a column of the same row, such as a key
+// version, it cannot be known in time, so decrypt after the scan.
+type Column struct {
+	Key    []byte
+	Digest *string // Expected digest; see `Decrypt`.
+
+	Value string // Decrypted.
+	Null  bool   // The column was null; `Value` is empty.
+}
+
+var _ sql.Scanner = (*Column)(nil)
+
+// Scan decrypts `src` into `Value`. A nil `Digest` returns `ErrDigest`.
+func (c *Column) Scan(src any) error {
+	var e string
+	switch v := src.(type) {
+	case nil:
+		c.Value, c.Null = "", true
+		return nil
+	case string:
+		e = v
+	case []byte:
+		e = string(v)
+	default:
+		return ErrColumnType
+	}
+	if c.Digest == nil {
+		return ErrDigest
+	}
+	s, err := Decrypt(e, *c.Digest, c.Key)
+	if err != nil {
+		return err
+	}
+	c.Value, c.Null = s, false
+	return nil
+}
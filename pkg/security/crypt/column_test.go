@@ -0,0 +1,48 @@
+/*
+Package crypt contains crytographic utilities.
+*/
+package crypt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"grokloc.com/pkg/security/key"
+)
+
+func TestColumn(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		k := key.Random()
+		s := uuid.NewString()
+		e, err := Encrypt(s, k)
+		require.NoError(t, err, "encrypt fail")
+		digestBytes := sha256.Sum256([]byte(s))
+		d := hex.EncodeToString(digestBytes[:])
+
+		c := Column{Key: k, Digest: &d}
+		require.NoError(t, c.Scan(e), "scan string")
+		require.Equal(t, s, c.Value, "decrypted")
+		require.False(t, c.Null, "not null")
+
+		c = Column{Key: k, Digest: &d}
+		require.NoError(t, c.Scan([]byte(e)), "scan bytes")
+		require.Equal(t, s, c.Value, "decrypted bytes")
+
+		require.NoError(t, c.Scan(nil), "scan null")
+		require.True(t, c.Null, "null")
+		require.Empty(t, c.Value, "null value")
+
+		bad := "abcd"
+		c = Column{Key: k, Digest: &bad}
+		require.Equal(t, ErrDigest, c.Scan(e), "bad digest")
+
+		c = Column{Key: k}
+		require.Equal(t, ErrDigest, c.Scan(e), "no digest")
+
+		require.Equal(t, ErrColumnType, c.Scan(42), "not text")
+	})
+}
@@ -11,16 +11,44 @@ import (
 	"encoding/hex"
 	"errors"
 	"io"
+
+	"github.com/google/uuid"
+	"grokloc.com/pkg/security/key"
 )
 
+// formatV1 is the first byte of every ciphertext `Encrypt` produces,
+// followed by the GCM nonce and the sealed value. Ciphertexts written
+// before the format byte existed start directly with the nonce; they
+// are still accepted by `Decrypt`.
+const formatV1 byte = 1
+
 var (
-	ErrDigest = errors.New("value does not have correct digest")
-	ErrNonce  = errors.New("nonce could not be constructed")
+	ErrDigest            = errors.New("value does not have correct digest")
+	ErrNonce             = errors.New("nonce could not be constructed")
+	ErrUnsupportedFormat = errors.New("ciphertext format not supported")
+	ErrZeroKey           = errors.New("key is all zero bytes")
 )
 
-// Encrypt returns the hex-encoded AES symmetric encryption
-// of s with key.
+// allowZeroKey disables the `ErrZeroKey` check. Only tests in this
+// package may set it.
+var allowZeroKey bool
+
+func zeroKey(key []byte) bool {
+	for _, b := range key {
+		if b != 0 {
+			return false
+		}
+	}
+	return len(key) != 0
+}
+
+// Encrypt returns the hex-encoded AES symmetric encryption of s with
+// key, prefixed by the `formatV1` byte. An all-zero key, almost
+// certainly one that was never set, returns `ErrZeroKey`.
 func Encrypt(s string, key []byte) (string, error) {
+	if zeroKey(key) && !allowZeroKey {
+		return "", ErrZeroKey
+	}
 	c, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
@@ -34,11 +62,30 @@ func Encrypt(s string, key []byte) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return hex.EncodeToString(gcm.Seal(nonce, nonce, []byte(s), nil)), nil
+	prefix := append([]byte{formatV1}, nonce...)
+	return hex.EncodeToString(gcm.Seal(prefix, nonce, []byte(s), nil)), nil
+}
+
+// open splits the nonce from the front of `d` and opens the rest.
+func open(gcm cipher.AEAD, d []byte) ([]byte, error) {
+	nonceSize := gcm.NonceSize()
+	if len(d) < nonceSize {
+		return nil, ErrNonce
+	}
+	nonce, msg := d[:nonceSize], d[nonceSize:]
+	return gcm.Open(nil, nonce, msg, nil) // #nosec G407
 }
 
 // Decrypt reverses the value e produced by Encrypt. Decrypted value
 // must have a sha256 that matches expectedDigest.
+//
+// A legacy ciphertext has no format byte, so its first nonce byte can
+// look like one; when a `formatV1` value fails to open it is retried as
+// legacy, which GCM authentication makes safe. A value that does not
+// start with `formatV1` is opened as legacy, and if that fails the GCM
+// error is returned, as for a wrong key. Only if the value instead opens
+// once its first byte is skipped is that byte an unknown format byte,
+// and `ErrUnsupportedFormat` is returned.
 func Decrypt(e, expectedDigest string, key []byte) (string, error) {
 	d, err := hex.DecodeString(e)
 	if err != nil {
@@ -52,14 +99,28 @@ func Decrypt(e, expectedDigest string, key []byte) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	nonceSize := gcm.NonceSize()
-	if len(d) < nonceSize {
+	if len(d) == 0 {
 		return "", ErrNonce
 	}
-	nonce, msg := d[:nonceSize], d[nonceSize:]
-	bs, err := gcm.Open(nil, nonce, msg, nil) // #nosec G407
-	if err != nil {
-		return "", err
+	var bs []byte
+	if d[0] == formatV1 {
+		bs, err = open(gcm, d[1:])
+		if err != nil {
+			legacy, legacyErr := open(gcm, d)
+			if legacyErr != nil {
+				return "", err
+			}
+			bs = legacy
+		}
+	} else {
+		bs, err = open(gcm, d)
+		if err != nil {
+			_, prefixedErr := open(gcm, d[1:])
+			if prefixedErr == nil {
+				return "", ErrUnsupportedFormat
+			}
+			return "", err
+		}
 	}
 	sum := sha256.Sum256(bs)
 	if hex.EncodeToString(sum[:]) != expectedDigest {
@@ -67,3 +128,37 @@ func Decrypt(e, expectedDigest string, key []byte) (string, error) {
 	}
 	return string(bs), nil
 }
+
+// DecryptWithAnyKey tries to `Decrypt` e with each key in p, and returns
+// the decrypted value and the version of the key that worked. If no key
+// works, `key.ErrNotFound` is returned. Keys are tried in
+// `SortedVersions` order, so the result does not depend on map order.
+//
+// A non-empty `label` derives the candidate key for a field encrypted
+// under `key.DeriveField`, such as a user PII column; see
+// `user.EncryptedFields`. An empty `label` uses each key as is.
+//
+// This is a recovery tool for values whose key version is not known. It
+// costs O(len(versions)) decryption attempts, so it is not for hot paths.
+func DecryptWithAnyKey(
+	e, expectedDigest string,
+	p key.KeyProvider,
+	label string,
+) (string, uuid.UUID, error) {
+	for _, version := range p.SortedVersions() {
+		versioned, err := p.Get(version)
+		if err != nil {
+			// Removed since it was listed.
+			continue
+		}
+		k := versioned.Key
+		if label != "" {
+			k = key.DeriveField(k, label)
+		}
+		s, err := Decrypt(e, expectedDigest, k)
+		if err == nil {
+			return s, version, nil
+		}
+	}
+	return "", uuid.Nil, key.ErrNotFound
+}
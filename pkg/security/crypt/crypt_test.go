@@ -34,4 +34,83 @@ func TestCrypt(t *testing.T) {
 		require.Error(t, err, "bad digest")
 		require.Equal(t, ErrDigest, err, "digest err")
 	})
+
+	t.Run("Format", func(t *testing.T) {
+		t.Parallel()
+		k := key.Random()
+		s := uuid.NewString()
+		e, err := Encrypt(s, k)
+		require.NoError(t, err, "encrypt fail")
+		require.Equal(t, "01", e[:2], "format byte")
+		digestBytes := sha256.Sum256([]byte(s))
+
+		// An unknown format byte.
+		_, err = Decrypt("02"+e[2:], hex.EncodeToString(digestBytes[:]), k)
+		require.Error(t, err, "unknown format")
+		require.Equal(t, ErrUnsupportedFormat, err, "format err")
+
+		// A legacy value with the wrong key is not a format error.
+		legacy, err := Encrypt(s, k)
+		require.NoError(t, err, "encrypt fail")
+		_, err = Decrypt(legacy[2:], hex.EncodeToString(digestBytes[:]), key.Random())
+		require.Error(t, err, "wrong key")
+		require.NotErrorIs(t, err, ErrUnsupportedFormat, "open err")
+
+		_, err = Decrypt("", hex.EncodeToString(digestBytes[:]), k)
+		require.Equal(t, ErrNonce, err, "empty")
+	})
+
+	t.Run("DecryptWithAnyKey", func(t *testing.T) {
+		t.Parallel()
+		m := make(key.VersionedMap)
+		for range 3 {
+			m[uuid.New()] = key.Random()
+		}
+		version := uuid.New()
+		m[version] = key.Random()
+
+		s := uuid.NewString()
+		e, err := Encrypt(s, m[version])
+		require.NoError(t, err, "encrypt fail")
+		digestBytes := sha256.Sum256([]byte(s))
+
+		d, foundVersion, err := DecryptWithAnyKey(
+			e,
+			hex.EncodeToString(digestBytes[:]),
+			m,
+			"",
+		)
+		require.NoError(t, err, "decrypt fail")
+		require.Equal(t, s, d, "round trip")
+		require.Equal(t, version, foundVersion, "version")
+
+		// A field key is derived from the version key with its label.
+		fieldE, err := Encrypt(s, key.DeriveField(m[version], "email"))
+		require.NoError(t, err, "encrypt field")
+		_, _, err = DecryptWithAnyKey(fieldE, hex.EncodeToString(digestBytes[:]), m, "")
+		require.Equal(t, key.ErrNotFound, err, "field needs label")
+		d, foundVersion, err = DecryptWithAnyKey(fieldE, hex.EncodeToString(digestBytes[:]), m, "email")
+		require.NoError(t, err, "decrypt field")
+		require.Equal(t, s, d, "field round trip")
+		require.Equal(t, version, foundVersion, "field version")
+
+		// No key works once the encrypting key is removed.
+		delete(m, version)
+		_, _, err = DecryptWithAnyKey(e, hex.EncodeToString(digestBytes[:]), m, "")
+		require.Error(t, err, "no key")
+		require.Equal(t, key.ErrNotFound, err, "not found err")
+	})
+
+	t.Run("ZeroKey", func(t *testing.T) {
+		// Not parallel, since it sets allowZeroKey.
+		k := make([]byte, len(key.Random()))
+		_, err := Encrypt("plaintext", k)
+		require.Error(t, err, "zero key")
+		require.Equal(t, ErrZeroKey, err, "zero key err")
+
+		allowZeroKey = true
+		defer func() { allowZeroKey = false }()
+		_, err = Encrypt("plaintext", k)
+		require.NoError(t, err, "allowed zero key")
+	})
 }
@@ -0,0 +1,58 @@
+/*
+Package crypt contains crytographic utilities.
+*/
+package crypt
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"grokloc.com/pkg/security/key"
+)
+
+// gcmNonceSize is the nonce size of `cipher.NewGCM`.
+const gcmNonceSize = 12
+
+// NonceOf returns the nonce that `Encrypt` prepended to `e`.
+// It is for tests only.
+func NonceOf(e string) ([]byte, error) {
+	d, err := hex.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+	if len(d) < 1+gcmNonceSize {
+		return nil, ErrNonce
+	}
+	return d[1 : 1+gcmNonceSize], nil
+}
+
+func TestNonce(t *testing.T) {
+	t.Run("NonceOf", func(t *testing.T) {
+		t.Parallel()
+		_, err := NonceOf("not hex")
+		require.Error(t, err, "not hex")
+
+		_, err = NonceOf("abcd")
+		require.Error(t, err, "short")
+		require.Equal(t, ErrNonce, err, "nonce err")
+	})
+
+	t.Run("NoReuse", func(t *testing.T) {
+		t.Parallel()
+		const n = 10000
+		k := key.Random()
+		seen := make(map[string]struct{}, n)
+		for range n {
+			// The same plaintext every time; only the nonce varies.
+			e, err := Encrypt("plaintext", k)
+			require.NoError(t, err, "encrypt fail")
+			nonce, err := NonceOf(e)
+			require.NoError(t, err, "nonce")
+			require.Len(t, nonce, gcmNonceSize, "nonce size")
+			_, ok := seen[string(nonce)]
+			require.False(t, ok, "nonce reused")
+			seen[string(nonce)] = struct{}{}
+		}
+	})
+}
@@ -0,0 +1,41 @@
+/*
+Package crypt contains crytographic utilities.
+*/
+package crypt
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestVectors decrypts ciphertexts produced by an earlier `Encrypt`.
+// If it fails, existing rows can no longer be read; do not regenerate
+// the vectors to make it pass.
+func TestVectors(t *testing.T) {
+	t.Run("Decrypt", func(t *testing.T) {
+		t.Parallel()
+		b, err := os.ReadFile("testdata/vectors.json")
+		require.NoError(t, err, "read vectors")
+
+		var vectors []struct {
+			Key        string `json:"key"`
+			Plaintext  string `json:"plaintext"`
+			Ciphertext string `json:"ciphertext"`
+			Digest     string `json:"digest"`
+		}
+		require.NoError(t, json.Unmarshal(b, &vectors), "unmarshal vectors")
+		require.NotEmpty(t, vectors, "vectors")
+
+		for _, v := range vectors {
+			k, err := hex.DecodeString(v.Key)
+			require.NoError(t, err, "key")
+			d, err := Decrypt(v.Ciphertext, v.Digest, k)
+			require.NoError(t, err, "decrypt %q", v.Plaintext)
+			require.Equal(t, v.Plaintext, d, "plaintext")
+		}
+	})
+}
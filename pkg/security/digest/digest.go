@@ -5,12 +5,58 @@ package digest
 
 import (
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
+	"errors"
+	"hash"
 )
 
-// SHA256Hex returns the hex-encoded sha256 digest of `s`.
-func SHA256Hex(s string) string {
-	hasher := sha256.New()
+// Algorithm selects the hash used by `Hash`.
+type Algorithm int
+
+const (
+	SHA256 Algorithm = iota + 1
+	SHA512
+)
+
+var (
+	ErrAlgorithm = errors.New("digest algorithm not recognized")
+)
+
+// Hash returns the hex-encoded `alg` digest of `s`. It panics if
+// `alg` is not one of the defined algorithms.
+func Hash(alg Algorithm, s string) string {
+	var hasher hash.Hash
+	switch alg {
+	case SHA256:
+		hasher = sha256.New()
+	case SHA512:
+		hasher = sha512.New()
+	default:
+		panic(ErrAlgorithm.Error())
+	}
 	hasher.Write([]byte(s))
 	return hex.EncodeToString(hasher.Sum(nil))
 }
+
+// SHA256Hex returns the hex-encoded sha256 digest of `s`.
+func SHA256Hex(s string) string {
+	return Hash(SHA256, s)
+}
+
+// Parse infers the algorithm of a stored digest from its length,
+// which is the marker that distinguishes them; digests stored before
+// `Algorithm` existed are sha256 and parse as such.
+func Parse(d string) (Algorithm, error) {
+	if _, err := hex.DecodeString(d); err != nil {
+		return 0, ErrAlgorithm
+	}
+	switch len(d) {
+	case sha256.Size * 2:
+		return SHA256, nil
+	case sha512.Size * 2:
+		return SHA512, nil
+	default:
+		return 0, ErrAlgorithm
+	}
+}
@@ -0,0 +1,38 @@
+/*
+Package digest provides digest encoding utilities.
+*/
+package digest
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigest(t *testing.T) {
+	t.Run("Hash", func(t *testing.T) {
+		t.Parallel()
+		s := uuid.NewString()
+		require.Equal(t, SHA256Hex(s), Hash(SHA256, s), "sha256")
+		require.NotEqual(t, Hash(SHA256, s), Hash(SHA512, s), "sha512")
+		require.Panics(t, func() { Hash(Algorithm(0), s) }, "unknown")
+	})
+
+	t.Run("Parse", func(t *testing.T) {
+		t.Parallel()
+		s := uuid.NewString()
+		for _, alg := range []Algorithm{SHA256, SHA512} {
+			parsed, err := Parse(Hash(alg, s))
+			require.NoError(t, err, "parse")
+			require.Equal(t, alg, parsed, "algorithm")
+		}
+
+		_, err := Parse("abcd")
+		require.Error(t, err, "short")
+		require.Equal(t, ErrAlgorithm, err, "algorithm err")
+
+		_, err = Parse(uuid.NewString() + uuid.NewString()) // 72 chars, not hex
+		require.Error(t, err, "not hex")
+	})
+}
@@ -9,6 +9,8 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"runtime"
+	"sync"
 )
 
 // Random produces PEM-encoded public and private Ed25519 key strings.
@@ -82,3 +84,61 @@ func ImportPublicPEM(publicPEM string) (ed25519.PublicKey, error) {
 
 	return ed25519Key, nil
 }
+
+// Verify reports whether sig is a valid signature of msg by the
+// PEM-encoded public key.
+func Verify(publicPEM string, msg, sig []byte) (bool, error) {
+	publicKey, err := ImportPublicPEM(publicPEM)
+	if err != nil {
+		return false, err
+	}
+	return ed25519.Verify(publicKey, msg, sig), nil
+}
+
+// IsValidPublicPEM reports whether `s` is a PEM-encoded Ed25519
+// public key, as `ImportPublicPEM` accepts.
+func IsValidPublicPEM(s string) bool {
+	_, err := ImportPublicPEM(s)
+	return err == nil
+}
+
+// IsValidPrivatePEM reports whether `s` is a PEM-encoded Ed25519
+// private key, as `ImportPrivatePEM` accepts.
+func IsValidPrivatePEM(s string) bool {
+	_, err := ImportPrivatePEM(s)
+	return err == nil
+}
+
+// VerifyItem is a signature to check with `VerifyBatch`.
+type VerifyItem struct {
+	PublicPEM string
+	Msg       []byte
+	Sig       []byte
+}
+
+// VerifyBatch reports, for each item, whether its signature is valid
+// as `Verify` would. An item with a malformed key is reported invalid.
+// The standard library has no batch verification, so items are checked
+// concurrently on up to GOMAXPROCS goroutines.
+func VerifyBatch(items []VerifyItem) []bool {
+	results := make([]bool, len(items))
+	workers := min(runtime.GOMAXPROCS(0), len(items))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				ok, err := Verify(items[i].PublicPEM, items[i].Msg, items[i].Sig)
+				results[i] = err == nil && ok
+			}
+		}()
+	}
+	for i := range items {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
@@ -5,6 +5,13 @@ for Ed25519 keys.
 package ed25519
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -21,3 +28,89 @@ func TestGenerate(t *testing.T) {
 		require.NoError(t, err, "private pem")
 	})
 }
+
+func TestVerify(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		ed25519PublicPEM, ed25519PrivatePEM, err := Random()
+		require.NoError(t, err, "random")
+		privateKey, err := ImportPrivatePEM(ed25519PrivatePEM)
+		require.NoError(t, err, "private pem")
+
+		msg := []byte("message")
+		sig := ed25519.Sign(privateKey, msg)
+		ok, err := Verify(ed25519PublicPEM, msg, sig)
+		require.NoError(t, err, "verify")
+		require.True(t, ok, "valid signature")
+
+		ok, err = Verify(ed25519PublicPEM, []byte("other"), sig)
+		require.NoError(t, err, "verify")
+		require.False(t, ok, "invalid signature")
+
+		_, err = Verify("not pem", msg, sig)
+		require.Error(t, err, "bad pem")
+	})
+}
+
+func TestIsValidPEM(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		ed25519PublicPEM, ed25519PrivatePEM, err := Random()
+		require.NoError(t, err, "random")
+		require.True(t, IsValidPublicPEM(ed25519PublicPEM), "public")
+		require.True(t, IsValidPrivatePEM(ed25519PrivatePEM), "private")
+
+		// Swapped.
+		require.False(t, IsValidPublicPEM(ed25519PrivatePEM), "private as public")
+		require.False(t, IsValidPrivatePEM(ed25519PublicPEM), "public as private")
+
+		require.False(t, IsValidPublicPEM("not pem"), "public not pem")
+		require.False(t, IsValidPrivatePEM(""), "private empty")
+
+		// A well-formed key of another type.
+		ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err, "ecdsa")
+		publicBytes, err := x509.MarshalPKIXPublicKey(&ecdsaKey.PublicKey)
+		require.NoError(t, err, "marshal public")
+		privateBytes, err := x509.MarshalPKCS8PrivateKey(ecdsaKey)
+		require.NoError(t, err, "marshal private")
+		require.False(t, IsValidPublicPEM(string(pem.EncodeToMemory(&pem.Block{
+			Type:  "PUBLIC KEY",
+			Bytes: publicBytes,
+		}))), "ecdsa public")
+		require.False(t, IsValidPrivatePEM(string(pem.EncodeToMemory(&pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: privateBytes,
+		}))), "ecdsa private")
+	})
+}
+
+func TestVerifyBatch(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		var items []VerifyItem
+		var want []bool
+		for i := range 50 {
+			ed25519PublicPEM, ed25519PrivatePEM, err := Random()
+			require.NoError(t, err, "random")
+			privateKey, err := ImportPrivatePEM(ed25519PrivatePEM)
+			require.NoError(t, err, "private pem")
+
+			msg := []byte(fmt.Sprintf("message %d", i))
+			sig := ed25519.Sign(privateKey, msg)
+			switch i % 3 {
+			case 0:
+				items = append(items, VerifyItem{ed25519PublicPEM, msg, sig})
+				want = append(want, true)
+			case 1:
+				items = append(items, VerifyItem{ed25519PublicPEM, []byte("other"), sig})
+				want = append(want, false)
+			default:
+				items = append(items, VerifyItem{"not pem", msg, sig})
+				want = append(want, false)
+			}
+		}
+		require.Equal(t, want, VerifyBatch(items), "results")
+		require.Empty(t, VerifyBatch(nil), "empty")
+	})
+}
@@ -6,6 +6,8 @@ package jwt
 
 import (
 	"errors"
+	"fmt"
+	"slices"
 	"time"
 
 	go_jwt "github.com/golang-jwt/jwt/v5"
@@ -15,29 +17,234 @@ import (
 const (
 	Expiration        = 86400
 	AuthorizationType = "Bearer"
+	DefaultIssuer     = "GrokLOC.com"
+
+	// ForTestTTL is the lifetime of tokens from `ForTest`.
+	ForTestTTL = 5 * time.Minute
+)
+
+var (
+	ErrAlgorithm              = errors.New("token alg not allowed")
+	ErrIncorrectSigningMethod = errors.New("signing method not HS256")
+	ErrSignatureClaim         = errors.New("sig claim missing or malformed")
+	ErrClaims                 = errors.New("token claims not GrokClaims")
+	ErrExpired                = errors.New("token expired")
+	ErrSignatureInvalid       = errors.New("token signature invalid")
+	ErrMalformed              = errors.New("token malformed")
+	ErrNotYetValid            = errors.New("token not yet valid")
 )
 
-var ErrIncorrectSigningMethod = errors.New("signing method not HS256")
+// GrokClaims are the claims of a GrokLOC token. `Org`, `Role`,
+// `Signature`, and `Owner` are optional and omitted when zero.
+type GrokClaims struct {
+	Issuer    string              `json:"iss"`
+	Subject   uuid.UUID           `json:"sub"`
+	Org       uuid.UUID           `json:"org,omitzero"`
+	Role      int                 `json:"role,omitempty"`
+	Signature uuid.UUID           `json:"sig,omitzero"`
+	Owner     bool                `json:"owner,omitempty"`
+	NotBefore *go_jwt.NumericDate `json:"nbf,omitempty"`
+	IssuedAt  *go_jwt.NumericDate `json:"iat,omitempty"`
+	ExpiresAt *go_jwt.NumericDate `json:"exp,omitempty"`
+}
+
+var _ go_jwt.Claims = (*GrokClaims)(nil)
+
+// NewClaims returns claims for `sub` issued by `issuer` now and
+// expiring after `Expiration` seconds. An empty `issuer` is replaced
+// with `DefaultIssuer`.
+func NewClaims(sub uuid.UUID, issuer string) *GrokClaims {
+	now := time.Now().Truncate(time.Second)
+	return &GrokClaims{
+		Issuer:    issuerOrDefault(issuer),
+		Subject:   sub,
+		NotBefore: go_jwt.NewNumericDate(now),
+		IssuedAt:  go_jwt.NewNumericDate(now),
+		ExpiresAt: go_jwt.NewNumericDate(now.Add(Expiration * time.Second)),
+	}
+}
+
+// Encode produces a signed JWT with claims `c`.
+func (c *GrokClaims) Encode(signingKey []byte) (string, error) {
+	return go_jwt.NewWithClaims(go_jwt.SigningMethodHS256, c).
+		SignedString(signingKey)
+}
+
+func (c *GrokClaims) GetExpirationTime() (*go_jwt.NumericDate, error) {
+	return c.ExpiresAt, nil
+}
+
+func (c *GrokClaims) GetIssuedAt() (*go_jwt.NumericDate, error) {
+	return c.IssuedAt, nil
+}
+
+func (c *GrokClaims) GetNotBefore() (*go_jwt.NumericDate, error) {
+	return c.NotBefore, nil
+}
+
+func (c *GrokClaims) GetIssuer() (string, error) {
+	return c.Issuer, nil
+}
+
+func (c *GrokClaims) GetSubject() (string, error) {
+	return c.Subject.String(), nil
+}
+
+func (c *GrokClaims) GetAudience() (go_jwt.ClaimStrings, error) {
+	return nil, nil
+}
 
-// Encode produces a signed JWT.
-func Encode(sub uuid.UUID, signingKey []byte) (string, error) {
-	now := time.Now().Unix()
-	tok := go_jwt.NewWithClaims(go_jwt.SigningMethodHS256, go_jwt.MapClaims{
-		"iss": "GrokLOC.com",
-		"sub": sub.String(),
-		"nbf": now,
-		"iat": now,
-		"exp": now + Expiration,
-	})
-	return tok.SignedString(signingKey)
+// Claims returns the claims of a token from `Decode`.
+func Claims(token *go_jwt.Token) (*GrokClaims, error) {
+	c, ok := token.Claims.(*GrokClaims)
+	if !ok {
+		return nil, ErrClaims
+	}
+	return c, nil
 }
 
-// Decode takes the string returned by `Encode` and decodes the token.
-func Decode(tokenStr string, signingKey []byte) (*go_jwt.Token, error) {
-	return go_jwt.Parse(tokenStr, func(token *go_jwt.Token) (interface{}, error) {
+// Encode produces a signed JWT issued by `issuer`. An empty `issuer`
+// is replaced with `DefaultIssuer`.
+func Encode(sub uuid.UUID, issuer string, signingKey []byte) (string, error) {
+	return NewClaims(sub, issuer).Encode(signingKey)
+}
+
+// EncodeDelayed produces a signed JWT like `Encode` that is not valid
+// until `notBefore`, and expires `ttl` after it. This supports granting
+// access on a schedule.
+func EncodeDelayed(
+	sub uuid.UUID,
+	issuer string,
+	signingKey []byte,
+	notBefore time.Time,
+	ttl time.Duration,
+) (string, error) {
+	c := NewClaims(sub, issuer)
+	notBefore = notBefore.Truncate(time.Second)
+	c.NotBefore = go_jwt.NewNumericDate(notBefore)
+	c.ExpiresAt = go_jwt.NewNumericDate(notBefore.Add(ttl))
+	return c.Encode(signingKey)
+}
+
+// ForTest produces a signed JWT for `sub`, usually the id of a user from
+// `user.ForTest`, with `sig` as its `sig` claim, that expires after
+// `ForTestTTL`. It panics on error, like the other `ForTest` helpers,
+// and is for test automation only.
+func ForTest(sub uuid.UUID, sig uuid.UUID, issuer string, signingKey []byte) string {
+	c := NewClaims(sub, issuer)
+	c.Signature = sig
+	now := time.Now().Truncate(time.Second)
+	c.NotBefore = go_jwt.NewNumericDate(now)
+	c.ExpiresAt = go_jwt.NewNumericDate(now.Add(ForTestTTL))
+	tokenStr, err := c.Encode(signingKey)
+	if err != nil {
+		panic(err.Error())
+	}
+	return tokenStr
+}
+
+// EncodeClaims produces a signed JWT like `Encode`, adding the `sig`
+// claim for the current signature of the subject's row. A token is
+// revoked by changing that signature, which every update of the row
+// does; see `user.ValidateToken`.
+//
+// The `owner` claim is true if `sub` is `orgOwner`, the owner of the
+// subject's org, so owner checks can skip a database read. It is a
+// snapshot taken when the token was issued: ownership may have changed
+// since, so sensitive operations must re-verify it.
+func EncodeClaims(
+	sub uuid.UUID,
+	sig uuid.UUID,
+	orgOwner uuid.UUID,
+	issuer string,
+	signingKey []byte,
+) (string, error) {
+	c := NewClaims(sub, issuer)
+	c.Signature = sig
+	c.Owner = sub == orgOwner
+	return c.Encode(signingKey)
+}
+
+// Signature returns the `sig` claim of a decoded token.
+func Signature(token *go_jwt.Token) (uuid.UUID, error) {
+	c, err := Claims(token)
+	if err != nil || c.Signature == uuid.Nil {
+		return uuid.Nil, ErrSignatureClaim
+	}
+	return c.Signature, nil
+}
+
+// Owner returns the `owner` claim of a decoded token, or false if it
+// is missing. See `EncodeClaims` for the limits of the claim.
+func Owner(token *go_jwt.Token) bool {
+	c, err := Claims(token)
+	return err == nil && c.Owner
+}
+
+// Decode takes the string returned by `Encode` and decodes the token,
+// whose claims are `GrokClaims`; see `Claims`. The token must have
+// been issued by `issuer`; an empty `issuer` is replaced with
+// `DefaultIssuer`.
+//
+// Expired, not yet valid, badly signed, and malformed tokens return
+// `ErrExpired`, `ErrNotYetValid`, `ErrSignatureInvalid`, and
+// `ErrMalformed` respectively, wrapping the underlying error.
+func Decode(tokenStr string, issuer string, signingKey []byte) (*go_jwt.Token, error) {
+	token, err := go_jwt.ParseWithClaims(tokenStr, &GrokClaims{}, func(token *go_jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*go_jwt.SigningMethodHMAC); !ok {
 			return nil, ErrIncorrectSigningMethod
 		}
 		return signingKey, nil
-	})
+	}, go_jwt.WithIssuer(issuerOrDefault(issuer)))
+	return token, decodeErr(err)
+}
+
+// KeyFunc returns the key that verifies `token`. It is only called
+// once the token's `alg` has been allowed.
+type KeyFunc func(token *go_jwt.Token) (any, error)
+
+// DecodeWithAlgorithms is `Decode` for tokens that may be signed with
+// any algorithm in `allowed`, for example "HS256" and "EdDSA", each
+// verified with the key `keyFunc` returns. A token whose `alg` is not
+// in `allowed` returns `ErrAlgorithm` before `keyFunc` is called, so a
+// key of one algorithm can never verify a token claiming another. An
+// empty `allowed` rejects every token.
+func DecodeWithAlgorithms(
+	tokenStr string,
+	issuer string,
+	keyFunc KeyFunc,
+	allowed []string,
+) (*go_jwt.Token, error) {
+	token, err := go_jwt.ParseWithClaims(tokenStr, &GrokClaims{}, func(token *go_jwt.Token) (interface{}, error) {
+		if !slices.Contains(allowed, token.Method.Alg()) {
+			return nil, ErrAlgorithm
+		}
+		return keyFunc(token)
+	}, go_jwt.WithIssuer(issuerOrDefault(issuer)))
+	return token, decodeErr(err)
+}
+
+// decodeErr maps the errors of `go_jwt.ParseWithClaims` to the errors
+// documented on `Decode`, wrapping the original.
+func decodeErr(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, go_jwt.ErrTokenExpired):
+		return fmt.Errorf("%w: %w", ErrExpired, err)
+	case errors.Is(err, go_jwt.ErrTokenNotValidYet):
+		return fmt.Errorf("%w: %w", ErrNotYetValid, err)
+	case errors.Is(err, go_jwt.ErrTokenSignatureInvalid):
+		return fmt.Errorf("%w: %w", ErrSignatureInvalid, err)
+	case errors.Is(err, go_jwt.ErrTokenMalformed):
+		return fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+	return err
+}
+
+func issuerOrDefault(issuer string) string {
+	if issuer == "" {
+		return DefaultIssuer
+	}
+	return issuer
 }
@@ -6,7 +6,9 @@ package jwt
 
 import (
 	"testing"
+	"time"
 
+	go_jwt "github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 	"grokloc.com/pkg/security/key"
@@ -18,15 +20,214 @@ func TestJWT(t *testing.T) {
 		sub, err := uuid.NewRandom()
 		require.NoError(t, err, "random")
 		signingKey := key.Random()
-		tokenStr, err := Encode(sub, signingKey)
+		tokenStr, err := Encode(sub, DefaultIssuer, signingKey)
 		require.NoError(t, err, "Encode")
-		token, err := Decode(tokenStr, signingKey)
+		token, err := Decode(tokenStr, DefaultIssuer, signingKey)
 		require.NoError(t, err, "Decode")
 		claimsSub, err := token.Claims.GetSubject()
 		require.NoError(t, err, "GetSubject")
 		require.Equal(t, claimsSub, sub.String())
 
-		_, err = Decode(tokenStr, key.Random())
+		_, err = Decode(tokenStr, DefaultIssuer, key.Random())
 		require.Error(t, err, "bad signing key")
+		require.ErrorIs(t, err, ErrSignatureInvalid, "signature err")
+		require.ErrorIs(t, err, go_jwt.ErrTokenSignatureInvalid, "wrapped")
+
+		_, err = Decode("not a token", DefaultIssuer, signingKey)
+		require.Error(t, err, "malformed")
+		require.ErrorIs(t, err, ErrMalformed, "malformed err")
+
+		past := time.Now().Add(-time.Hour)
+		c := NewClaims(sub, DefaultIssuer)
+		c.NotBefore = go_jwt.NewNumericDate(past)
+		c.IssuedAt = go_jwt.NewNumericDate(past)
+		c.ExpiresAt = go_jwt.NewNumericDate(past.Add(time.Second))
+		tokenStr, err = c.Encode(signingKey)
+		require.NoError(t, err, "encode")
+		_, err = Decode(tokenStr, DefaultIssuer, signingKey)
+		require.Error(t, err, "expired")
+		require.ErrorIs(t, err, ErrExpired, "expired err")
+	})
+
+	t.Run("Algorithms", func(t *testing.T) {
+		t.Parallel()
+		sub := uuid.New()
+		signingKey := key.Random()
+		tokenStr, err := Encode(sub, DefaultIssuer, signingKey)
+		require.NoError(t, err, "encode")
+
+		called := false
+		keyFunc := func(*go_jwt.Token) (any, error) {
+			called = true
+			return signingKey, nil
+		}
+
+		token, err := DecodeWithAlgorithms(tokenStr, DefaultIssuer, keyFunc, []string{"EdDSA", "HS256"})
+		require.NoError(t, err, "allowed")
+		require.True(t, called, "key func called")
+		claims, err := Claims(token)
+		require.NoError(t, err, "claims")
+		require.Equal(t, sub, claims.Subject, "sub")
+
+		for _, allowed := range [][]string{{"EdDSA"}, {"HS512"}, nil} {
+			called = false
+			_, err = DecodeWithAlgorithms(tokenStr, DefaultIssuer, keyFunc, allowed)
+			require.ErrorIs(t, err, ErrAlgorithm, "not allowed %v", allowed)
+			require.False(t, called, "key func not called %v", allowed)
+		}
+
+		_, err = DecodeWithAlgorithms(tokenStr, DefaultIssuer, func(*go_jwt.Token) (any, error) {
+			return key.Random(), nil
+		}, []string{"HS256"})
+		require.ErrorIs(t, err, ErrSignatureInvalid, "bad key")
+	})
+
+	t.Run("ForTest", func(t *testing.T) {
+		t.Parallel()
+		sub, sig := uuid.New(), uuid.New()
+		signingKey := key.Random()
+		token, err := Decode(ForTest(sub, sig, DefaultIssuer, signingKey), DefaultIssuer, signingKey)
+		require.NoError(t, err, "decode")
+		claims, err := Claims(token)
+		require.NoError(t, err, "claims")
+		require.Equal(t, sub, claims.Subject, "sub")
+		require.WithinDuration(t, time.Now().Add(ForTestTTL), claims.ExpiresAt.Time, 2*time.Second, "ttl")
+		tokenSig, err := Signature(token)
+		require.NoError(t, err, "sig")
+		require.Equal(t, sig, tokenSig, "sig")
+	})
+
+	t.Run("Delayed", func(t *testing.T) {
+		t.Parallel()
+		sub, err := uuid.NewRandom()
+		require.NoError(t, err, "random")
+		signingKey := key.Random()
+
+		notBefore := time.Now().Add(time.Hour)
+		tokenStr, err := EncodeDelayed(sub, DefaultIssuer, signingKey, notBefore, time.Hour)
+		require.NoError(t, err, "EncodeDelayed")
+		_, err = Decode(tokenStr, DefaultIssuer, signingKey)
+		require.Error(t, err, "not yet valid")
+		require.ErrorIs(t, err, ErrNotYetValid, "not yet valid err")
+		require.ErrorIs(t, err, go_jwt.ErrTokenNotValidYet, "wrapped")
+
+		// Once nbf has passed, the token decodes.
+		notBefore = time.Now().Add(-time.Minute)
+		tokenStr, err = EncodeDelayed(sub, DefaultIssuer, signingKey, notBefore, time.Hour)
+		require.NoError(t, err, "EncodeDelayed")
+		token, err := Decode(tokenStr, DefaultIssuer, signingKey)
+		require.NoError(t, err, "Decode")
+		nbf, err := token.Claims.GetNotBefore()
+		require.NoError(t, err, "GetNotBefore")
+		require.Equal(t, notBefore.Unix(), nbf.Unix(), "nbf")
+		exp, err := token.Claims.GetExpirationTime()
+		require.NoError(t, err, "GetExpirationTime")
+		require.Equal(t, notBefore.Add(time.Hour).Unix(), exp.Unix(), "exp")
+	})
+
+	t.Run("Claims", func(t *testing.T) {
+		t.Parallel()
+		signingKey := key.Random()
+		c := NewClaims(uuid.New(), DefaultIssuer)
+		c.Org = uuid.New()
+		c.Role = 2
+		tokenStr, err := c.Encode(signingKey)
+		require.NoError(t, err, "Encode")
+		token, err := Decode(tokenStr, DefaultIssuer, signingKey)
+		require.NoError(t, err, "Decode")
+		decoded, err := Claims(token)
+		require.NoError(t, err, "Claims")
+		require.Equal(t, *c, *decoded, "round trip")
+
+		// Optional claims are omitted when zero.
+		tokenStr, err = NewClaims(c.Subject, DefaultIssuer).Encode(signingKey)
+		require.NoError(t, err, "Encode")
+		m := go_jwt.MapClaims{}
+		_, _, err = go_jwt.NewParser().ParseUnverified(tokenStr, m)
+		require.NoError(t, err, "ParseUnverified")
+		for _, claim := range []string{"org", "role", "sig", "owner"} {
+			require.NotContains(t, m, claim, claim)
+		}
+
+		// A subject that is not a uuid is malformed.
+		tokenStr, err = go_jwt.NewWithClaims(
+			go_jwt.SigningMethodHS256,
+			go_jwt.MapClaims{"iss": DefaultIssuer, "sub": "not a uuid"},
+		).SignedString(signingKey)
+		require.NoError(t, err, "sign")
+		_, err = Decode(tokenStr, DefaultIssuer, signingKey)
+		require.ErrorIs(t, err, ErrMalformed, "malformed sub")
+	})
+
+	t.Run("Issuer", func(t *testing.T) {
+		t.Parallel()
+		sub, err := uuid.NewRandom()
+		require.NoError(t, err, "random")
+		signingKey := key.Random()
+		issuer := "example.com"
+		tokenStr, err := Encode(sub, issuer, signingKey)
+		require.NoError(t, err, "Encode")
+		token, err := Decode(tokenStr, issuer, signingKey)
+		require.NoError(t, err, "Decode")
+		claimsIssuer, err := token.Claims.GetIssuer()
+		require.NoError(t, err, "GetIssuer")
+		require.Equal(t, issuer, claimsIssuer)
+
+		_, err = Decode(tokenStr, DefaultIssuer, signingKey)
+		require.Error(t, err, "wrong issuer")
+
+		// Empty issuer means DefaultIssuer.
+		tokenStr, err = Encode(sub, "", signingKey)
+		require.NoError(t, err, "Encode")
+		_, err = Decode(tokenStr, DefaultIssuer, signingKey)
+		require.NoError(t, err, "Decode")
+	})
+
+	t.Run("Signature", func(t *testing.T) {
+		t.Parallel()
+		sub := uuid.New()
+		sig := uuid.New()
+		signingKey := key.Random()
+		tokenStr, err := EncodeClaims(sub, sig, uuid.New(), DefaultIssuer, signingKey)
+		require.NoError(t, err, "EncodeClaims")
+		token, err := Decode(tokenStr, DefaultIssuer, signingKey)
+		require.NoError(t, err, "Decode")
+		claimsSig, err := Signature(token)
+		require.NoError(t, err, "Signature")
+		require.Equal(t, sig, claimsSig)
+
+		// Tokens from Encode have no sig claim.
+		tokenStr, err = Encode(sub, DefaultIssuer, signingKey)
+		require.NoError(t, err, "Encode")
+		token, err = Decode(tokenStr, DefaultIssuer, signingKey)
+		require.NoError(t, err, "Decode")
+		_, err = Signature(token)
+		require.Error(t, err, "no sig")
+		require.Equal(t, ErrSignatureClaim, err, "sig err")
+	})
+
+	t.Run("Owner", func(t *testing.T) {
+		t.Parallel()
+		sub := uuid.New()
+		signingKey := key.Random()
+
+		tokenStr, err := EncodeClaims(sub, uuid.New(), sub, DefaultIssuer, signingKey)
+		require.NoError(t, err, "EncodeClaims")
+		token, err := Decode(tokenStr, DefaultIssuer, signingKey)
+		require.NoError(t, err, "Decode")
+		require.True(t, Owner(token), "owner")
+
+		tokenStr, err = EncodeClaims(sub, uuid.New(), uuid.New(), DefaultIssuer, signingKey)
+		require.NoError(t, err, "EncodeClaims")
+		token, err = Decode(tokenStr, DefaultIssuer, signingKey)
+		require.NoError(t, err, "Decode")
+		require.False(t, Owner(token), "not owner")
+
+		// Tokens from Encode have no owner claim.
+		tokenStr, err = Encode(sub, DefaultIssuer, signingKey)
+		require.NoError(t, err, "Encode")
+		token, err = Decode(tokenStr, DefaultIssuer, signingKey)
+		require.NoError(t, err, "Decode")
+		require.False(t, Owner(token), "no claim")
 	})
 }
@@ -5,9 +5,15 @@ supporting utilties.
 package key
 
 import (
+	"bytes"
+	"crypto/hkdf"
 	"crypto/rand"
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"io"
+	"maps"
+	"slices"
 
 	"github.com/google/uuid"
 )
@@ -17,6 +23,8 @@ const (
 )
 
 var (
+	ErrEmpty    = errors.New("no keys configured")
+	ErrLength   = errors.New("key has incorrect length")
 	ErrNotFound = errors.New("key version not found")
 )
 
@@ -30,6 +38,16 @@ func Random() []byte {
 	return bs
 }
 
+// DeriveField derives a key for the field named by `label` from `base`
+// with HKDF-SHA256, so one stored key yields a distinct key per field.
+func DeriveField(base []byte, label string) []byte {
+	bs, err := hkdf.Key(sha256.New, base, nil, label, Length)
+	if err != nil {
+		panic(err)
+	}
+	return bs
+}
+
 // Versioned identifies a key with a uuid version.
 type Versioned struct {
 	Version uuid.UUID
@@ -51,3 +69,31 @@ func (v VersionedMap) Get(u uuid.UUID) (*Versioned, error) {
 		Key:     key,
 	}, nil
 }
+
+// SortedVersions returns the versions in `v` in ascending byte order.
+// Iterate with it wherever the order of keys can be observed, since
+// map order is random.
+func (v VersionedMap) SortedVersions() []uuid.UUID {
+	return slices.SortedFunc(maps.Keys(v), func(a, b uuid.UUID) int {
+		return bytes.Compare(a[:], b[:])
+	})
+}
+
+// Validate checks that `v` is usable with `current` as the current key
+// version: it must be non-empty, hold `current`, and hold only keys of
+// `Length` bytes. Run it at startup, since a bad map otherwise only
+// shows up as `ErrNotFound` on the first read.
+func (v VersionedMap) Validate(current uuid.UUID) error {
+	if len(v) == 0 {
+		return ErrEmpty
+	}
+	if _, ok := v[current]; !ok {
+		return fmt.Errorf("%w: current version %s", ErrNotFound, current)
+	}
+	for _, version := range v.SortedVersions() {
+		if len(v[version]) != Length {
+			return fmt.Errorf("%w: version %s", ErrLength, version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+/*
+Package key defines the database encryption key and provides
+supporting utilties.
+*/
+package key
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeriveField(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		base := Random()
+		email := DeriveField(base, "email")
+		require.Len(t, email, Length, "length")
+		require.Equal(t, email, DeriveField(base, "email"), "deterministic")
+		require.NotEqual(t, base, email, "not base")
+		require.NotEqual(t, email, DeriveField(base, "display_name"), "label")
+		require.NotEqual(t, email, DeriveField(Random(), "email"), "base")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		current := uuid.New()
+		m := VersionedMap{current: Random(), uuid.New(): Random()}
+		require.NoError(t, m.Validate(current), "valid")
+
+		var nilMap VersionedMap
+		require.Equal(t, ErrEmpty, nilMap.Validate(current), "nil")
+		require.Equal(t, ErrEmpty, VersionedMap{}.Validate(current), "empty")
+
+		require.ErrorIs(t, m.Validate(uuid.New()), ErrNotFound, "no current")
+
+		m[uuid.New()] = []byte("short")
+		require.ErrorIs(t, m.Validate(current), ErrLength, "length")
+	})
+}
+
+func TestSortedVersions(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		a := uuid.MustParse("00000000-0000-0000-0000-000000000001")
+		b := uuid.MustParse("00000000-0000-0000-0000-000000000002")
+		c := uuid.MustParse("ffffffff-0000-0000-0000-000000000000")
+		m := VersionedMap{c: Random(), a: Random(), b: Random()}
+		for range 10 {
+			require.Equal(t, []uuid.UUID{a, b, c}, m.SortedVersions(), "sorted")
+		}
+		require.Empty(t, VersionedMap{}.SortedVersions(), "empty")
+	})
+}
@@ -0,0 +1,63 @@
+/*
+Package key defines the database encryption key and provides
+supporting utilties.
+*/
+package key
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// KeyProvider looks up versioned keys. `VersionedMap` is the production
+// implementation. `SortedVersions` lists the versions held, for callers
+// that must try each key.
+type KeyProvider interface {
+	Get(u uuid.UUID) (*Versioned, error)
+	SortedVersions() []uuid.UUID
+}
+
+var (
+	_ KeyProvider = VersionedMap(nil)
+	_ KeyProvider = (*MemoryProvider)(nil)
+)
+
+// MemoryProvider is a `KeyProvider` for tests whose keys can be removed
+// to simulate a retired key version. It is safe for concurrent use.
+type MemoryProvider struct {
+	mu   sync.RWMutex
+	keys VersionedMap
+}
+
+// NewMemoryProvider returns a `MemoryProvider` holding `keys`. With no
+// keys, every `Get` returns `ErrNotFound`.
+func NewMemoryProvider(keys ...Versioned) *MemoryProvider {
+	p := &MemoryProvider{keys: make(VersionedMap, len(keys))}
+	for _, k := range keys {
+		p.keys[k.Version] = k.Key
+	}
+	return p
+}
+
+// Get returns the `Versioned` instance for a key.
+func (p *MemoryProvider) Get(u uuid.UUID) (*Versioned, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.keys.Get(u)
+}
+
+// SortedVersions returns the versions held in ascending byte order.
+func (p *MemoryProvider) SortedVersions() []uuid.UUID {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.keys.SortedVersions()
+}
+
+// Remove drops `version`, so later `Get` calls for it return
+// `ErrNotFound`. Removing a missing version is a no-op.
+func (p *MemoryProvider) Remove(version uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.keys, version)
+}
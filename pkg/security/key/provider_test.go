@@ -0,0 +1,39 @@
+/*
+Package key defines the database encryption key and provides
+supporting utilties.
+*/
+package key
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryProvider(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		a := Versioned{Version: uuid.New(), Key: Random()}
+		b := Versioned{Version: uuid.New(), Key: Random()}
+		p := NewMemoryProvider(a, b)
+
+		got, err := p.Get(a.Version)
+		require.NoError(t, err, "get")
+		require.Equal(t, &a, got, "key")
+
+		require.ElementsMatch(t, []uuid.UUID{a.Version, b.Version}, p.SortedVersions(), "versions")
+
+		p.Remove(a.Version)
+		require.Equal(t, []uuid.UUID{b.Version}, p.SortedVersions(), "versions after remove")
+		_, err = p.Get(a.Version)
+		require.Equal(t, ErrNotFound, err, "removed")
+		_, err = p.Get(b.Version)
+		require.NoError(t, err, "others kept")
+
+		p.Remove(uuid.New())
+
+		_, err = NewMemoryProvider().Get(b.Version)
+		require.Equal(t, ErrNotFound, err, "empty")
+	})
+}
@@ -0,0 +1,55 @@
+/*
+Package password contains Argon2 utilities.
+*/
+package password
+
+import (
+	"errors"
+	"time"
+
+	"github.com/matthewhartstonge/argon2"
+)
+
+// Memory bounds for `Calibrate`, in KiB.
+const (
+	CalibrateMinMemoryCost = 8 * 1024    // 8 MiB.
+	CalibrateMaxMemoryCost = 1024 * 1024 // 1 GiB.
+)
+
+var ErrCalibrateTarget = errors.New("calibration target not positive")
+
+// Calibrate returns the most expensive config for which a hash on this
+// host takes no longer than `target`. Memory cost is doubled from
+// `CalibrateMinMemoryCost` up to `CalibrateMaxMemoryCost`, then time
+// cost is doubled. If even the cheapest config exceeds `target`, it is
+// returned.
+//
+// Since costs double, calibration takes a small multiple of `target`.
+// Run it once per deployment and use the result as
+// `State.Argon2Config`.
+func Calibrate(target time.Duration) (argon2.Config, error) {
+	if target <= 0 {
+		return argon2.Config{}, ErrCalibrateTarget
+	}
+
+	cfg := argon2.DefaultConfig()
+	cfg.TimeCost = 1
+	cfg.MemoryCost = CalibrateMinMemoryCost
+	chosen := cfg
+	for {
+		start := time.Now()
+		_, err := cfg.Hash([]byte("calibrate"), nil)
+		if err != nil {
+			return argon2.Config{}, err
+		}
+		if time.Since(start) > target {
+			return chosen, nil
+		}
+		chosen = cfg
+		if cfg.MemoryCost < CalibrateMaxMemoryCost {
+			cfg.MemoryCost *= 2
+		} else {
+			cfg.TimeCost *= 2
+		}
+	}
+}
@@ -4,6 +4,7 @@ Package password contains Argon2 utilities.
 package password
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/google/uuid"
@@ -24,6 +25,63 @@ func Verify(guess string, encoded string) (bool, error) {
 	return argon2.VerifyEncoded([]byte(guess), []byte(encoded))
 }
 
+var ErrParametersTooWeak = errors.New("password hash parameters below minimum")
+
+// WeakParametersError is returned by `VerifyWithMinimums` for a stored
+// hash with a parameter below its minimum. It matches
+// `ErrParametersTooWeak` with `errors.Is`.
+type WeakParametersError struct {
+	Param string
+	Value uint32
+	Min   uint32
+}
+
+func (e *WeakParametersError) Error() string {
+	return fmt.Sprintf("%s: %s %d < %d", ErrParametersTooWeak, e.Param, e.Value, e.Min)
+}
+
+func (e *WeakParametersError) Unwrap() error {
+	return ErrParametersTooWeak
+}
+
+// VerifyWithMinimums is `Verify` that first checks the parameters
+// embedded in `encoded` against those of `min`, returning a
+// `*WeakParametersError` without verifying if any is lower. Since
+// `Verify` trusts the stored parameters, this stops anyone able to
+// write a stored hash from replacing it with a trivially weak one.
+// Zero fields in `minimum` are not checked.
+func VerifyWithMinimums(guess string, encoded string, minimum argon2.Config) (bool, error) {
+	raw, err := argon2.Decode([]byte(encoded))
+	if err != nil {
+		return false, err
+	}
+	for _, p := range []struct {
+		param      string
+		value, min uint32
+	}{
+		{"time cost", raw.Config.TimeCost, minimum.TimeCost},
+		{"memory cost", raw.Config.MemoryCost, minimum.MemoryCost},
+		{"parallelism", uint32(raw.Config.Parallelism), uint32(minimum.Parallelism)},
+		{"salt length", uint32(len(raw.Salt)), minimum.SaltLength}, // #nosec G115
+		{"hash length", uint32(len(raw.Hash)), minimum.HashLength}, // #nosec G115
+	} {
+		if p.value < p.min {
+			return false, &WeakParametersError{Param: p.param, Value: p.value, Min: p.min}
+		}
+	}
+	return raw.Verify([]byte(guess))
+}
+
+// NeedsRehash returns true if `encoded` was hashed with parameters
+// other than those in `cfg`.
+func NeedsRehash(encoded string, cfg argon2.Config) (bool, error) {
+	raw, err := argon2.Decode([]byte(encoded))
+	if err != nil {
+		return false, err
+	}
+	return raw.Config != cfg, nil
+}
+
 // Random generates a new random password. Mostly for testing.
 func Random() string {
 	password, err := Encode(uuid.NewString(), argon2.DefaultConfig())
@@ -32,3 +90,34 @@ func Random() string {
 	}
 	return password
 }
+
+var ErrBusy = errors.New("password verification limit reached")
+
+// Limiter caps the number of concurrent `Verify` calls, since Argon2
+// verification is CPU-intensive.
+type Limiter struct {
+	sem chan struct{}
+}
+
+// NewLimiter returns a Limiter allowing `n` concurrent `Verify` calls.
+// An `n` below 1 is raised to 1, since a Limiter allowing no calls
+// would return `ErrBusy` for every one.
+func NewLimiter(n int) *Limiter {
+	return &Limiter{sem: make(chan struct{}, max(n, 1))}
+}
+
+// Verify calls `Verify` if the limit has not been reached. If it has,
+// `ErrBusy` is returned immediately rather than waiting. A nil Limiter
+// does not limit.
+func (l *Limiter) Verify(guess string, encoded string) (bool, error) {
+	if l == nil {
+		return Verify(guess, encoded)
+	}
+	select {
+	case l.sem <- struct{}{}:
+		defer func() { <-l.sem }()
+		return Verify(guess, encoded)
+	default:
+		return false, ErrBusy
+	}
+}
@@ -5,6 +5,7 @@ package password
 
 import (
 	"testing"
+	"time"
 
 	"github.com/matthewhartstonge/argon2"
 	"github.com/stretchr/testify/require"
@@ -23,4 +24,121 @@ func TestPassword(t *testing.T) {
 		require.NoError(t, err, "verify password")
 		require.False(t, match, "match password")
 	})
+
+	t.Run("Limiter", func(t *testing.T) {
+		t.Parallel()
+		s := "my-password"
+		encoded, err := Encode(s, argon2.DefaultConfig())
+		require.NoError(t, err, "encode password")
+
+		l := NewLimiter(1)
+		match, err := l.Verify(s, encoded)
+		require.NoError(t, err, "verify password")
+		require.True(t, match, "match password")
+
+		// Occupy the only slot.
+		l.sem <- struct{}{}
+		_, err = l.Verify(s, encoded)
+		require.Error(t, err, "busy")
+		require.Equal(t, ErrBusy, err, "busy err")
+		<-l.sem
+
+		match, err = l.Verify(s, encoded)
+		require.NoError(t, err, "verify password")
+		require.True(t, match, "match password")
+
+		// A Limiter allows at least one call.
+		for _, n := range []int{0, -1} {
+			match, err = NewLimiter(n).Verify(s, encoded)
+			require.NoError(t, err, "verify password")
+			require.True(t, match, "match password")
+		}
+
+		// Nil Limiter does not limit.
+		var nilLimiter *Limiter
+		match, err = nilLimiter.Verify(s, encoded)
+		require.NoError(t, err, "verify password")
+		require.True(t, match, "match password")
+	})
+
+	t.Run("VerifyWithMinimums", func(t *testing.T) {
+		t.Parallel()
+		s := "my-password"
+		cfg := argon2.DefaultConfig()
+		encoded, err := Encode(s, cfg)
+		require.NoError(t, err, "encode password")
+
+		match, err := VerifyWithMinimums(s, encoded, cfg)
+		require.NoError(t, err, "verify password")
+		require.True(t, match, "match password")
+		match, err = VerifyWithMinimums("not", encoded, cfg)
+		require.NoError(t, err, "verify password")
+		require.False(t, match, "match password")
+
+		// A hash weaker than the minimums, as if written to the db.
+		weak := cfg
+		weak.TimeCost = 1
+		weak.MemoryCost = 1024
+		weakEncoded, err := Encode(s, weak)
+		require.NoError(t, err, "encode weak password")
+		_, err = VerifyWithMinimums(s, weakEncoded, cfg)
+		require.ErrorIs(t, err, ErrParametersTooWeak, "too weak")
+		var weakErr *WeakParametersError
+		require.ErrorAs(t, err, &weakErr, "weak err")
+		require.Equal(t, "time cost", weakErr.Param, "param")
+
+		match, err = VerifyWithMinimums(s, weakEncoded, argon2.Config{})
+		require.NoError(t, err, "no minimums")
+		require.True(t, match, "match password")
+
+		_, err = VerifyWithMinimums(s, "not argon2", cfg)
+		require.Error(t, err, "not argon2")
+	})
+
+	t.Run("NeedsRehash", func(t *testing.T) {
+		t.Parallel()
+		cfg := argon2.DefaultConfig()
+		encoded, err := Encode("my-password", cfg)
+		require.NoError(t, err, "encode password")
+		needsRehash, err := NeedsRehash(encoded, cfg)
+		require.NoError(t, err, "needs rehash")
+		require.False(t, needsRehash, "same config")
+
+		cfg.TimeCost++
+		needsRehash, err = NeedsRehash(encoded, cfg)
+		require.NoError(t, err, "needs rehash")
+		require.True(t, needsRehash, "changed config")
+
+		_, err = NeedsRehash("not argon2", cfg)
+		require.Error(t, err, "not argon2")
+	})
+}
+
+func TestCalibrate(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		target := 20 * time.Millisecond
+		start := time.Now()
+		cfg, err := Calibrate(target)
+		require.NoError(t, err, "calibrate")
+		require.Less(t, time.Since(start), 5*time.Second, "bounded")
+		require.GreaterOrEqual(t, cfg.MemoryCost, uint32(CalibrateMinMemoryCost), "min memory")
+		require.LessOrEqual(t, cfg.MemoryCost, uint32(CalibrateMaxMemoryCost), "max memory")
+		require.GreaterOrEqual(t, cfg.TimeCost, uint32(1), "time cost")
+
+		encoded, err := Encode("my-password", cfg)
+		require.NoError(t, err, "encode password")
+		match, err := Verify("my-password", encoded)
+		require.NoError(t, err, "verify password")
+		require.True(t, match, "match password")
+
+		// The cheapest config is returned if nothing meets the target.
+		cfg, err = Calibrate(time.Nanosecond)
+		require.NoError(t, err, "calibrate")
+		require.Equal(t, uint32(CalibrateMinMemoryCost), cfg.MemoryCost, "min memory")
+		require.Equal(t, uint32(1), cfg.TimeCost, "min time")
+
+		_, err = Calibrate(0)
+		require.Equal(t, ErrCalibrateTarget, err, "target")
+	})
 }
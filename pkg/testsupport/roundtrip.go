@@ -0,0 +1,53 @@
+/*
+Package testsupport provides helpers for tests that use the database.
+*/
+package testsupport
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"grokloc.com/pkg/postgresql"
+	"grokloc.com/pkg/security/key"
+)
+
+// ReadFunc reads the row with `id`, decrypting with `m` if the row has
+// encrypted fields. `user.Read` is a `ReadFunc[user.User]`.
+type ReadFunc[T any] func(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	id uuid.UUID,
+) (*T, error)
+
+// AssertRoundTrip reads the row with `id` with `read` and asserts it
+// equals `want`, the in-memory value after a mutation. The whole struct
+// is compared, so a mutation that changes a column without refreshing
+// the field, such as `Mtime` or `Signature`, fails.
+//
+// Each `normalize` is applied to copies of both values before they are
+// compared, to clear fields that legitimately differ, such as state
+// cached only in memory.
+func AssertRoundTrip[T any](
+	t *testing.T,
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	id uuid.UUID,
+	want *T,
+	read ReadFunc[T],
+	normalize ...func(*T),
+) {
+	t.Helper()
+	got, err := read(ctx, conn, m, id)
+	require.NoError(t, err, "round trip read")
+
+	wantCopy, gotCopy := *want, *got
+	for _, f := range normalize {
+		f(&wantCopy)
+		f(&gotCopy)
+	}
+	require.Equal(t, wantCopy, gotCopy, "round trip")
+}
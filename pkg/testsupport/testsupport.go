@@ -0,0 +1,73 @@
+/*
+Package testsupport provides helpers for tests that use the database.
+*/
+package testsupport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+// CancelGrace is how long after its deadline a cancelled call may take
+// to return before `AssertCancels` fails.
+const CancelGrace = 500 * time.Millisecond
+
+// SlowQuery runs a query that sleeps for `d` in the db.
+func SlowQuery(ctx context.Context, conn *pgx.Conn, d time.Duration) error {
+	_, err := conn.Exec(ctx, `select pg_sleep($1)`, d.Seconds())
+	return err
+}
+
+// LockRow starts a transaction on `conn` that holds a row lock on the
+// row in `table` with `id`, so that updates to the row on other
+// connections block. The caller must roll back the returned transaction.
+func LockRow(
+	ctx context.Context,
+	conn *pgx.Conn,
+	table string,
+	id uuid.UUID,
+) (pgx.Tx, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `select 1 from ` + pgx.Identifier{table}.Sanitize() +
+		` where id = $1 for update`
+	_, err = tx.Exec(ctx, query, id)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// AssertCancels calls `fn` with a context that expires after `deadline`
+// and asserts that `fn` returns a context error promptly.
+func AssertCancels(
+	t *testing.T,
+	deadline time.Duration,
+	fn func(ctx context.Context) error,
+) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+
+	start := time.Now()
+	err := fn(ctx)
+	elapsed := time.Since(start)
+
+	require.Error(t, err, "cancelled call")
+	require.True(t,
+		pgconn.Timeout(err) || errors.Is(err, context.DeadlineExceeded),
+		"context error: %v", err)
+	require.Less(t, elapsed, deadline+CancelGrace, "prompt return")
+}
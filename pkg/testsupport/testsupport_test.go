@@ -0,0 +1,75 @@
+/*
+Package testsupport provides helpers for tests that use the database.
+*/
+package testsupport
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"grokloc.com/pkg/postgresql"
+	"grokloc.com/pkg/runtime"
+	"grokloc.com/pkg/security/key"
+)
+
+var st *runtime.State
+
+func TestMain(m *testing.M) {
+	var stErr error
+	st, stErr = runtime.Unit()
+	if stErr != nil {
+		log.Fatal(stErr.Error())
+	}
+	m.Run()
+}
+
+func TestSlowQuery(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.RandomReplica().Acquire(context.Background())
+		require.NoError(t, err, "replica conn")
+		defer conn.Release()
+
+		err = SlowQuery(context.Background(), conn.Conn(), time.Millisecond)
+		require.NoError(t, err, "slow query")
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.RandomReplica().Acquire(context.Background())
+		require.NoError(t, err, "replica conn")
+		defer conn.Release()
+
+		AssertCancels(t, 100*time.Millisecond, func(ctx context.Context) error {
+			return SlowQuery(ctx, conn.Conn(), 10*time.Second)
+		})
+	})
+}
+
+func TestAssertRoundTrip(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		type row struct {
+			ID     uuid.UUID
+			Mtime  int64
+			cached string
+		}
+		want := &row{ID: uuid.New(), Mtime: 1, cached: "in memory"}
+		read := func(
+			_ context.Context,
+			_ postgresql.DB,
+			_ key.KeyProvider,
+			id uuid.UUID,
+		) (*row, error) {
+			return &row{ID: id, Mtime: 1}, nil
+		}
+
+		AssertRoundTrip(t, context.Background(), nil, nil, want.ID, want, read,
+			func(r *row) { r.cached = "" })
+		require.Equal(t, "in memory", want.cached, "want not modified")
+	})
+}
@@ -0,0 +1,334 @@
+/*
+Package user provides utilities to create, read, and update
+rows in the `users` database table.
+*/
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"grokloc.com/pkg/postgresql"
+	"grokloc.com/pkg/security/crypt"
+	"grokloc.com/pkg/security/digest"
+	"grokloc.com/pkg/security/key"
+)
+
+var (
+	ErrEmailNotFound    = errors.New("user email not found")
+	ErrEmailNotVerified = errors.New("user email not verified")
+)
+
+// Email is a secondary email of a user, from the `user_emails` table.
+// The primary email is `User.Email`.
+type Email struct {
+	Email       string    `db:"email"` // PII.
+	EmailDigest string    `db:"email_digest"`
+	KeyVersion  uuid.UUID `db:"key_version"`
+	Verified    bool      `db:"verified"`
+	InsertOrder int64     `db:"insert_order"`
+	Ctime       int64     `db:"ctime"`
+}
+
+// validEmail returns `ErrEmail` unless `email` is a bare address.
+func validEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return ErrEmail
+	}
+	return nil
+}
+
+// AddEmail adds `email` as an unverified secondary email of `u`,
+// encrypted under the user's key version in `m`. Adding the primary
+// email or an email already added returns `ErrEmailTaken`.
+//
+// Secondary emails are not unique within the org; that is checked when
+// one is promoted with `SetPrimaryEmail`.
+func (u *User) AddEmail(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	email string,
+) error {
+	email = NormalizeEmail(email)
+	err := validEmail(email)
+	if err != nil {
+		return err
+	}
+	if digest.SHA256Hex(email) == u.EmailDigest {
+		return ErrEmailTaken
+	}
+
+	versionedKey, err := m.Get(u.KeyVersion)
+	if err != nil {
+		return err
+	}
+
+	encryptedEmail, err := crypt.Encrypt(
+		email,
+		key.DeriveField(versionedKey.Key, emailLabel),
+	)
+	if err != nil {
+		return err
+	}
+
+	const query = `insert into user_emails
+		(user_id, email, email_digest, key_version)
+		values ($1, $2, $3, $4)
+		on conflict (user_id, email_digest) do nothing`
+
+	result, err := conn.Exec(
+		ctx,
+		query,
+		u.ID,
+		encryptedEmail,
+		digest.SHA256Hex(email),
+		versionedKey.Version,
+	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() != 1 {
+		return ErrEmailTaken
+	}
+	return nil
+}
+
+// VerifyEmail marks the secondary `email` of `u` as verified, or
+// returns `ErrEmailNotFound`. Proving that the user controls the
+// address, for example with an emailed token, is up to the caller.
+func (u *User) VerifyEmail(
+	ctx context.Context,
+	conn postgresql.DB,
+	email string,
+) error {
+	const query = `update user_emails
+		set verified = true
+		where user_id = $1 and email_digest = $2`
+
+	result, err := conn.Exec(
+		ctx,
+		query,
+		u.ID,
+		digest.SHA256Hex(NormalizeEmail(email)),
+	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() != 1 {
+		return ErrEmailNotFound
+	}
+	return nil
+}
+
+// SetPrimaryEmail swaps the verified secondary `email` of `u` with its
+// primary email, encrypting it under the user's key version in `m`. The
+// prior primary becomes a verified secondary.
+//
+// An email that is not a secondary of `u` returns `ErrEmailNotFound`,
+// and one not yet verified returns `ErrEmailNotVerified`. If another
+// user in the org has `email` as their primary, `ErrEmailTaken` is
+// returned and nothing is changed.
+func (u *User) SetPrimaryEmail(
+	ctx context.Context,
+	conn postgresql.DB,
+	actor uuid.UUID,
+	m key.KeyProvider,
+	email string,
+) error {
+	email = NormalizeEmail(email)
+	emailDigest := digest.SHA256Hex(email)
+
+	versionedKey, err := m.Get(u.KeyVersion)
+	if err != nil {
+		return err
+	}
+
+	encryptedEmail, err := crypt.Encrypt(
+		email,
+		key.DeriveField(versionedKey.Key, emailLabel),
+	)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) // nolint:errcheck
+
+	const removeQuery = `delete from user_emails
+		where user_id = $1 and email_digest = $2
+		returning verified`
+
+	var verified bool
+	err = tx.QueryRow(ctx, removeQuery, u.ID, emailDigest).Scan(&verified)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrEmailNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return ErrEmailNotVerified
+	}
+
+	// The prior primary is archived still encrypted under its key version.
+	const archiveQuery = `insert into user_emails
+		(user_id, email, email_digest, key_version, verified)
+		select id, email, email_digest, key_version, true
+		from users where id = $1`
+
+	result, err := tx.Exec(ctx, archiveQuery, u.ID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() != 1 {
+		return postgresql.ErrRowsAffected
+	}
+
+	const query = `update users
+		set email = $1,
+		email_digest = $2,
+		updated_by = $3
+		where id = $4
+		returning mtime, signature, email_digest, updated_by`
+
+	var mtime int64
+	var signature uuid.UUID
+	var updatedBy *uuid.UUID
+	err = tx.QueryRow(
+		ctx,
+		query,
+		encryptedEmail,
+		emailDigest,
+		actor,
+		u.ID,
+	).
+		Scan(
+			&mtime,
+			&signature,
+			&emailDigest,
+			&updatedBy,
+		)
+	if err != nil {
+		if name, ok := postgresql.ConstraintName(err); ok && name == "users_email_digest_org" {
+			return fmt.Errorf("%w: %w", ErrEmailTaken, err)
+		}
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	u.Email = email
+	u.EmailDigest = emailDigest
+	u.Mtime = mtime
+	u.Signature = signature
+	u.UpdatedBy = updatedBy
+	return nil
+}
+
+// Emails returns the secondary emails of `u` in the order they were
+// added, decrypted with the key version of each row in `m`.
+func (u *User) Emails(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+) ([]Email, error) {
+	const query = `select email, email_digest, key_version, verified, insert_order, ctime
+		from user_emails
+		where user_id = $1
+		order by insert_order`
+
+	rows, err := conn.Query(ctx, query, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	emails, err := pgx.CollectRows(rows, pgx.RowToStructByName[Email])
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range emails {
+		versionedKey, err := m.Get(emails[i].KeyVersion)
+		if err != nil {
+			return nil, err
+		}
+		emails[i].Email, err = crypt.Decrypt(
+			emails[i].Email,
+			emails[i].EmailDigest,
+			key.DeriveField(versionedKey.Key, emailLabel),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return emails, nil
+}
+
+// reEncryptEmails re-encrypts the secondary emails of user `id` under
+// `newKey`. As in `reEncryptEd25519History`, an email that cannot be
+// decrypted with `m` is left as it is.
+func reEncryptEmails(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	id uuid.UUID,
+	newKey key.Versioned,
+) error {
+	const selectQuery = `select email, email_digest, key_version, verified, insert_order, ctime
+		from user_emails
+		where user_id = $1
+		order by insert_order
+		for update`
+
+	rows, err := conn.Query(ctx, selectQuery, id)
+	if err != nil {
+		return err
+	}
+	emails, err := pgx.CollectRows(rows, pgx.RowToStructByName[Email])
+	if err != nil {
+		return err
+	}
+
+	const query = `update user_emails
+		set email = $1,
+		key_version = $2
+		where insert_order = $3`
+
+	for _, e := range emails {
+		if e.KeyVersion == newKey.Version {
+			continue
+		}
+		versionedKey, err := m.Get(e.KeyVersion)
+		if err != nil {
+			continue
+		}
+		email, err := crypt.Decrypt(
+			e.Email,
+			e.EmailDigest,
+			key.DeriveField(versionedKey.Key, emailLabel),
+		)
+		if err != nil {
+			continue
+		}
+		encrypted, err := crypt.Encrypt(email, key.DeriveField(newKey.Key, emailLabel))
+		if err != nil {
+			return err
+		}
+		_, err = conn.Exec(ctx, query, encrypted, newKey.Version, e.InsertOrder)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+/*
+Package user provides utilities to create, read, and update
+rows in the `users` database table.
+*/
+package user
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"grokloc.com/pkg/model/status"
+	"grokloc.com/pkg/security/digest"
+	"grokloc.com/pkg/security/key"
+)
+
+func TestEmails(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+		// `ForTest` emails are not addresses.
+		primary := uuid.NewString() + "@example.com"
+		_, err = user.Update(
+			context.Background(),
+			conn.Conn(),
+			st.StatusSink,
+			uuid.New(),
+			st.EncryptionKeys,
+			UserPatch{Email: &primary},
+		)
+		require.NoError(t, err, "update email")
+		secondary := uuid.NewString() + "@example.com"
+
+		err = user.AddEmail(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			strings.ToUpper(secondary),
+		)
+		require.NoError(t, err, "add email")
+
+		err = user.AddEmail(context.Background(), conn.Conn(), st.EncryptionKeys, secondary)
+		require.ErrorIs(t, err, ErrEmailTaken, "added twice")
+		err = user.AddEmail(context.Background(), conn.Conn(), st.EncryptionKeys, primary)
+		require.ErrorIs(t, err, ErrEmailTaken, "primary")
+		err = user.AddEmail(context.Background(), conn.Conn(), st.EncryptionKeys, "not an email")
+		require.ErrorIs(t, err, ErrEmail, "malformed")
+
+		emails, err := user.Emails(context.Background(), conn.Conn(), st.EncryptionKeys)
+		require.NoError(t, err, "emails")
+		require.Len(t, emails, 1, "one secondary")
+		require.Equal(t, secondary, emails[0].Email, "normalized")
+		require.Equal(t, digest.SHA256Hex(secondary), emails[0].EmailDigest, "digest")
+		require.False(t, emails[0].Verified, "unverified")
+
+		err = user.SetPrimaryEmail(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			st.EncryptionKeys,
+			secondary,
+		)
+		require.ErrorIs(t, err, ErrEmailNotVerified, "unverified")
+
+		err = user.VerifyEmail(context.Background(), conn.Conn(), uuid.NewString())
+		require.ErrorIs(t, err, ErrEmailNotFound, "verify missing")
+		err = user.VerifyEmail(context.Background(), conn.Conn(), secondary)
+		require.NoError(t, err, "verify")
+
+		actor := uuid.New()
+		err = user.SetPrimaryEmail(
+			context.Background(),
+			conn.Conn(),
+			actor,
+			st.EncryptionKeys,
+			secondary,
+		)
+		require.NoError(t, err, "set primary")
+		require.Equal(t, secondary, user.Email, "email")
+		require.Equal(t, digest.SHA256Hex(secondary), user.EmailDigest, "email digest")
+		require.Equal(t, &actor, user.UpdatedBy, "updated by")
+
+		readUser, err := Read(context.Background(), conn.Conn(), st.EncryptionKeys, user.ID)
+		require.NoError(t, err, "read")
+		require.Equal(t, *user, *readUser, "round trip")
+
+		// The prior primary is now a verified secondary.
+		emails, err = user.Emails(context.Background(), conn.Conn(), st.EncryptionKeys)
+		require.NoError(t, err, "emails")
+		require.Len(t, emails, 1, "one secondary")
+		require.Equal(t, primary, emails[0].Email, "prior primary")
+		require.True(t, emails[0].Verified, "verified")
+
+		err = user.SetPrimaryEmail(
+			context.Background(),
+			conn.Conn(),
+			actor,
+			st.EncryptionKeys,
+			uuid.NewString(),
+		)
+		require.ErrorIs(t, err, ErrEmailNotFound, "not a secondary")
+	})
+
+	t.Run("Taken", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		user := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+		other := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+		otherEmail := uuid.NewString() + "@example.com"
+		_, err = other.Update(
+			context.Background(),
+			conn.Conn(),
+			st.StatusSink,
+			uuid.New(),
+			st.EncryptionKeys,
+			UserPatch{Email: &otherEmail},
+		)
+		require.NoError(t, err, "update email")
+
+		// Another user's primary may be added as a secondary, but not
+		// promoted.
+		err = user.AddEmail(context.Background(), conn.Conn(), st.EncryptionKeys, other.Email)
+		require.NoError(t, err, "add email")
+		err = user.VerifyEmail(context.Background(), conn.Conn(), other.Email)
+		require.NoError(t, err, "verify")
+
+		primary := user.Email
+		err = user.SetPrimaryEmail(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			st.EncryptionKeys,
+			other.Email,
+		)
+		require.ErrorIs(t, err, ErrEmailTaken, "taken")
+		require.Equal(t, primary, user.Email, "email unchanged")
+
+		emails, err := user.Emails(context.Background(), conn.Conn(), st.EncryptionKeys)
+		require.NoError(t, err, "emails")
+		require.Len(t, emails, 1, "rolled back")
+		require.Equal(t, other.Email, emails[0].Email, "secondary kept")
+	})
+	t.Run("ReEncrypt", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(context.Background(), conn.Conn(), *versionKey, uuid.New(), status.Active)
+		secondary := uuid.NewString() + "@example.com"
+		err = user.AddEmail(context.Background(), conn.Conn(), st.EncryptionKeys, secondary)
+		require.NoError(t, err, "add email")
+
+		var newKey *key.Versioned
+		for _, version := range st.EncryptionKeys.SortedVersions() {
+			if version != user.KeyVersion {
+				newKey, err = st.EncryptionKeys.Get(version)
+				require.NoError(t, err, "new key")
+				break
+			}
+		}
+		require.NotNil(t, newKey, "new key")
+
+		err = user.ReEncrypt(context.Background(), conn.Conn(), uuid.New(), st.EncryptionKeys, *newKey)
+		require.NoError(t, err, "re-encrypt")
+
+		// Secondary emails are readable once the old key is retired.
+		emails, err := user.Emails(context.Background(), conn.Conn(), key.NewMemoryProvider(*newKey))
+		require.NoError(t, err, "emails")
+		require.Len(t, emails, 1, "one secondary")
+		require.Equal(t, secondary, emails[0].Email, "email")
+		require.Equal(t, newKey.Version, emails[0].KeyVersion, "key version")
+	})
+}
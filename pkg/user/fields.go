@@ -0,0 +1,70 @@
+/*
+Package user provides utilities to create, read, and update
+rows in the `users` database table.
+*/
+package user
+
+import (
+	"grokloc.com/pkg/security/key"
+)
+
+// FieldSpec describes an encrypted PII column of the `users` table.
+type FieldSpec struct {
+	Column       string // Encrypted value.
+	DigestColumn string // Digest of the decrypted value.
+
+	// Label derives the column key with `key.DeriveField`; if empty,
+	// the versioned key is used as is.
+	Label string
+
+	Get       func(u *User) string
+	Set       func(u *User, s string)
+	GetDigest func(u *User) string
+	SetDigest func(u *User, s string)
+}
+
+// Key returns the key the column is encrypted with under `base`.
+func (f FieldSpec) Key(base []byte) []byte {
+	if f.Label == "" {
+		return base
+	}
+	return key.DeriveField(base, f.Label)
+}
+
+var encryptedFields = []FieldSpec{
+	{
+		Column:       "display_name",
+		DigestColumn: "display_name_digest",
+		Label:        displayNameLabel,
+		Get:          func(u *User) string { return u.DisplayName },
+		Set:          func(u *User, s string) { u.DisplayName = s },
+		GetDigest:    func(u *User) string { return u.DisplayNameDigest },
+		SetDigest:    func(u *User, s string) { u.DisplayNameDigest = s },
+	},
+	{
+		Column:       "ed25519_public",
+		DigestColumn: "ed25519_public_digest",
+		Get:          func(u *User) string { return u.Ed25519Public },
+		Set:          func(u *User, s string) { u.Ed25519Public = s },
+		GetDigest:    func(u *User) string { return u.Ed25519PublicDigest },
+		SetDigest:    func(u *User, s string) { u.Ed25519PublicDigest = s },
+	},
+	{
+		Column:       "email",
+		DigestColumn: "email_digest",
+		Label:        emailLabel,
+		Get:          func(u *User) string { return u.Email },
+		Set:          func(u *User, s string) { u.Email = s },
+		GetDigest:    func(u *User) string { return u.EmailDigest },
+		SetDigest:    func(u *User, s string) { u.EmailDigest = s },
+	},
+}
+
+// EncryptedFields returns the encrypted PII columns of the `users`
+// table. `ReEncrypt`, `IntegrityScan`, and `Shred` iterate these, so a
+// new PII column is added here.
+func EncryptedFields() []FieldSpec {
+	fields := make([]FieldSpec, len(encryptedFields))
+	copy(fields, encryptedFields)
+	return fields
+}
@@ -0,0 +1,50 @@
+/*
+Package user provides utilities to create, read, and update
+rows in the `users` database table.
+*/
+package user
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"grokloc.com/pkg/security/key"
+)
+
+func TestEncryptedFields(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		fields := EncryptedFields()
+		var columns []string
+		for _, field := range fields {
+			columns = append(columns, field.Column)
+			require.Equal(t, field.Column+"_digest", field.DigestColumn, "digest column")
+
+			var u User
+			s := uuid.NewString()
+			field.Set(&u, s)
+			require.Equal(t, s, field.Get(&u), "get")
+			field.SetDigest(&u, s)
+			require.Equal(t, s, field.GetDigest(&u), "get digest")
+		}
+		require.Equal(t,
+			[]string{"display_name", "ed25519_public", "email"},
+			columns,
+			"columns")
+
+		// Callers cannot modify the registry.
+		fields[0].Column = ""
+		require.Equal(t, "display_name", EncryptedFields()[0].Column, "copy")
+	})
+
+	t.Run("Key", func(t *testing.T) {
+		t.Parallel()
+		base := key.Random()
+		require.Equal(t, base, FieldSpec{}.Key(base), "base")
+		require.Equal(t,
+			key.DeriveField(base, emailLabel),
+			FieldSpec{Label: emailLabel}.Key(base),
+			"derived")
+	})
+}
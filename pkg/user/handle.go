@@ -0,0 +1,47 @@
+/*
+Package user provides utilities to create, read, and update
+rows in the `users` database table.
+*/
+package user
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+var (
+	handleAdjectives = []string{
+		"amber", "bold", "brave", "calm", "clever", "cosmic", "crisp",
+		"dusty", "eager", "fancy", "gentle", "golden", "happy", "hidden",
+		"jolly", "lively", "lucky", "mellow", "misty", "noble", "polite",
+		"quiet", "rapid", "rosy", "silent", "silver", "sunny", "swift",
+		"tidy", "velvet", "witty", "zesty",
+	}
+	handleNouns = []string{
+		"badger", "beacon", "canyon", "cedar", "comet", "falcon", "fern",
+		"harbor", "heron", "island", "lantern", "lemur", "maple", "meadow",
+		"otter", "panda", "pebble", "pine", "quartz", "raven", "river",
+		"robin", "salmon", "sparrow", "summit", "tiger", "tulip", "valley",
+		"walrus", "willow", "yak", "zebra",
+	}
+)
+
+// RandomHandle returns a readable random handle such as
+// "swift-otter-4821", for guest accounts and tests. It is a valid
+// display name.
+func RandomHandle() string {
+	return fmt.Sprintf("%s-%s-%04d",
+		handleAdjectives[randomIndex(len(handleAdjectives))],
+		handleNouns[randomIndex(len(handleNouns))],
+		randomIndex(10000),
+	)
+}
+
+func randomIndex(n int) int {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic(fmt.Sprintf("random index in 0:%v", n))
+	}
+	return int(i.Int64())
+}
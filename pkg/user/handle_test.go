@@ -0,0 +1,23 @@
+/*
+Package user provides utilities to create, read, and update
+rows in the `users` database table.
+*/
+package user
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRandomHandle(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		re := regexp.MustCompile(`^[a-z]+-[a-z]+-[0-9]{4}$`)
+		for range 100 {
+			handle := RandomHandle()
+			require.Regexp(t, re, handle, "format")
+		}
+	})
+}
@@ -6,13 +6,25 @@ package user
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/matthewhartstonge/argon2"
+	"grokloc.com/pkg/model"
 	"grokloc.com/pkg/model/role"
+	"grokloc.com/pkg/model/status"
+	"grokloc.com/pkg/postgresql"
+	"grokloc.com/pkg/runtime"
 	"grokloc.com/pkg/security/crypt"
 	"grokloc.com/pkg/security/digest"
 	"grokloc.com/pkg/security/ed25519"
+	"grokloc.com/pkg/security/jwt"
 	"grokloc.com/pkg/security/key"
 	"grokloc.com/pkg/security/password"
 )
@@ -21,6 +33,38 @@ const (
 	SchemaVersion = 0
 )
 
+var (
+	ErrTokenRevoked = errors.New("token signature does not match user")
+	ErrIDInUse      = errors.New("user id already in use")
+	ErrEmailTaken   = errors.New("user email already in use in org")
+	ErrEd25519Taken = errors.New("user ed25519 public key already in use in org")
+	ErrNotFound     = errors.New("user not found")
+	ErrDisplayName  = errors.New("user display name empty")
+	ErrEmail        = errors.New("user email malformed")
+	ErrStatus       = errors.New("user status not valid")
+	ErrRole         = errors.New("user role not valid")
+	ErrUnauthorized = errors.New("actor not authorized")
+
+	ErrOwnerMustBeAdmin = errors.New("org owner must keep the owner role")
+	ErrOwnerDelete      = errors.New("org owner cannot be deleted")
+
+	ErrInsertOrderExhausted = errors.New("user insert order sequence exhausted")
+
+	ErrEd25519Private       = errors.New("user ed25519 private key not valid")
+	ErrNoEd25519Private     = errors.New("user has no ed25519 private key")
+	ErrEd25519PrivateLocked = errors.New("user ed25519 private key not decrypted")
+	ErrKeyVersion           = errors.New("key version does not match user")
+	ErrOrgNotFound          = errors.New("org not found")
+)
+
+// Field labels derive the keys for PII columns encrypted under a
+// per-column key rather than the versioned key itself.
+const (
+	displayNameLabel    = "display_name"
+	emailLabel          = "email"
+	ed25519PrivateLabel = "ed25519_private"
+)
+
 type User struct {
 	// PII fields are encrypted for storage and decrypted at read.
 	// Corresponding digest fields are digests of decrypted PII.
@@ -37,19 +81,29 @@ type User struct {
 	Password            string    `db:"password"` // Argon2 hash.
 
 	// Metadata.
-	Ctime         int64     `db:"ctime"` // Unixtime.
-	Mtime         int64     `db:"mtime"` // Unixtime.
-	InsertOrder   int64     `db:"insert_order"`
-	Role          int       `db:"role"`
-	SchemaVersion int       `db:"schema_version"`
-	Signature     uuid.UUID `db:"signature"` // Generated.
-	Status        int       `db:"status"`
+	Ctime         int64      `db:"ctime"` // Unixtime.
+	Mtime         int64      `db:"mtime"` // Unixtime.
+	InsertOrder   int64      `db:"insert_order"`
+	Role          int        `db:"role"`
+	SchemaVersion int        `db:"schema_version"`
+	Signature     uuid.UUID  `db:"signature"` // Generated.
+	Status        int        `db:"status"`
+	UpdatedBy     *uuid.UUID `db:"updated_by"` // Actor of the last Update*.
+
+	// The optional Ed25519 private key is never decrypted by `Read`;
+	// see `Ed25519Private`. Both are nil if no key is set.
+	Ed25519PrivateEncrypted *string `db:"ed25519_private"`
+	Ed25519PrivateDigest    *string `db:"ed25519_private_digest"`
+
+	// ed25519Private is the decrypted private key, once requested.
+	ed25519Private string
 }
 
 // Insert adds a new User to the database and returns it.
 func Insert(
 	ctx context.Context,
-	conn *pgx.Conn,
+	conn postgresql.DB,
+	l runtime.RateLimiter,
 	versionedKey key.Versioned,
 	displayName string,
 	ed25519Public string,
@@ -60,29 +114,93 @@ func Insert(
 	schemaVersion int,
 	status int,
 ) (*User, error) {
-	_, err := ed25519.ImportPublicPEM(ed25519Public)
-	if err != nil {
-		return nil, err
-	}
+	return InsertWithID(
+		ctx,
+		conn,
+		l,
+		uuid.New(),
+		versionedKey,
+		displayName,
+		ed25519Public,
+		email,
+		org,
+		password,
+		role,
+		schemaVersion,
+		status,
+	)
+}
 
-	encryptedDisplayName, err := crypt.Encrypt(displayName, versionedKey.Key)
+// InsertWithID is `Insert` with a caller-supplied `id`, for callers
+// that must know the id before the row exists. Returns
+// `ErrIDInUse` if a user with `id` already exists.
+//
+// If the email or Ed25519 public key is already used in `org`, the
+// db error is wrapped with `ErrEmailTaken` or `ErrEd25519Taken`.
+// `runtime.ErrRateLimited` is returned if `l` denies `org`.
+func InsertWithID(
+	ctx context.Context,
+	conn postgresql.DB,
+	l runtime.RateLimiter,
+	id uuid.UUID,
+	versionedKey key.Versioned,
+	displayName string,
+	ed25519Public string,
+	email string,
+	org uuid.UUID,
+	password string,
+	role int,
+	schemaVersion int,
+	status int,
+) (*User, error) {
+	err := runtime.CheckRateLimit(ctx, l, org)
 	if err != nil {
 		return nil, err
 	}
 
-	encryptedEd25519Public, err := crypt.Encrypt(ed25519Public, versionedKey.Key)
+	user, values, err := PrepareInsert(
+		ctx,
+		id,
+		versionedKey,
+		displayName,
+		ed25519Public,
+		email,
+		org,
+		password,
+		role,
+		schemaVersion,
+		status,
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	encryptedEmail, err := crypt.Encrypt(email, versionedKey.Key)
+	const insertQuery = `
+	insert into users
+	(` + InsertColumns + `)
+	values
+	($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+	on conflict (id) do nothing
+	returning ctime, mtime, insert_order, signature
+	`
+
+	err = conn.QueryRow(ctx, insertQuery, values...).Scan(
+		&user.Ctime,
+		&user.Mtime,
+		&user.InsertOrder,
+		&user.Signature,
+	)
 	if err != nil {
-		return nil, err
+		return nil, MapInsertError(err)
 	}
 
-	const insertQuery = `
-	insert into users
-	(display_name,
+	return user, nil
+}
+
+// InsertColumns lists the users columns set on insert, in the order of
+// the values returned by `PrepareInsert`.
+const InsertColumns = `id,
+	display_name,
 	display_name_digest,
 	ed25519_public,
 	ed25519_public_digest,
@@ -93,42 +211,125 @@ func Insert(
 	password,
 	role,
 	schema_version,
-	status)
-	values
-	($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
-	returning id
-	`
+	status`
+
+// PrepareInsert checks and encrypts a new user as `InsertWithID` does,
+// without inserting it, for callers that insert the row in their own
+// statement, such as `org.Insert`. It returns the user, lacking the
+// columns generated on insert, and the values of `InsertColumns`.
+// Errors from that statement should be passed to `MapInsertError`.
+// Unlike `InsertWithID`, it does not check a rate limit, since the
+// user's org is being created by the same statement.
+func PrepareInsert(
+	ctx context.Context,
+	id uuid.UUID,
+	versionedKey key.Versioned,
+	displayName string,
+	ed25519Public string,
+	email string,
+	org uuid.UUID,
+	password string,
+	role int,
+	schemaVersion int,
+	status int,
+) (*User, []any, error) {
+	if id == uuid.Nil {
+		return nil, nil, runtime.ErrInvalidID
+	}
+
+	email = NormalizeEmail(email)
+
+	_, err := ed25519.ImportPublicPEM(ed25519Public)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encryptedDisplayName, err := crypt.Encrypt(
+		displayName,
+		key.DeriveField(versionedKey.Key, displayNameLabel),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encryptedEd25519Public, err := crypt.Encrypt(ed25519Public, versionedKey.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encryptedEmail, err := crypt.Encrypt(
+		email,
+		key.DeriveField(versionedKey.Key, emailLabel),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	var id uuid.UUID
+	// The caller has the plaintext, so the user is assembled here
+	// rather than read back and decrypted.
+	user := User{
+		ID:                  id,
+		DisplayName:         displayName,
+		DisplayNameDigest:   digest.SHA256Hex(displayName),
+		Ed25519Public:       ed25519Public,
+		Ed25519PublicDigest: digest.SHA256Hex(ed25519Public),
+		Email:               email,
+		EmailDigest:         digest.SHA256Hex(email),
+		KeyVersion:          versionedKey.Version,
+		Org:                 org,
+		Password:            password,
+		Role:                role,
+		SchemaVersion:       schemaVersion,
+		Status:              status,
+	}
 
-	err = conn.QueryRow(ctx, insertQuery,
+	values := []any{
+		user.ID,
 		encryptedDisplayName,
-		digest.SHA256Hex(displayName),
+		user.DisplayNameDigest,
 		encryptedEd25519Public,
-		digest.SHA256Hex(ed25519Public),
+		user.Ed25519PublicDigest,
 		encryptedEmail,
-		digest.SHA256Hex(email),
-		versionedKey.Version,
-		org,
-		password,
-		role,
-		schemaVersion,
-		status,
-	).Scan(&id)
-	if err != nil {
-		return nil, err
+		user.EmailDigest,
+		user.KeyVersion,
+		user.Org,
+		user.Password,
+		user.Role,
+		user.SchemaVersion,
+		user.Status,
 	}
+	return &user, values, nil
+}
 
-	m := make(key.VersionedMap)
-	m[versionedKey.Version] = versionedKey.Key
-	return Read(ctx, conn, m, id)
+// MapInsertError maps an error from inserting a users row prepared by
+// `PrepareInsert` as `InsertWithID` does. The insert must use
+// `on conflict (id) do nothing`, so that no row returned means
+// `ErrIDInUse`.
+func MapInsertError(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrIDInUse
+	}
+	if name, ok := postgresql.ConstraintName(err); ok {
+		switch name {
+		case "users_email_digest_org":
+			return fmt.Errorf("%w: %w", ErrEmailTaken, err)
+		case "users_ed25519_public_digest_org":
+			return fmt.Errorf("%w: %w", ErrEd25519Taken, err)
+		}
+	}
+	if postgresql.SequenceExhausted(err) {
+		return fmt.Errorf("%w: %w", ErrInsertOrderExhausted, err)
+	}
+	return err
 }
 
 // Read selects the users row matching `id` and decrypts PII fields.
+// A row with an unknown status or role returns a
+// `*model.CorruptEnumError`.
 func Read(
 	ctx context.Context,
-	conn *pgx.Conn,
-	m key.VersionedMap,
+	conn postgresql.DB,
+	m key.KeyProvider,
 	id uuid.UUID,
 ) (*User, error) {
 	const query = `select * from users where id = @id`
@@ -137,236 +338,1909 @@ func Read(
 	if err != nil {
 		return nil, err
 	}
+	return CollectOne(rows, m)
+}
+
+// CollectOne collects the single row of a `select * from users` query
+// as `Read` does, checking its enums and decrypting PII fields, so
+// other packages can read users in their own queries, for example in
+// a `runtime.Pipeline`. No row returns `pgx.ErrNoRows`.
+func CollectOne(rows pgx.Rows, m key.KeyProvider) (*User, error) {
 	user, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[User])
 	if err != nil {
 		return nil, err
 	}
 
-	versionedKey, err := m.Get(user.KeyVersion)
+	err = user.checkEnums()
 	if err != nil {
 		return nil, err
 	}
 
-	// Decrypt PII columns.
+	err = user.decrypt(m)
+	if err != nil {
+		return nil, err
+	}
 
-	user.DisplayName, err = crypt.Decrypt(
-		user.DisplayName,
-		user.DisplayNameDigest,
-		versionedKey.Key,
-	)
+	return &user, nil
+}
+
+// ReadReplica is `Read` on a connection from `st.HealthyReplica`; it
+// never uses the master, and returns `runtime.ErrNoReplica` if no
+// replica is healthy. A miss, which may be replication lag, returns
+// `ErrNotFound`.
+func ReadReplica(
+	ctx context.Context,
+	st *runtime.State,
+	id uuid.UUID,
+) (*User, error) {
+	replica, err := st.HealthyReplica()
 	if err != nil {
 		return nil, err
 	}
+	return readPool(ctx, replica, st.EncryptionKeys, id)
+}
 
-	user.Ed25519Public, err = crypt.Decrypt(
-		user.Ed25519Public,
-		user.Ed25519PublicDigest,
-		versionedKey.Key,
-	)
+// ReadQuorum reads the user from two replicas and, if they disagree
+// on `Mtime` or only one has the row, from the master. Use it where a
+// single lagging replica must not be trusted, such as reading status
+// for an authorization decision. With fewer than two replicas, it
+// reads from the master. A miss everywhere returns `ErrNotFound`.
+func ReadQuorum(
+	ctx context.Context,
+	st *runtime.State,
+	m key.KeyProvider,
+	id uuid.UUID,
+) (*User, error) {
+	l := len(st.Replicas)
+	if l >= 2 {
+		i := randomIndex(l)
+		j := (i + 1 + randomIndex(l-1)) % l // Not i.
+		a, errA := readPool(ctx, st.Replicas[i], m, id)
+		b, errB := readPool(ctx, st.Replicas[j], m, id)
+		switch {
+		case errA == nil && errB == nil && a.Mtime == b.Mtime:
+			return a, nil
+		case errors.Is(errA, ErrNotFound) && errors.Is(errB, ErrNotFound):
+			return nil, ErrNotFound
+		}
+	}
+
+	return readPool(ctx, st.Master, m, id)
+}
+
+func readPool(
+	ctx context.Context,
+	pool *pgxpool.Pool,
+	m key.KeyProvider,
+	id uuid.UUID,
+) (*User, error) {
+	conn, err := pool.Acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer conn.Release()
 
-	user.Email, err = crypt.Decrypt(
-		user.Email,
-		user.EmailDigest,
-		versionedKey.Key,
-	)
+	user, err := Read(ctx, conn.Conn(), m, id)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
-	return &user, nil
+	return user, nil
 }
 
-// NewEd25519 replaces the Ed25519 public key and encrypts it in the db.
-func (u *User) NewEd25519(
+// ReadAsOf returns user `id` as it was at `at`, from the current row
+// or the `users_history` row it replaced, and decrypts PII fields.
+// Times resolve to the second, as `Mtime` does, so of several updates
+// in one second the last is returned. `ErrNotFound` is returned if the
+// user did not exist at `at`.
+func ReadAsOf(
 	ctx context.Context,
-	conn *pgx.Conn,
-	m key.VersionedMap,
-	ed25519Public string,
-) error {
-	_, err := ed25519.ImportPublicPEM(ed25519Public)
+	conn postgresql.DB,
+	m key.KeyProvider,
+	id uuid.UUID,
+	at time.Time,
+) (*User, error) {
+	// Columns are listed, rather than `*`, so the union does not depend
+	// on `users_history` having the columns of `users` in the same
+	// order. The current row orders after every history row.
+	const columns = `id, display_name, display_name_digest,
+		ed25519_public, ed25519_public_digest,
+		ed25519_private, ed25519_private_digest,
+		email, email_digest, key_version, org, password,
+		ctime, mtime, insert_order, role, schema_version,
+		signature, status, updated_by`
+	const query = `select * from (
+			select ` + columns + `, 9223372036854775807 as history_order
+			from users where id = @id
+			union all
+			select ` + columns + `, history_order
+			from users_history where id = @id
+		) v
+		where mtime <= @at
+		order by history_order desc
+		limit 1`
+	args := pgx.NamedArgs{"id": id, "at": at.Unix()}
+	rows, err := conn.Query(ctx, query, args)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	type historyRow struct {
+		User
+		HistoryOrder int64 `db:"history_order"`
+	}
+	row, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[historyRow])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
 	}
 
-	versionedKey, err := m.Get(u.KeyVersion)
+	user := row.User
+	err = user.checkEnums()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	encryptedEd25519Public, err := crypt.Encrypt(ed25519Public, versionedKey.Key)
+	err = user.decrypt(m)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	const query = `update users 
-		set ed25519_public = $1, 
-		ed25519_public_digest = $2
-		where id = $3
-		returning mtime, signature, ed25519_public_digest`
+	return &user, nil
+}
 
-	err = conn.QueryRow(
+// NormalizeEmail trims surrounding space from `email` and lowercases
+// it. Emails are normalized before they are stored, so that their
+// digests, and so `ReadByEmail`, do not depend on case.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// ReadByEmail returns the user in `org` with `email`, found by the
+// digest of its normalized form, and decrypts PII fields. A miss
+// returns `ErrNotFound`. Users stored before emails were normalized
+// are found only once `NormalizeEmails` has rewritten them.
+//
+// Only exact matches are possible. Emails are encrypted with a random
+// nonce, so equal emails have unrelated ciphertexts, and the digest of
+// a whole email says nothing about its prefixes or substrings;
+// searching by part of an email would mean decrypting every user.
+func ReadByEmail(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	org uuid.UUID,
+	email string,
+) (*User, error) {
+	user, err := FindByEmailDigest(
 		ctx,
-		query,
-		encryptedEd25519Public,
-		digest.SHA256Hex(ed25519Public),
-		u.ID,
-	).
-		Scan(
-			&u.Mtime,
-			&u.Signature,
-			&u.Ed25519PublicDigest,
-		)
+		conn,
+		m,
+		org,
+		digest.SHA256Hex(NormalizeEmail(email)),
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return user, err
+}
+
+// FindByEmailDigest returns the user in `org` whose email has digest
+// `emailDigest`, and decrypts PII fields. A miss returns
+// `pgx.ErrNoRows`. Callers compute the digest with `digest.SHA256Hex`
+// of the `NormalizeEmail` form; the lookup uses the unique
+// `users_email_digest_org` index.
+func FindByEmailDigest(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	org uuid.UUID,
+	emailDigest string,
+) (*User, error) {
+	const query = `select * from users
+		where org = @org and email_digest = @email_digest`
+	args := pgx.NamedArgs{
+		"org":          org,
+		"email_digest": emailDigest,
+	}
+	rows, err := conn.Query(ctx, query, args)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return CollectOne(rows, m)
+}
 
-	u.Ed25519Public = ed25519Public
-	return nil
+// NormalizeEmails backfills the users in `org` stored before emails
+// were normalized: each primary email that differs from its
+// `NormalizeEmail` form is rewritten with `UpdateEmail`, so its digest,
+// and so `ReadByEmail`, no longer depend on case. It returns the number
+// of users changed and the ids of those skipped because the normalized
+// email is malformed or is already the primary email of another user in
+// `org`; those must be resolved by hand. Each user is updated in its own
+// transaction, so the backfill can be rerun after an error.
+func NormalizeEmails(
+	ctx context.Context,
+	conn postgresql.DB,
+	actor uuid.UUID,
+	m key.KeyProvider,
+	org uuid.UUID,
+) (int, []uuid.UUID, error) {
+	var changed int
+	var skipped []uuid.UUID
+	var after int64
+	for {
+		page, err := ListByOrg(ctx, conn, m, org, after, 100)
+		if err != nil {
+			return changed, skipped, err
+		}
+
+		for i := range page.Items {
+			u := &page.Items[i]
+			if u.Email == NormalizeEmail(u.Email) {
+				continue
+			}
+
+			err = normalizeEmail(ctx, conn, actor, m, u)
+			if errors.Is(err, ErrEmailTaken) || errors.Is(err, ErrEmail) {
+				skipped = append(skipped, u.ID)
+				continue
+			}
+			if err != nil {
+				return changed, skipped, err
+			}
+			changed++
+		}
+
+		if !page.HasMore {
+			break
+		}
+		after = page.Next
+	}
+
+	return changed, skipped, nil
 }
 
-// UpdateDisplayName replaces the display name and encrypts it in the db.
-func (u *User) UpdateDisplayName(
+// normalizeEmail rewrites the primary email of `u` in its normalized
+// form. The update runs in a transaction, a savepoint if `conn` is one,
+// so a unique violation does not abort the caller's transaction.
+func normalizeEmail(
 	ctx context.Context,
-	conn *pgx.Conn,
-	m key.VersionedMap,
-	displayName string,
+	conn postgresql.DB,
+	actor uuid.UUID,
+	m key.KeyProvider,
+	u *User,
 ) error {
-	versionedKey, err := m.Get(u.KeyVersion)
+	tx, err := conn.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx) // nolint:errcheck
 
-	encryptedDisplayName, err := crypt.Encrypt(displayName, versionedKey.Key)
+	err = u.UpdateEmail(ctx, tx, actor, m, u.Email)
 	if err != nil {
 		return err
 	}
+	return tx.Commit(ctx)
+}
 
-	const query = `update users 
-		set display_name = $1,
-		display_name_digest = $2
-		where id = $3
-		returning mtime, signature, display_name_digest`
+// ReadMany selects the users rows matching `ids` and decrypts PII fields.
+// Ids with no row are absent from the returned map.
+func ReadMany(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	ids []uuid.UUID,
+) (map[uuid.UUID]*User, error) {
+	const query = `select * from users where id = any(@ids)`
+	args := pgx.NamedArgs{"ids": ids}
+	rows, err := conn.Query(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	users, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByName[User])
+	if err != nil {
+		return nil, err
+	}
 
-	err = conn.QueryRow(
-		ctx,
-		query,
-		encryptedDisplayName,
-		digest.SHA256Hex(displayName),
-		u.ID,
-	).
-		Scan(
-			&u.Mtime,
-			&u.Signature,
-			&u.DisplayNameDigest,
-		)
+	byID := make(map[uuid.UUID]*User, len(users))
+	for _, user := range users {
+		err = user.checkEnums()
+		if err != nil {
+			return nil, err
+		}
+		err = user.decrypt(m)
+		if err != nil {
+			return nil, err
+		}
+		byID[user.ID] = user
+	}
+
+	return byID, nil
+}
+
+// ListByOrg selects up to `limit` users in `org` with an insert order
+// greater than `afterInsertOrder`, and decrypts PII fields. Pass the
+// returned `Next` as `afterInsertOrder` to get the following page; 0
+// starts from the first user. A negative cursor returns
+// `model.ErrCursor`.
+//
+// `insert_order` is an identity column: unique and increasing, starting
+// at 1, but not gapless, since a rolled back insert still consumes its
+// value. Pages compare with `>` and never compute the next value, so
+// gaps are skipped and the cursor cannot overflow. Values are assigned
+// at insert, not commit, so a user whose transaction commits after a
+// page past its insert order was read is not seen by that listing.
+func ListByOrg(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	org uuid.UUID,
+	afterInsertOrder int64,
+	limit int,
+) (*model.Page[User], error) {
+	if limit < 1 {
+		return nil, model.ErrLimit
+	}
+	if afterInsertOrder < 0 {
+		return nil, model.ErrCursor
+	}
+
+	// Select one extra row to learn if there is a following page.
+	const query = `select * from users
+		where org = @org and insert_order > @after
+		order by insert_order asc
+		limit @limit`
+	args := pgx.NamedArgs{
+		"org":   org,
+		"after": afterInsertOrder,
+		"limit": limit + 1,
+	}
+	rows, err := conn.Query(ctx, query, args)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	users, err := pgx.CollectRows(rows, pgx.RowToStructByName[User])
+	if err != nil {
+		return nil, err
 	}
 
-	u.DisplayName = displayName
-	return nil
+	page := model.NewPage(users, afterInsertOrder, limit, func(u User) int64 {
+		return u.InsertOrder
+	})
+	for i := range page.Items {
+		err = page.Items[i].checkEnums()
+		if err != nil {
+			return nil, err
+		}
+		err = page.Items[i].decrypt(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
 }
 
-// UpdatePassword replaces the password. Password is Argon2-formatted.
-func (u *User) UpdatePassword(
+// MaxInsertOrder returns the greatest insert order of the users in
+// `org`, or 0 if it has none, for use as a high-water mark: a later
+// `ListByOrg` after it returns only users inserted since. See
+// `ListByOrg` for why a user inserted concurrently, before the mark but
+// committed after it, can be missed.
+func MaxInsertOrder(
 	ctx context.Context,
-	conn *pgx.Conn,
-	password string,
-) error {
-	const query = `update users 
-		set password = $1
-		where id = $2
-		returning mtime, signature, password`
+	conn postgresql.DB,
+	org uuid.UUID,
+) (int64, error) {
+	const query = `select coalesce(max(insert_order), 0)
+		from users where org = $1`
 
-	return conn.QueryRow(
-		ctx,
-		query,
+	var n int64
+	err := conn.QueryRow(ctx, query, org).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// ListAdmins selects the active admins of `org` and decrypts PII fields.
+func ListAdmins(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	org uuid.UUID,
+) ([]User, error) {
+	const query = `select * from users
+		where org = @org and role = @role and status = @status
+		order by insert_order asc`
+	args := pgx.NamedArgs{
+		"org":    org,
+		"role":   role.Admin,
+		"status": status.Active,
+	}
+	rows, err := conn.Query(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	users, err := pgx.CollectRows(rows, pgx.RowToStructByName[User])
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range users {
+		err = users[i].checkEnums()
+		if err != nil {
+			return nil, err
+		}
+		err = users[i].decrypt(m)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return users, nil
+}
+
+// checkEnums returns a `*model.CorruptEnumError` if the status or role
+// of a row read from the db is not a known constant.
+func (u *User) checkEnums() error {
+	if !status.Valid(u.Status) {
+		return &model.CorruptEnumError{Field: "status", Value: u.Status}
+	}
+	if !role.Valid(u.Role) {
+		return &model.CorruptEnumError{Field: "role", Value: u.Role}
+	}
+	return nil
+}
+
+// decrypt replaces the encrypted PII fields of a row read from the
+// db with their decrypted values.
+func (u *User) decrypt(m key.KeyProvider) error {
+	versionedKey, err := m.Get(u.KeyVersion)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range encryptedFields {
+		decrypted, err := crypt.Decrypt(
+			field.Get(u),
+			field.GetDigest(u),
+			field.Key(versionedKey.Key),
+		)
+		if err != nil {
+			return err
+		}
+		field.Set(u, decrypted)
+	}
+
+	return nil
+}
+
+// mutationResult is the result of an update that changed the row.
+func (u *User) mutationResult() model.MutationResult {
+	return model.MutationResult{
+		Modified:  true,
+		Mtime:     u.Mtime,
+		Signature: u.Signature,
+	}
+}
+
+// NewEd25519 replaces the Ed25519 public key and encrypts it in the db.
+// The prior key is archived so signatures made with it can still be
+// checked with `VerifyWithHistory`.
+func (u *User) NewEd25519(
+	ctx context.Context,
+	conn postgresql.DB,
+	actor uuid.UUID,
+	m key.KeyProvider,
+	ed25519Public string,
+) error {
+	_, err := ed25519.ImportPublicPEM(ed25519Public)
+	if err != nil {
+		return err
+	}
+
+	versionedKey, err := m.Get(u.KeyVersion)
+	if err != nil {
+		return err
+	}
+
+	encryptedEd25519Public, err := crypt.Encrypt(ed25519Public, versionedKey.Key)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) // nolint:errcheck
+
+	// The prior key is archived still encrypted under its key version.
+	const archiveQuery = `insert into ed25519_public_history
+		(user_id, ed25519_public, ed25519_public_digest, key_version)
+		select id, ed25519_public, ed25519_public_digest, key_version
+		from users where id = $1`
+
+	result, err := tx.Exec(ctx, archiveQuery, u.ID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() != 1 {
+		return postgresql.ErrRowsAffected
+	}
+
+	const query = `update users 
+		set ed25519_public = $1, 
+		ed25519_public_digest = $2,
+		updated_by = $3
+		where id = $4
+		returning mtime, signature, ed25519_public_digest, updated_by`
+
+	err = tx.QueryRow(
+		ctx,
+		query,
+		encryptedEd25519Public,
+		digest.SHA256Hex(ed25519Public),
+		actor,
+		u.ID,
+	).
+		Scan(
+			&u.Mtime,
+			&u.Signature,
+			&u.Ed25519PublicDigest,
+			&u.UpdatedBy,
+		)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	u.Ed25519Public = ed25519Public
+	return nil
+}
+
+// UpdateEmail replaces the email and encrypts it in the db under the
+// user's key version in `m`. The email is normalized first. An email
+// already used in the org returns `ErrEmailTaken` wrapping the unique
+// constraint violation, as `Insert` does.
+func (u *User) UpdateEmail(
+	ctx context.Context,
+	conn postgresql.DB,
+	actor uuid.UUID,
+	m key.KeyProvider,
+	email string,
+) error {
+	email = NormalizeEmail(email)
+	err := validEmail(email)
+	if err != nil {
+		return err
+	}
+
+	versionedKey, err := m.Get(u.KeyVersion)
+	if err != nil {
+		return err
+	}
+
+	encryptedEmail, err := crypt.Encrypt(
+		email,
+		key.DeriveField(versionedKey.Key, emailLabel),
+	)
+	if err != nil {
+		return err
+	}
+
+	const query = `update users 
+		set email = $1, 
+		email_digest = $2,
+		updated_by = $3
+		where id = $4
+		returning mtime, signature, email_digest, updated_by`
+
+	err = conn.QueryRow(
+		ctx,
+		query,
+		encryptedEmail,
+		digest.SHA256Hex(email),
+		actor,
+		u.ID,
+	).
+		Scan(
+			&u.Mtime,
+			&u.Signature,
+			&u.EmailDigest,
+			&u.UpdatedBy,
+		)
+	if err != nil {
+		if name, ok := postgresql.ConstraintName(err); ok && name == "users_email_digest_org" {
+			return fmt.Errorf("%w: %w", ErrEmailTaken, err)
+		}
+		return err
+	}
+
+	u.Email = email
+	return nil
+}
+
+// SetEd25519Private stores `privatePEM` as the user's Ed25519 private
+// key, encrypted under `versionedKey`, which must be the user's key
+// version. It is for keypairs generated server-side, such as for
+// service accounts.
+func (u *User) SetEd25519Private(
+	ctx context.Context,
+	conn postgresql.DB,
+	actor uuid.UUID,
+	versionedKey key.Versioned,
+	privatePEM string,
+) error {
+	if !ed25519.IsValidPrivatePEM(privatePEM) {
+		return ErrEd25519Private
+	}
+	if versionedKey.Version != u.KeyVersion {
+		return ErrKeyVersion
+	}
+
+	encrypted, err := crypt.Encrypt(
+		privatePEM,
+		key.DeriveField(versionedKey.Key, ed25519PrivateLabel),
+	)
+	if err != nil {
+		return err
+	}
+
+	const query = `update users
+		set ed25519_private = $1,
+		ed25519_private_digest = $2,
+		updated_by = $3
+		where id = $4
+		returning mtime, signature, ed25519_private, ed25519_private_digest, updated_by`
+
+	err = conn.QueryRow(
+		ctx,
+		query,
+		encrypted,
+		digest.SHA256Hex(privatePEM),
+		actor,
+		u.ID,
+	).
+		Scan(
+			&u.Mtime,
+			&u.Signature,
+			&u.Ed25519PrivateEncrypted,
+			&u.Ed25519PrivateDigest,
+			&u.UpdatedBy,
+		)
+	if err != nil {
+		return err
+	}
+
+	u.ed25519Private = privatePEM
+	return nil
+}
+
+// Ed25519Private decrypts and returns the user's Ed25519 private key,
+// or `ErrNoEd25519Private` if none is set. `Read` leaves the key
+// encrypted, so it is only decrypted where it is needed.
+func (u *User) Ed25519Private(m key.KeyProvider) (string, error) {
+	if u.Ed25519PrivateEncrypted == nil || u.Ed25519PrivateDigest == nil {
+		return "", ErrNoEd25519Private
+	}
+
+	versionedKey, err := m.Get(u.KeyVersion)
+	if err != nil {
+		return "", err
+	}
+
+	decrypted, err := crypt.Decrypt(
+		*u.Ed25519PrivateEncrypted,
+		*u.Ed25519PrivateDigest,
+		key.DeriveField(versionedKey.Key, ed25519PrivateLabel),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	u.ed25519Private = decrypted
+	return decrypted, nil
+}
+
+// VerifyWithHistory reports whether sig is a valid signature of msg by
+// the current Ed25519 public key of user `id`, or by any key it replaced.
+// Keys are tried from newest to oldest. An archived key that cannot be
+// used, because its key version is retired or it does not decrypt or
+// parse, is skipped; false is returned only once every key is tried.
+func VerifyWithHistory(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	id uuid.UUID,
+	msg []byte,
+	sig []byte,
+) (bool, error) {
+	user, err := Read(ctx, conn, m, id)
+	if err != nil {
+		return false, err
+	}
+	ok, err := ed25519.Verify(user.Ed25519Public, msg, sig)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return true, nil
+	}
+
+	history, err := readEd25519History(ctx, conn, id, false)
+	if err != nil {
+		return false, err
+	}
+
+	for _, h := range history {
+		ed25519Public, err := h.decrypt(m)
+		if err != nil {
+			continue
+		}
+		ok, err := ed25519.Verify(ed25519Public, msg, sig)
+		if err == nil && ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// archivedEd25519 is a row of `ed25519_public_history`.
+type archivedEd25519 struct {
+	InsertOrder         int64     `db:"insert_order"`
+	Ed25519Public       string    `db:"ed25519_public"`
+	Ed25519PublicDigest string    `db:"ed25519_public_digest"`
+	KeyVersion          uuid.UUID `db:"key_version"`
+}
+
+func (h archivedEd25519) decrypt(m key.KeyProvider) (string, error) {
+	versionedKey, err := m.Get(h.KeyVersion)
+	if err != nil {
+		return "", err
+	}
+	return crypt.Decrypt(h.Ed25519Public, h.Ed25519PublicDigest, versionedKey.Key)
+}
+
+// readEd25519History returns the archived Ed25519 public keys of user
+// `id`, newest first, locking them if `forUpdate`.
+func readEd25519History(
+	ctx context.Context,
+	conn postgresql.DB,
+	id uuid.UUID,
+	forUpdate bool,
+) ([]archivedEd25519, error) {
+	query := `select insert_order, ed25519_public, ed25519_public_digest, key_version
+		from ed25519_public_history
+		where user_id = $1
+		order by insert_order desc`
+	if forUpdate {
+		query += " for update"
+	}
+
+	rows, err := conn.Query(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByName[archivedEd25519])
+}
+
+// reEncryptEd25519History re-encrypts the archived Ed25519 public keys
+// of user `id` under `newKey`. An entry that cannot be decrypted with
+// `m` is left as it is, since it is already unreadable;
+// `VerifyWithHistory` skips it.
+func reEncryptEd25519History(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	id uuid.UUID,
+	newKey key.Versioned,
+) error {
+	history, err := readEd25519History(ctx, conn, id, true)
+	if err != nil {
+		return err
+	}
+
+	const query = `update ed25519_public_history
+		set ed25519_public = $1,
+		key_version = $2
+		where insert_order = $3`
+
+	for _, h := range history {
+		if h.KeyVersion == newKey.Version {
+			continue
+		}
+		ed25519Public, err := h.decrypt(m)
+		if err != nil {
+			continue
+		}
+		encrypted, err := crypt.Encrypt(ed25519Public, newKey.Key)
+		if err != nil {
+			return err
+		}
+		_, err = conn.Exec(ctx, query, encrypted, newKey.Version, h.InsertOrder)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UserPatch holds the fields for `Update`; nil fields are unchanged.
+type UserPatch struct {
+	DisplayName *string
+	Email       *string
+	Status      *int // `status.Deleted` is set only by `Shred`.
+}
+
+func (p UserPatch) validate() error {
+	if p.DisplayName != nil && *p.DisplayName == "" {
+		return ErrDisplayName
+	}
+	if p.Email != nil {
+		err := validEmail(*p.Email)
+		if err != nil {
+			return err
+		}
+	}
+	if p.Status != nil && (!status.Valid(*p.Status) || *p.Status == status.Deleted) {
+		return ErrStatus
+	}
+	return nil
+}
+
+// Update sets the non-nil fields of `patch` in one statement. PII is
+// encrypted under the user's key version in `m`. An empty patch does
+// nothing. An email already used in the org returns `ErrEmailTaken`,
+// as `Insert` does. A status transition is reported to `sink`, as
+// `UpdateStatus` does; a nil `sink` returns `runtime.ErrNoStatusSink`.
+func (u *User) Update(
+	ctx context.Context,
+	conn postgresql.DB,
+	sink runtime.StatusSink,
+	actor uuid.UUID,
+	m key.KeyProvider,
+	patch UserPatch,
+) (model.MutationResult, error) {
+	err := runtime.CheckStatusSink(sink)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+	if patch.Email != nil {
+		email := NormalizeEmail(*patch.Email)
+		patch.Email = &email
+	}
+	err = patch.validate()
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+
+	pii := map[string]*string{
+		"display_name": patch.DisplayName,
+		"email":        patch.Email,
+	}
+
+	var sets []string
+	var args []any
+	var fields []FieldSpec
+	for _, field := range encryptedFields {
+		v := pii[field.Column]
+		if v == nil {
+			continue
+		}
+		versionedKey, err := m.Get(u.KeyVersion)
+		if err != nil {
+			return model.MutationResult{}, err
+		}
+		encrypted, err := crypt.Encrypt(*v, field.Key(versionedKey.Key))
+		if err != nil {
+			return model.MutationResult{}, err
+		}
+		args = append(args, encrypted, digest.SHA256Hex(*v))
+		sets = append(sets,
+			fmt.Sprintf("%s = $%d", field.Column, len(args)-1),
+			fmt.Sprintf("%s = $%d", field.DigestColumn, len(args)),
+		)
+		fields = append(fields, field)
+	}
+	if patch.Status != nil {
+		args = append(args, *patch.Status)
+		sets = append(sets, fmt.Sprintf("status = $%d", len(args)))
+	}
+	if len(sets) == 0 {
+		return model.MutationResult{Mtime: u.Mtime, Signature: u.Signature}, nil
+	}
+	args = append(args, actor, u.ID)
+
+	// The prior status is read under a row lock, as `UpdateStatus`
+	// does, to report a status transition.
+	query := fmt.Sprintf(`with prior as (
+			select status from users where id = $%[3]d for update
+		)
+		update users
+		set %[1]s,
+		updated_by = $%[2]d
+		from prior
+		where id = $%[3]d
+		returning mtime, signature, updated_by, prior.status`,
+		strings.Join(sets, ",\n\t\t"),
+		len(args)-1,
+		len(args),
+	)
+
+	var from int
+	err = conn.QueryRow(ctx, query, args...).
+		Scan(
+			&u.Mtime,
+			&u.Signature,
+			&u.UpdatedBy,
+			&from,
+		)
+	if err != nil {
+		if name, ok := postgresql.ConstraintName(err); ok && name == "users_email_digest_org" {
+			return model.MutationResult{}, fmt.Errorf("%w: %w", ErrEmailTaken, err)
+		}
+		return model.MutationResult{}, err
+	}
+
+	for _, field := range fields {
+		v := *pii[field.Column]
+		field.Set(u, v)
+		field.SetDigest(u, digest.SHA256Hex(v))
+	}
+	if patch.Status != nil {
+		u.Status = *patch.Status
+		if from != u.Status {
+			sink.Record(ctx, runtime.StatusChange{
+				UserID: u.ID,
+				From:   from,
+				To:     u.Status,
+				At:     time.Unix(u.Mtime, 0),
+			})
+		}
+	}
+	return u.mutationResult(), nil
+}
+
+// UpdateDisplayName replaces the display name and encrypts it in the db.
+// A display name whose digest matches `u.DisplayNameDigest` is not
+// written, so mtime and signature are unchanged. Ciphertexts use a
+// random nonce, so only the digests can be compared.
+func (u *User) UpdateDisplayName(
+	ctx context.Context,
+	conn postgresql.DB,
+	actor uuid.UUID,
+	m key.KeyProvider,
+	displayName string,
+) (model.MutationResult, error) {
+	if digest.SHA256Hex(displayName) == u.DisplayNameDigest {
+		return model.MutationResult{Mtime: u.Mtime, Signature: u.Signature}, nil
+	}
+
+	versionedKey, err := m.Get(u.KeyVersion)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+
+	encryptedDisplayName, err := crypt.Encrypt(
+		displayName,
+		key.DeriveField(versionedKey.Key, displayNameLabel),
+	)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+
+	const query = `update users 
+		set display_name = $1,
+		display_name_digest = $2,
+		updated_by = $3
+		where id = $4
+		returning mtime, signature, display_name_digest, updated_by`
+
+	err = conn.QueryRow(
+		ctx,
+		query,
+		encryptedDisplayName,
+		digest.SHA256Hex(displayName),
+		actor,
+		u.ID,
+	).
+		Scan(
+			&u.Mtime,
+			&u.Signature,
+			&u.DisplayNameDigest,
+			&u.UpdatedBy,
+		)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+
+	u.DisplayName = displayName
+	return u.mutationResult(), nil
+}
+
+// VerifyAndMaybeRehash returns true if `guess` matches the user's
+// password. Verification goes through `l`, usually
+// `State.PasswordLimiter`, so a login storm returns `password.ErrBusy`
+// rather than exhausting the CPU; a nil `l` does not limit. On a match,
+// a password hashed with parameters other than `cfg` is rehashed with
+// `cfg` and updated. The rehash is best effort: its failure does not
+// change the result, and the next successful verification tries again.
+func (u *User) VerifyAndMaybeRehash(
+	ctx context.Context,
+	conn postgresql.DB,
+	l *password.Limiter,
+	guess string,
+	cfg argon2.Config,
+) (bool, error) {
+	ok, err := l.Verify(guess, u.Password)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	needsRehash, err := password.NeedsRehash(u.Password, cfg)
+	if err != nil || !needsRehash {
+		return true, nil
+	}
+
+	encoded, err := password.Encode(guess, cfg)
+	if err != nil {
+		return true, nil
+	}
+	_, _ = u.UpdatePassword(ctx, conn, u.ID, encoded)
+
+	return true, nil
+}
+
+// UpdatePassword replaces the password. Password is Argon2-formatted.
+func (u *User) UpdatePassword(
+	ctx context.Context,
+	conn postgresql.DB,
+	actor uuid.UUID,
+	password string,
+) (model.MutationResult, error) {
+	const query = `update users 
+		set password = $1,
+		updated_by = $2
+		where id = $3
+		returning mtime, signature, password, updated_by`
+
+	err := conn.QueryRow(
+		ctx,
+		query,
 		password,
+		actor,
+		u.ID,
+	).
+		Scan(
+			&u.Mtime,
+			&u.Signature,
+			&u.Password,
+			&u.UpdatedBy,
+		)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+	return u.mutationResult(), nil
+}
+
+// UpdateStatus sets the status of `u`. A transition to a different
+// status is reported to `sink`; the prior status is read under a row
+// lock in the same statement. A nil `sink` returns
+// `runtime.ErrNoStatusSink`, so auditing cannot be skipped by omission;
+// pass `runtime.DiscardStatus` to disable it.
+func (u *User) UpdateStatus(
+	ctx context.Context,
+	conn postgresql.DB,
+	sink runtime.StatusSink,
+	actor uuid.UUID,
+	status int,
+) (model.MutationResult, error) {
+	err := runtime.CheckStatusSink(sink)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+
+	const query = `with prior as (
+			select status from users where id = $3 for update
+		)
+		update users 
+		set status = $1,
+		updated_by = $2
+		from prior
+		where id = $3
+		returning mtime, signature, users.status, updated_by, prior.status`
+
+	var from int
+	err = conn.QueryRow(
+		ctx,
+		query,
+		status,
+		actor,
 		u.ID,
 	).
 		Scan(
 			&u.Mtime,
 			&u.Signature,
-			&u.Password,
+			&u.Status,
+			&u.UpdatedBy,
+			&from,
 		)
+	if err != nil {
+		return model.MutationResult{}, err
+	}
+	if from != u.Status {
+		sink.Record(ctx, runtime.StatusChange{
+			UserID: u.ID,
+			From:   from,
+			To:     u.Status,
+			At:     time.Unix(u.Mtime, 0),
+		})
+	}
+	return u.mutationResult(), nil
 }
 
-func (u *User) UpdateStatus(
+// UpdateRoleAuthorized changes the role of `target` to `newRole` if
+// `actor` is an active admin of the target's org, or returns
+// `ErrUnauthorized`. The actor's role, status, and org are checked
+// against the database in the same statement as the update, not taken
+// from `actor`.
+//
+// The owner of an org must keep `role.OrgOwner` of the org's role
+// (`role.Admin` outside test orgs); demoting the owner returns
+// `ErrOwnerMustBeAdmin`. The org row is share-locked in the same
+// transaction, so ownership cannot be transferred between the check and
+// the update.
+func UpdateRoleAuthorized(
 	ctx context.Context,
-	conn *pgx.Conn,
-	status int,
+	conn postgresql.DB,
+	actor *User,
+	target *User,
+	newRole int,
 ) error {
-	const query = `update users 
-		set status = $1 
+	if !role.Valid(newRole) {
+		return ErrRole
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) // nolint:errcheck
+
+	const query = `update users
+		set role = $1,
+		updated_by = $2
+		where id = $3
+		and exists (
+			select 1 from users a
+			where a.id = $2
+			and a.org = users.org
+			and a.role = $4
+			and a.status = $5)
+		returning mtime, signature, role, updated_by`
+
+	var mtime int64
+	var signature uuid.UUID
+	var updatedRole int
+	var updatedBy *uuid.UUID
+	err = tx.QueryRow(
+		ctx,
+		query,
+		newRole,
+		actor.ID,
+		target.ID,
+		role.Admin,
+		status.Active,
+	).
+		Scan(
+			&mtime,
+			&signature,
+			&updatedRole,
+			&updatedBy,
+		)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUnauthorized
+	}
+	if err != nil {
+		return err
+	}
+
+	// A user whose org row does not exist owns nothing.
+	const ownerQuery = `select o.role
+		from orgs o join users u on u.org = o.id
+		where u.id = $1 and o.owner = u.id
+		for share of o`
+
+	var orgRole int
+	err = tx.QueryRow(ctx, ownerQuery, target.ID).Scan(&orgRole)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+	case err != nil:
+		return err
+	case newRole != role.OrgOwner(orgRole):
+		return ErrOwnerMustBeAdmin
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	target.Mtime = mtime
+	target.Signature = signature
+	target.Role = updatedRole
+	target.UpdatedBy = updatedBy
+	return nil
+}
+
+// SetRoleForUsers sets `role` for each user in `ids` that belongs to
+// `org`, returning the number of users updated. Ids of users outside
+// `org`, or of no user at all, are ignored.
+//
+// If `ids` includes the owner of `org`, `role` must be `role.OrgOwner`
+// of the org's role, as in `UpdateRoleAuthorized`; otherwise
+// `ErrOwnerMustBeAdmin` is returned and no user is changed. The org row
+// is share-locked in the same transaction as the update.
+func SetRoleForUsers(
+	ctx context.Context,
+	conn postgresql.DB,
+	actor uuid.UUID,
+	org uuid.UUID,
+	ids []uuid.UUID,
+	newRole int,
+) (int64, error) {
+	if !role.Valid(newRole) {
+		return 0, ErrRole
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx) // nolint:errcheck
+
+	// An org without a row owns nothing.
+	const ownerQuery = `select role from orgs
+		where id = $1 and owner = any($2)
+		for share`
+
+	var orgRole int
+	err = tx.QueryRow(ctx, ownerQuery, org, ids).Scan(&orgRole)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+	case err != nil:
+		return 0, err
+	case newRole != role.OrgOwner(orgRole):
+		return 0, ErrOwnerMustBeAdmin
+	}
+
+	const query = `update users
+		set role = @role,
+		updated_by = @actor
+		where org = @org and id = any(@ids)`
+	args := pgx.NamedArgs{
+		"role":  newRole,
+		"org":   org,
+		"ids":   ids,
+		"actor": actor,
+	}
+	result, err := tx.Exec(ctx, query, args)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// RotateSignature replaces the signature without changing any other
+// column, which invalidates anything bound to the prior signature.
+func (u *User) RotateSignature(
+	ctx context.Context,
+	conn postgresql.DB,
+	actor uuid.UUID,
+) error {
+	const query = `update users
+		set signature = gen_random_uuid(),
+		updated_by = $1
 		where id = $2
-		returning mtime, signature, status`
+		returning mtime, signature, updated_by`
 
 	return conn.QueryRow(
 		ctx,
 		query,
-		status,
+		actor,
 		u.ID,
 	).
+		Scan(
+			&u.Mtime,
+			&u.Signature,
+			&u.UpdatedBy,
+		)
+}
+
+// shreddedPassword replaces the password hash of a shredded user. It
+// is not an Argon2 encoding, so no guess verifies against it.
+const shreddedPassword = "shredded"
+
+// Shred irreversibly erases the PII of the user. Each encrypted PII
+// column is overwritten with a random placeholder encrypted under
+// `versionedKey`, with a matching digest, so the row still decrypts and
+// list, scan, and export paths keep working. The password hash is
+// replaced by `shreddedPassword`, the status is set to `status.Deleted`,
+// and the signature is rotated. PII digests and password hashes are also
+// removed from the user's audit log entries, and archived Ed25519 public
+// keys, secondary emails, and prior versions of the row in
+// `users_history` are deleted.
+//
+// Unlike setting `status.Inactive`, this cannot be undone. The row and its
+// id are kept so references to the user remain valid; `Read` returns it
+// with the placeholders. The transition to `status.Deleted` is reported to
+// `sink`; a nil `sink` returns `runtime.ErrNoStatusSink`.
+func (u *User) Shred(
+	ctx context.Context,
+	conn postgresql.DB,
+	sink runtime.StatusSink,
+	versionedKey key.Versioned,
+) error {
+	err := runtime.CheckStatusSink(sink)
+	if err != nil {
+		return err
+	}
+
+	// Placeholders are random so the per-org unique digests of
+	// shredded users do not collide.
+	placeholders := make([]string, len(encryptedFields))
+	var sets []string
+	var args []any
+	for i, field := range encryptedFields {
+		placeholders[i] = hex.EncodeToString(key.Random())
+		encrypted, err := crypt.Encrypt(
+			placeholders[i],
+			field.Key(versionedKey.Key),
+		)
+		if err != nil {
+			return err
+		}
+		args = append(args, encrypted, digest.SHA256Hex(placeholders[i]))
+		sets = append(sets,
+			fmt.Sprintf("%s = $%d", field.Column, len(args)-1),
+			fmt.Sprintf("%s = $%d", field.DigestColumn, len(args)),
+		)
+	}
+	args = append(args, versionedKey.Version, shreddedPassword, status.Deleted, u.ID)
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) // nolint:errcheck
+
+	query := fmt.Sprintf(`with prior as (
+			select status from users where id = $%[5]d for update
+		)
+		update users
+		set %[1]s,
+		key_version = $%[2]d,
+		password = $%[3]d,
+		ed25519_private = null,
+		ed25519_private_digest = null,
+		status = $%[4]d,
+		updated_by = null
+		from prior
+		where id = $%[5]d
+		returning mtime, signature, users.status, updated_by, prior.status`,
+		strings.Join(sets, ",\n\t\t"),
+		len(args)-3,
+		len(args)-2,
+		len(args)-1,
+		len(args),
+	)
+
+	var from int
+	err = tx.QueryRow(ctx, query, args...).
 		Scan(
 			&u.Mtime,
 			&u.Signature,
 			&u.Status,
+			&u.UpdatedBy,
+			&from,
 		)
+	if err != nil {
+		return err
+	}
+
+	auditColumns := []string{"password"}
+	for _, field := range encryptedFields {
+		auditColumns = append(auditColumns, field.DigestColumn)
+	}
+
+	const auditQuery = `update audit_log
+		set details = '{}'::jsonb
+		where audit_table = 'users'
+		and audit_id = $1
+		and audit_column = any($2)`
+
+	_, err = tx.Exec(ctx, auditQuery, u.ID, auditColumns)
+	if err != nil {
+		return err
+	}
+
+	const historyQuery = `delete from ed25519_public_history where user_id = $1`
+
+	_, err = tx.Exec(ctx, historyQuery, u.ID)
+	if err != nil {
+		return err
+	}
+
+	const emailsQuery = `delete from user_emails where user_id = $1`
+
+	_, err = tx.Exec(ctx, emailsQuery, u.ID)
+	if err != nil {
+		return err
+	}
+
+	// Prior rows, including the one archived by this update, hold PII.
+	const usersHistoryQuery = `delete from users_history where id = $1`
+
+	_, err = tx.Exec(ctx, usersHistoryQuery, u.ID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i, field := range encryptedFields {
+		field.Set(u, placeholders[i])
+		field.SetDigest(u, digest.SHA256Hex(placeholders[i]))
+	}
+	u.KeyVersion = versionedKey.Version
+	u.Password = shreddedPassword
+	u.Ed25519PrivateEncrypted = nil
+	u.Ed25519PrivateDigest = nil
+	u.ed25519Private = ""
+	if from != u.Status {
+		sink.Record(ctx, runtime.StatusChange{
+			UserID: u.ID,
+			From:   from,
+			To:     u.Status,
+			At:     time.Unix(u.Mtime, 0),
+		})
+	}
+	return nil
+}
+
+// Delete removes the users row with `id`, along with its secondary
+// emails, archived Ed25519 public keys, and prior versions in
+// `users_history`, and clears the details of its audit log entries. A
+// missing user returns `pgx.ErrNoRows`.
+//
+// The owner of an org cannot be deleted, since that would leave
+// `orgs.owner` dangling; `ErrOwnerDelete` is returned and nothing is
+// changed. Transfer ownership first. Unlike `Shred`, no row remains, so
+// references to the user elsewhere no longer resolve.
+func Delete(
+	ctx context.Context,
+	conn postgresql.DB,
+	id uuid.UUID,
+) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) // nolint:errcheck
+
+	const query = `delete from users where id = $1`
+
+	result, err := tx.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	switch result.RowsAffected() {
+	case 0:
+		return pgx.ErrNoRows
+	case 1:
+	default:
+		return postgresql.ErrRowsAffected
+	}
+
+	// The row is locked by the delete, so this sees any ownership
+	// committed before it.
+	const ownerQuery = `select id from orgs where owner = $1 limit 1`
+
+	var org uuid.UUID
+	err = tx.QueryRow(ctx, ownerQuery, id).Scan(&org)
+	if err == nil {
+		return fmt.Errorf("%w: org %s", ErrOwnerDelete, org)
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	for _, q := range []string{
+		`delete from user_emails where user_id = $1`,
+		`delete from ed25519_public_history where user_id = $1`,
+		`delete from users_history where id = $1`,
+		`update audit_log set details = '{}'::jsonb
+		where audit_table = 'users' and audit_id = $1`,
+	} {
+		_, err = tx.Exec(ctx, q, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
 }
 
 // ReEncrypt changes the encrypted values for PII fields and updates the
-// instance key version.
+// instance key version. Archived Ed25519 public keys and secondary
+// emails are re-encrypted in the same transaction, reading them with
+// the keys in `m`.
+//
+// A stored Ed25519 private key is re-encrypted too, which requires it
+// to have been decrypted with `Ed25519Private` or set with
+// `SetEd25519Private` first; otherwise `ErrEd25519PrivateLocked` is
+// returned and nothing is changed.
 func (u *User) ReEncrypt(
 	ctx context.Context,
-	conn *pgx.Conn,
+	conn postgresql.DB,
+	actor uuid.UUID,
+	m key.KeyProvider,
 	versionedKey key.Versioned,
 ) error {
-	encryptedDisplayName, err := crypt.Encrypt(u.DisplayName, versionedKey.Key)
+	var sets []string
+	var args []any
+	for _, field := range encryptedFields {
+		encrypted, err := crypt.Encrypt(field.Get(u), field.Key(versionedKey.Key))
+		if err != nil {
+			return err
+		}
+		args = append(args, encrypted)
+		sets = append(sets, fmt.Sprintf("%s = $%d", field.Column, len(args)))
+	}
+	if u.Ed25519PrivateEncrypted != nil {
+		if u.ed25519Private == "" {
+			return ErrEd25519PrivateLocked
+		}
+		encrypted, err := crypt.Encrypt(
+			u.ed25519Private,
+			key.DeriveField(versionedKey.Key, ed25519PrivateLabel),
+		)
+		if err != nil {
+			return err
+		}
+		args = append(args, encrypted)
+		sets = append(sets, fmt.Sprintf("ed25519_private = $%d", len(args)))
+	}
+	args = append(args, versionedKey.Version, actor, u.ID)
+
+	tx, err := conn.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx) // nolint:errcheck
+
+	query := fmt.Sprintf(`update users
+		set %s,
+		key_version = $%d,
+		updated_by = $%d
+		where id = $%d
+		returning mtime, signature, key_version, ed25519_private, updated_by`,
+		strings.Join(sets, ",\n\t\t"),
+		len(args)-2,
+		len(args)-1,
+		len(args),
+	)
 
-	encryptedEd25519Public, err := crypt.Encrypt(u.Ed25519Public, versionedKey.Key)
+	updated := *u
+	err = tx.QueryRow(ctx, query, args...).
+		Scan(
+			&updated.Mtime,
+			&updated.Signature,
+			&updated.KeyVersion,
+			&updated.Ed25519PrivateEncrypted,
+			&updated.UpdatedBy,
+		)
 	if err != nil {
 		return err
 	}
 
-	encryptedEmail, err := crypt.Encrypt(u.Email, versionedKey.Key)
+	err = reEncryptEd25519History(ctx, tx, m, u.ID, versionedKey)
 	if err != nil {
 		return err
 	}
 
-	const query = `update users 
-		set display_name = $1,
-		ed25519_public = $2,
-		email = $3,
-		key_version = $4
-		where id = $5
-		returning mtime, signature, key_version`
+	err = reEncryptEmails(ctx, tx, m, u.ID, versionedKey)
+	if err != nil {
+		return err
+	}
 
-	return conn.QueryRow(
-		ctx,
-		query,
-		encryptedDisplayName,
-		encryptedEd25519Public,
-		encryptedEmail,
-		versionedKey.Version,
-		u.ID,
-	).
-		Scan(
-			&u.Mtime,
-			&u.Signature,
-			&u.KeyVersion,
-		)
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	u.Mtime = updated.Mtime
+	u.Signature = updated.Signature
+	u.KeyVersion = updated.KeyVersion
+	u.Ed25519PrivateEncrypted = updated.Ed25519PrivateEncrypted
+	u.UpdatedBy = updated.UpdatedBy
+	return nil
+}
+
+// ValidateToken decodes a token produced by `jwt.EncodeClaims` and reads
+// its subject. `ErrTokenRevoked` is returned if the token's `sig` claim
+// is missing or no longer matches the signature of the subject's row.
+//
+// The `metadata_update` trigger gives the row a new signature on every
+// update, not only in `RotateSignature`. This is intended: a token is
+// bound to the row as it was when the token was issued, so any change to
+// the user, including a profile edit, a status change, or a rehash in
+// `VerifyAndMaybeRehash`, revokes outstanding tokens and the client must
+// authenticate again.
+func ValidateToken(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	tokenStr string,
+	issuer string,
+	signingKey []byte,
+) (*User, error) {
+	token, err := jwt.Decode(tokenStr, issuer, signingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := token.Claims.GetSubject()
+	if err != nil {
+		return nil, err
+	}
+	id, err := runtime.ParseID(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	// A token without a `sig` claim cannot be checked for revocation.
+	sig, err := jwt.Signature(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenRevoked, err)
+	}
+
+	user, err := Read(ctx, conn, m, id)
+	if err != nil {
+		return nil, err
+	}
+	if user.Signature != sig {
+		return nil, ErrTokenRevoked
+	}
+
+	return user, nil
+}
+
+// IntegrityFailure identifies a PII column of a users row that could not
+// be decrypted and verified against its digest.
+type IntegrityFailure struct {
+	ID     uuid.UUID
+	Column string
+	Err    error
+}
+
+// IntegrityScan attempts to decrypt the PII columns of every user in `org`
+// and returns the failures found. A bad row does not stop the scan; only
+// query errors are returned as `error`.
+func IntegrityScan(
+	ctx context.Context,
+	conn postgresql.DB,
+	m key.KeyProvider,
+	org uuid.UUID,
+) ([]IntegrityFailure, error) {
+	const query = `select * from users where org = @org order by insert_order`
+	args := pgx.NamedArgs{"org": org}
+	rows, err := conn.Query(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	users, err := pgx.CollectRows(rows, pgx.RowToStructByName[User])
+	if err != nil {
+		return nil, err
+	}
+
+	var failures []IntegrityFailure
+	for _, user := range users {
+		versionedKey, err := m.Get(user.KeyVersion)
+		if err != nil {
+			failures = append(failures, IntegrityFailure{
+				ID:     user.ID,
+				Column: "key_version",
+				Err:    err,
+			})
+			continue
+		}
+
+		for _, field := range encryptedFields {
+			_, err = crypt.Decrypt(
+				field.Get(&user),
+				field.GetDigest(&user),
+				field.Key(versionedKey.Key),
+			)
+			if err != nil {
+				failures = append(failures, IntegrityFailure{
+					ID:     user.ID,
+					Column: field.Column,
+					Err:    err,
+				})
+			}
+		}
+
+		if user.Ed25519PrivateEncrypted != nil {
+			_, err = user.Ed25519Private(m)
+			if err != nil {
+				failures = append(failures, IntegrityFailure{
+					ID:     user.ID,
+					Column: "ed25519_private",
+					Err:    err,
+				})
+			}
+		}
+	}
+
+	return failures, nil
+}
+
+// Orphans returns the ids of at most `limit` users whose org does not
+// exist. Such users cannot be reached through their org; see
+// `ReassignOrDelete` for remediation.
+func Orphans(
+	ctx context.Context,
+	conn postgresql.DB,
+	limit int,
+) ([]uuid.UUID, error) {
+	const query = `select u.id
+		from users u left join orgs o on o.id = u.org
+		where o.id is null
+		order by u.insert_order
+		limit $1`
+
+	rows, err := conn.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+}
+
+// DisplayNameDigestCount returns how many users in `org` have the
+// display name whose digest is `displayNameDigest`, so duplicates can be
+// reported without decrypting any display name. Shredded users never
+// match, since `Shred` overwrites their digests.
+func DisplayNameDigestCount(
+	ctx context.Context,
+	conn postgresql.DB,
+	org uuid.UUID,
+	displayNameDigest string,
+) (int64, error) {
+	const query = `select count(*) from users
+		where org = $1 and display_name_digest = $2`
+
+	var n int64
+	err := conn.QueryRow(ctx, query, org, displayNameDigest).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// ReassignOrDelete is a remediation policy for users found by
+// `Orphans`. If `Org` is set, orphans are moved to that org; otherwise
+// they are erased with `Shred` under the key passed to `Apply`, which
+// reports each status transition to the sink passed to `Apply`.
+type ReassignOrDelete struct {
+	Org uuid.UUID
+}
+
+// Apply remediates the users in `ids` that are still orphans, returning
+// how many were changed. Ids of users that have an org are ignored, so
+// the result of an earlier `Orphans` call is safe to pass; orphans
+// already shredded are not shredded again. Returns `ErrOrgNotFound` if
+// `p.Org` is set but does not exist, and `runtime.ErrNoStatusSink` if
+// `sink` is nil.
+func (p ReassignOrDelete) Apply(
+	ctx context.Context,
+	conn postgresql.DB,
+	sink runtime.StatusSink,
+	versionedKey key.Versioned,
+	ids []uuid.UUID,
+) (int64, error) {
+	err := runtime.CheckStatusSink(sink)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	const orphaned = `id = any(@ids)
+		and not exists (select 1 from orgs o where o.id = users.org)`
+
+	args := pgx.NamedArgs{"ids": ids}
+	if p.Org != uuid.Nil {
+		var exists bool
+		const existsQuery = `select exists (select 1 from orgs where id = $1)`
+		err := conn.QueryRow(ctx, existsQuery, p.Org).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, ErrOrgNotFound
+		}
+		query := `update users set org = @org, updated_by = null where ` + orphaned
+		args["org"] = p.Org
+
+		result, err := conn.Exec(ctx, query, args)
+		if err != nil {
+			return 0, err
+		}
+		return result.RowsAffected(), nil
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx) // nolint:errcheck
+
+	query := `select id from users
+		where status != @status and ` + orphaned + `
+		order by insert_order
+		for update`
+	args["status"] = status.Deleted
+
+	rows, err := tx.Query(ctx, query, args)
+	if err != nil {
+		return 0, err
+	}
+	orphans, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range orphans {
+		// Shred only needs the id.
+		err = (&User{ID: id}).Shred(ctx, tx, sink, versionedKey)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(orphans)), nil
 }
 
 // ForTest creates a new instance of a User for test automation only.
 func ForTest(
 	ctx context.Context,
-	conn *pgx.Conn,
+	conn postgresql.DB,
 	versionKey key.Versioned,
 	org uuid.UUID,
 	status int,
@@ -378,11 +2252,12 @@ func ForTest(
 	u, err := Insert(
 		context.Background(),
 		conn,
+		runtime.NoRateLimit{},
 		versionKey,
 		uuid.NewString(), // display name
 		ed25519PublicPEM,
-		uuid.NewString(),  // email
-		uuid.New(),        // org
+		uuid.NewString(), // email
+		org,
 		password.Random(), // password
 		role.Test,
 		SchemaVersion,
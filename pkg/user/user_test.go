@@ -6,21 +6,31 @@ package user
 
 import (
 	"context"
+	crypto_ed25519 "crypto/ed25519"
+	"errors"
 	"log"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/matthewhartstonge/argon2"
 	"github.com/stretchr/testify/require"
+	"grokloc.com/pkg/model"
 	"grokloc.com/pkg/model/role"
 	"grokloc.com/pkg/model/status"
 	"grokloc.com/pkg/postgresql"
 	"grokloc.com/pkg/runtime"
+	"grokloc.com/pkg/security/crypt"
 	"grokloc.com/pkg/security/digest"
 	"grokloc.com/pkg/security/ed25519"
+	"grokloc.com/pkg/security/jwt"
 	"grokloc.com/pkg/security/key"
 	"grokloc.com/pkg/security/password"
+	"grokloc.com/pkg/testsupport"
 )
 
 var st *runtime.State
@@ -55,6 +65,7 @@ func TestInsert(t *testing.T) {
 		user, err := Insert(
 			context.Background(),
 			conn.Conn(),
+			st.RateLimiter,
 			*versionKey,
 			displayName,
 			ed25519PublicPEM,
@@ -92,6 +103,16 @@ func TestInsert(t *testing.T) {
 			user.SchemaVersion, "schema version")
 		require.NotNil(t, user.Signature, "signature")
 		require.Equal(t, status.Active, user.Status, "status")
+
+		// Insert assembles the user without reading it back.
+		readUser, err := Read(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+		)
+		require.NoError(t, err, "read")
+		require.Equal(t, *readUser, *user, "round trip")
 	})
 
 	t.Run("Conflict", func(t *testing.T) {
@@ -108,6 +129,7 @@ func TestInsert(t *testing.T) {
 		user, err := Insert(
 			context.Background(),
 			conn.Conn(),
+			st.RateLimiter,
 			*versionKey,
 			uuid.NewString(), // display name
 			ed25519PublicPEM,
@@ -125,6 +147,7 @@ func TestInsert(t *testing.T) {
 		_, err = Insert(
 			context.Background(),
 			conn.Conn(),
+			st.RateLimiter,
 			*versionKey,
 			uuid.NewString(), // display name
 			ed25519PublicPEM,
@@ -138,6 +161,7 @@ func TestInsert(t *testing.T) {
 
 		require.Error(t, err, "ed25519 conflict")
 		require.True(t, postgresql.UniqueConstraint(err), "err")
+		require.ErrorIs(t, err, ErrEd25519Taken, "ed25519 taken")
 
 		// Conflict when email is used twice in user.Org.
 
@@ -148,6 +172,7 @@ func TestInsert(t *testing.T) {
 		_, err = Insert(
 			context.Background(),
 			conn.Conn(),
+			st.RateLimiter,
 			*versionKey,
 			uuid.NewString(), // display name
 			ed25519PublicPEM,
@@ -161,6 +186,104 @@ func TestInsert(t *testing.T) {
 
 		require.Error(t, err, "email conflict")
 		require.True(t, postgresql.UniqueConstraint(err), "err")
+		require.ErrorIs(t, err, ErrEmailTaken, "email taken")
+	})
+
+	t.Run("WithID", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		insert := func(id uuid.UUID) (*User, error) {
+			ed25519PublicPEM, _, err := ed25519.Random()
+			require.NoError(t, err, "generate ed25519")
+			return InsertWithID(
+				context.Background(),
+				conn.Conn(),
+				st.RateLimiter,
+				id,
+				*versionKey,
+				uuid.NewString(), // display name
+				ed25519PublicPEM,
+				uuid.NewString(),  // email
+				uuid.New(),        // org
+				password.Random(), // password
+				role.Test,
+				SchemaVersion,
+				status.Active,
+			)
+		}
+
+		id := uuid.New()
+		user, err := insert(id)
+		require.NoError(t, err, "insert")
+		require.Equal(t, id, user.ID, "id")
+
+		_, err = insert(id)
+		require.Error(t, err, "id conflict")
+		require.Equal(t, ErrIDInUse, err, "err")
+
+		_, err = insert(uuid.Nil)
+		require.Error(t, err, "nil id")
+		require.Equal(t, runtime.ErrInvalidID, err, "err")
+	})
+}
+
+func TestFieldKeys(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+
+		var encryptedDisplayName, encryptedEmail string
+		err = conn.QueryRow(
+			context.Background(),
+			`select display_name, email from users where id = $1`,
+			user.ID,
+		).Scan(&encryptedDisplayName, &encryptedEmail)
+		require.NoError(t, err, "select encrypted")
+
+		// The versioned key alone cannot decrypt derived-key columns.
+		_, err = crypt.Decrypt(encryptedEmail, user.EmailDigest, versionKey.Key)
+		require.Error(t, err, "email base key")
+		_, err = crypt.Decrypt(
+			encryptedDisplayName,
+			user.DisplayNameDigest,
+			versionKey.Key,
+		)
+		require.Error(t, err, "display name base key")
+
+		email, err := crypt.Decrypt(
+			encryptedEmail,
+			user.EmailDigest,
+			key.DeriveField(versionKey.Key, emailLabel),
+		)
+		require.NoError(t, err, "email field key")
+		require.Equal(t, user.Email, email, "email")
+
+		displayName, err := crypt.Decrypt(
+			encryptedDisplayName,
+			user.DisplayNameDigest,
+			key.DeriveField(versionKey.Key, displayNameLabel),
+		)
+		require.NoError(t, err, "display name field key")
+		require.Equal(t, user.DisplayName, displayName, "display name")
 	})
 }
 
@@ -226,11 +349,13 @@ func TestRead(t *testing.T) {
 			status.Active,
 		)
 
-		// Empty VersionedMap does not have user.KeyVersion.
+		// The key version of user.KeyVersion has been retired.
+		keys := key.NewMemoryProvider(*versionKey)
+		keys.Remove(versionKey.Version)
 		_, err = Read(
 			context.Background(),
 			conn.Conn(),
-			make(key.VersionedMap),
+			keys,
 			user.ID,
 		)
 
@@ -240,6 +365,64 @@ func TestRead(t *testing.T) {
 
 }
 
+func TestReadMany(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		var ids []uuid.UUID
+		users := make(map[uuid.UUID]*User)
+		for range 3 {
+			user := ForTest(
+				context.Background(),
+				conn.Conn(),
+				*versionKey,
+				uuid.New(),
+				status.Active,
+			)
+			ids = append(ids, user.ID)
+			users[user.ID] = user
+		}
+
+		// Missing ids are absent rather than an error.
+		missing := uuid.New()
+		ids = append(ids, missing)
+
+		readUsers, err := ReadMany(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			ids,
+		)
+
+		require.NoError(t, err, "read many")
+		require.Equal(t, users, readUsers, "round trip")
+		require.NotContains(t, readUsers, missing, "missing")
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.RandomReplica().Acquire(context.Background())
+		require.NoError(t, err, "replica conn")
+		defer conn.Release()
+
+		readUsers, err := ReadMany(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			[]uuid.UUID{},
+		)
+
+		require.NoError(t, err, "read many")
+		require.Empty(t, readUsers, "empty")
+	})
+}
+
 func TestNewEd25519(t *testing.T) {
 	t.Run("OK", func(t *testing.T) {
 		t.Parallel()
@@ -268,6 +451,7 @@ func TestNewEd25519(t *testing.T) {
 		err = user.NewEd25519(
 			context.Background(),
 			conn.Conn(),
+			uuid.New(),
 			st.EncryptionKeys,
 			ed25519PublicPEM,
 		)
@@ -278,15 +462,15 @@ func TestNewEd25519(t *testing.T) {
 		require.True(t, mtime <= user.Mtime, "mtime")
 		require.NotEqual(t, signature, user.Signature, "signature")
 
-		readUser, err := Read(
+		testsupport.AssertRoundTrip(
+			t,
 			context.Background(),
 			conn.Conn(),
 			st.EncryptionKeys,
 			user.ID,
+			user,
+			Read,
 		)
-
-		require.NoError(t, err, "read")
-		require.Equal(t, *user, *readUser, "round trip")
 	})
 
 	t.Run("KeyNotFound", func(t *testing.T) {
@@ -309,13 +493,15 @@ func TestNewEd25519(t *testing.T) {
 		ed25519PublicPEM, _, err := ed25519.Random()
 		require.NoError(t, err, "generate ed25519")
 
-		// Decryption key will not be found in empty map.
-		emptyEncryptionKeys := make(key.VersionedMap)
+		// Decryption key will not be found once retired.
+		retiredKeys := key.NewMemoryProvider(*versionKey)
+		retiredKeys.Remove(versionKey.Version)
 
 		err = user.NewEd25519(
 			context.Background(),
 			conn.Conn(),
-			emptyEncryptionKeys,
+			uuid.New(),
+			retiredKeys,
 			ed25519PublicPEM,
 		)
 
@@ -324,6 +510,125 @@ func TestNewEd25519(t *testing.T) {
 	})
 }
 
+func TestUpdateEmail(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+
+		mtime := user.Mtime
+		signature := user.Signature
+		email := uuid.NewString() + "@example.com"
+		actor := uuid.New()
+
+		err = user.UpdateEmail(
+			context.Background(),
+			conn.Conn(),
+			actor,
+			st.EncryptionKeys,
+			strings.ToUpper(email),
+		)
+
+		require.NoError(t, err, "update email")
+		require.Equal(t, &actor, user.UpdatedBy, "updated by")
+		require.Equal(t, email, user.Email, "email")
+		require.Equal(t, digest.SHA256Hex(email), user.EmailDigest, "email digest")
+		require.True(t, mtime <= user.Mtime, "mtime")
+		require.NotEqual(t, signature, user.Signature, "signature")
+
+		testsupport.AssertRoundTrip(
+			t,
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+			user,
+			Read,
+		)
+
+		err = user.UpdateEmail(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			st.EncryptionKeys,
+			"not an email",
+		)
+		require.ErrorIs(t, err, ErrEmail, "malformed")
+	})
+
+	t.Run("Taken", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		user := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+		other := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+
+		email := uuid.NewString() + "@example.com"
+		err = other.UpdateEmail(context.Background(), conn.Conn(), uuid.New(), st.EncryptionKeys, email)
+		require.NoError(t, err, "update other")
+
+		err = user.UpdateEmail(context.Background(), conn.Conn(), uuid.New(), st.EncryptionKeys, email)
+		require.True(t, postgresql.UniqueConstraint(err), "err")
+		require.ErrorIs(t, err, ErrEmailTaken, "email taken")
+
+		// The same email in another org is allowed.
+		elsewhere := ForTest(context.Background(), conn.Conn(), *versionKey, uuid.New(), status.Active)
+		err = elsewhere.UpdateEmail(context.Background(), conn.Conn(), uuid.New(), st.EncryptionKeys, email)
+		require.NoError(t, err, "other org")
+	})
+
+	t.Run("KeyNotFound", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+
+		// Encryption key will not be found once retired.
+		retiredKeys := key.NewMemoryProvider(*versionKey)
+		retiredKeys.Remove(versionKey.Version)
+
+		err = user.UpdateEmail(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			retiredKeys,
+			uuid.NewString()+"@example.com",
+		)
+
+		require.Error(t, err, "empty keys")
+		require.Equal(t, err, key.ErrNotFound, "not found")
+	})
+}
+
 func TestUpdateDisplayName(t *testing.T) {
 	t.Run("OK", func(t *testing.T) {
 		t.Parallel()
@@ -348,9 +653,11 @@ func TestUpdateDisplayName(t *testing.T) {
 
 		displayName := uuid.NewString()
 
-		err = user.UpdateDisplayName(
+		actor := uuid.New()
+		_, err = user.UpdateDisplayName(
 			context.Background(),
 			conn.Conn(),
+			actor,
 			st.EncryptionKeys,
 			displayName,
 		)
@@ -361,15 +668,15 @@ func TestUpdateDisplayName(t *testing.T) {
 		require.True(t, mtime <= user.Mtime, "mtime")
 		require.NotEqual(t, signature, user.Signature, "signature")
 
-		readUser, err := Read(
+		testsupport.AssertRoundTrip(
+			t,
 			context.Background(),
 			conn.Conn(),
 			st.EncryptionKeys,
 			user.ID,
+			user,
+			Read,
 		)
-
-		require.NoError(t, err, "read")
-		require.Equal(t, *user, *readUser, "round trip")
 	})
 
 	t.Run("KeyNotFound", func(t *testing.T) {
@@ -389,19 +696,60 @@ func TestUpdateDisplayName(t *testing.T) {
 			status.Active,
 		)
 
-		// Decryption key will not be found in empty map.
-		emptyEncryptionKeys := make(key.VersionedMap)
+		// Decryption key will not be found once retired.
+		retiredKeys := key.NewMemoryProvider(*versionKey)
+		retiredKeys.Remove(versionKey.Version)
 
-		err = user.UpdateDisplayName(
+		actor := uuid.New()
+		_, err = user.UpdateDisplayName(
 			context.Background(),
 			conn.Conn(),
-			emptyEncryptionKeys,
+			actor,
+			retiredKeys,
 			uuid.NewString(),
 		)
 
 		require.Error(t, err, "empty keys")
 		require.Equal(t, err, key.ErrNotFound, "not found")
 	})
+
+	t.Run("Unchanged", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+
+		mtime := user.Mtime
+		signature := user.Signature
+
+		result, err := user.UpdateDisplayName(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			st.EncryptionKeys,
+			user.DisplayName,
+		)
+
+		require.NoError(t, err, "update display name")
+		require.False(t, result.Modified, "not modified")
+		require.Equal(t, mtime, user.Mtime, "mtime")
+		require.Equal(t, signature, user.Signature, "signature")
+
+		readUser, err := Read(context.Background(), conn.Conn(), st.EncryptionKeys, user.ID)
+		require.NoError(t, err, "read")
+		require.Equal(t, signature, readUser.Signature, "row not written")
+	})
 }
 
 func TestUpdatePassword(t *testing.T) {
@@ -428,9 +776,11 @@ func TestUpdatePassword(t *testing.T) {
 
 		pw := password.Random()
 
-		err = user.UpdatePassword(
+		actor := uuid.New()
+		_, err = user.UpdatePassword(
 			context.Background(),
 			conn.Conn(),
+			actor,
 			pw,
 		)
 
@@ -439,15 +789,15 @@ func TestUpdatePassword(t *testing.T) {
 		require.True(t, mtime <= user.Mtime, "mtime")
 		require.NotEqual(t, signature, user.Signature, "signature")
 
-		readUser, err := Read(
+		testsupport.AssertRoundTrip(
+			t,
 			context.Background(),
 			conn.Conn(),
 			st.EncryptionKeys,
 			user.ID,
+			user,
+			Read,
 		)
-
-		require.NoError(t, err, "read")
-		require.Equal(t, *user, *readUser, "round trip")
 	})
 }
 
@@ -473,26 +823,124 @@ func TestUpdateStatus(t *testing.T) {
 		signature := user.Signature
 		require.Equal(t, status.Active, user.Status)
 
-		err = user.UpdateStatus(
+		actor := uuid.New()
+		_, err = user.UpdateStatus(
 			context.Background(),
 			conn.Conn(),
+			st.StatusSink,
+			actor,
 			status.Inactive,
 		)
 
 		require.NoError(t, err, "update status")
 		require.Equal(t, status.Inactive, user.Status, "status")
+		require.Equal(t, &actor, user.UpdatedBy, "updated by")
 		require.True(t, mtime <= user.Mtime, "mtime")
 		require.NotEqual(t, signature, user.Signature, "signature")
 
-		readUser, err := Read(
+		testsupport.AssertRoundTrip(
+			t,
 			context.Background(),
 			conn.Conn(),
 			st.EncryptionKeys,
 			user.ID,
+			user,
+			Read,
+		)
+	})
+
+	t.Run("Sink", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
 		)
 
+		var changes []runtime.StatusChange
+		sink := runtime.StatusSinkFunc(
+			func(_ context.Context, c runtime.StatusChange) {
+				changes = append(changes, c)
+			})
+		ctx := context.Background()
+
+		_, err = user.UpdateStatus(ctx, conn.Conn(), sink, uuid.New(), status.Inactive)
+		require.NoError(t, err, "update status")
+		require.Len(t, changes, 1, "one change")
+		require.Equal(t, user.ID, changes[0].UserID, "user id")
+		require.Equal(t, status.Active, changes[0].From, "from")
+		require.Equal(t, status.Inactive, changes[0].To, "to")
+		require.Equal(t, user.Mtime, changes[0].At.Unix(), "at")
+
+		// Setting the same status again is not a transition.
+		_, err = user.UpdateStatus(ctx, conn.Conn(), sink, uuid.New(), status.Inactive)
+		require.NoError(t, err, "update status again")
+		require.Len(t, changes, 1, "no new change")
+
+		// `Update` and `Shred` report transitions too.
+		active := status.Active
+		_, err = user.Update(ctx, conn.Conn(), sink, uuid.New(), st.EncryptionKeys, UserPatch{Status: &active})
+		require.NoError(t, err, "update")
+		require.Len(t, changes, 2, "update change")
+		require.Equal(t, status.Inactive, changes[1].From, "update from")
+		require.Equal(t, status.Active, changes[1].To, "update to")
+		require.Equal(t, user.Mtime, changes[1].At.Unix(), "update at")
+
+		_, err = user.Update(ctx, conn.Conn(), sink, uuid.New(), st.EncryptionKeys, UserPatch{Status: &active})
+		require.NoError(t, err, "update again")
+		require.Len(t, changes, 2, "no update change")
+
+		err = user.Shred(ctx, conn.Conn(), sink, *versionKey)
+		require.NoError(t, err, "shred")
+		require.Len(t, changes, 3, "shred change")
+		require.Equal(t, status.Active, changes[2].From, "shred from")
+		require.Equal(t, status.Deleted, changes[2].To, "shred to")
+	})
+
+	t.Run("NoStatusSink", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+		before := *user
+
+		// A missing sink is an error, not a skipped audit record.
+		_, err = user.UpdateStatus(context.Background(), conn.Conn(), nil, uuid.New(), status.Inactive)
+		require.Equal(t, runtime.ErrNoStatusSink, err, "update status")
+		inactive := status.Inactive
+		_, err = user.Update(context.Background(), conn.Conn(), nil, uuid.New(), st.EncryptionKeys,
+			UserPatch{Status: &inactive})
+		require.Equal(t, runtime.ErrNoStatusSink, err, "update")
+		err = user.Shred(context.Background(), conn.Conn(), nil, *versionKey)
+		require.Equal(t, runtime.ErrNoStatusSink, err, "shred")
+		_, err = ReassignOrDelete{}.Apply(context.Background(), conn.Conn(), nil, *versionKey,
+			[]uuid.UUID{user.ID})
+		require.Equal(t, runtime.ErrNoStatusSink, err, "apply")
+		require.Equal(t, before, *user, "receiver unchanged")
+
+		readUser, err := Read(context.Background(), conn.Conn(), st.EncryptionKeys, user.ID)
 		require.NoError(t, err, "read")
-		require.Equal(t, *user, *readUser, "round trip")
+		require.Equal(t, status.Active, readUser.Status, "status unchanged")
 	})
 
 	t.Run("BadStatus", func(t *testing.T) {
@@ -513,20 +961,24 @@ func TestUpdateStatus(t *testing.T) {
 		)
 
 		status := user.Status
-		err = user.UpdateStatus(
+		actor := uuid.New()
+		_, err = user.UpdateStatus(
 			context.Background(),
 			conn.Conn(),
+			st.StatusSink,
+			actor,
 			99,
 		)
 
 		require.Error(t, err, "update status")
 		require.Equal(t, status, user.Status, "status unchanged")
 	})
-}
 
-func TestReEncrypt(t *testing.T) {
-	t.Run("OK", func(t *testing.T) {
+	t.Run("Cancel", func(t *testing.T) {
 		t.Parallel()
+		lockConn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer lockConn.Release()
 		conn, err := st.Master.Acquire(context.Background())
 		require.NoError(t, err, "master conn")
 		defer conn.Release()
@@ -536,14 +988,93 @@ func TestReEncrypt(t *testing.T) {
 
 		user := ForTest(
 			context.Background(),
-			conn.Conn(),
+			lockConn.Conn(),
 			*versionKey,
 			uuid.New(),
 			status.Active,
 		)
 
-		mtime := user.Mtime
-		signature := user.Signature
+		// Hold a row lock so the update blocks until its context expires.
+		tx, err := testsupport.LockRow(
+			context.Background(),
+			lockConn.Conn(),
+			"users",
+			user.ID,
+		)
+		require.NoError(t, err, "lock row")
+		defer tx.Rollback(context.Background()) // nolint:errcheck
+
+		testsupport.AssertCancels(t, 100*time.Millisecond,
+			func(ctx context.Context) error {
+				_, err := user.UpdateStatus(ctx, conn.Conn(), st.StatusSink, uuid.New(), status.Inactive)
+				return err
+			})
+	})
+}
+
+func TestRotateSignature(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+
+		mtime := user.Mtime
+		signature := user.Signature
+
+		err = user.RotateSignature(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+		)
+
+		require.NoError(t, err, "rotate signature")
+		require.True(t, mtime <= user.Mtime, "mtime")
+		require.NotEqual(t, signature, user.Signature, "signature")
+
+		testsupport.AssertRoundTrip(
+			t,
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+			user,
+			Read,
+		)
+	})
+}
+
+func TestReEncrypt(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+
+		mtime := user.Mtime
+		signature := user.Signature
 		require.Equal(t, status.Active, user.Status)
 
 		// Find a key in the version map that isn't the one currently used.
@@ -566,6 +1097,8 @@ func TestReEncrypt(t *testing.T) {
 		err = user.ReEncrypt(
 			context.Background(),
 			conn.Conn(),
+			uuid.New(),
+			st.EncryptionKeys,
 			*versionKey,
 		)
 
@@ -575,15 +1108,2173 @@ func TestReEncrypt(t *testing.T) {
 		require.True(t, mtime <= user.Mtime, "mtime")
 		require.NotEqual(t, signature, user.Signature, "signature")
 
-		readUser, err := Read(
+		testsupport.AssertRoundTrip(
+			t,
 			context.Background(),
 			conn.Conn(),
 			st.EncryptionKeys,
 			user.ID,
+			user,
+			Read,
 		)
+	})
 
-		require.NoError(t, err, "read")
-		require.Equal(t, *user, *readUser, "round trip")
+}
+
+func TestIntegrityScan(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		good := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			org,
+			status.Active,
+		)
+		bad := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			org,
+			status.Active,
+		)
+
+		// Corrupt the stored email digest so decryption verification fails.
+		_, err = conn.Exec(
+			context.Background(),
+			`update users set email_digest = $1 where id = $2`,
+			digest.SHA256Hex(uuid.NewString()),
+			bad.ID,
+		)
+		require.NoError(t, err, "corrupt email digest")
+
+		failures, err := IntegrityScan(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			org,
+		)
+
+		require.NoError(t, err, "integrity scan")
+		require.Len(t, failures, 1, "failures")
+		require.Equal(t, bad.ID, failures[0].ID, "failure id")
+		require.NotEqual(t, good.ID, failures[0].ID, "good id")
+		require.Equal(t, "email", failures[0].Column, "failure column")
+		require.ErrorIs(t, failures[0].Err, crypt.ErrDigest, "failure err")
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.RandomReplica().Acquire(context.Background())
+		require.NoError(t, err, "replica conn")
+		defer conn.Release()
+
+		failures, err := IntegrityScan(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			uuid.New(),
+		)
+
+		require.NoError(t, err, "integrity scan")
+		require.Empty(t, failures, "failures")
+	})
+}
+
+func TestListByOrg(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		var users []User
+		for range 3 {
+			user := ForTest(
+				context.Background(),
+				conn.Conn(),
+				*versionKey,
+				org,
+				status.Active,
+			)
+			users = append(users, *user)
+		}
+
+		page, err := ListByOrg(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			org,
+			0,
+			2,
+		)
+
+		require.NoError(t, err, "first page")
+		require.Equal(t, users[:2], page.Items, "first page items")
+		require.Equal(t, users[1].InsertOrder, page.Next, "first page next")
+		require.True(t, page.HasMore, "first page has more")
+
+		page, err = ListByOrg(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			org,
+			page.Next,
+			2,
+		)
+
+		require.NoError(t, err, "second page")
+		require.Equal(t, users[2:], page.Items, "second page items")
+		require.Equal(t, users[2].InsertOrder, page.Next, "second page next")
+		require.False(t, page.HasMore, "second page has more")
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.RandomReplica().Acquire(context.Background())
+		require.NoError(t, err, "replica conn")
+		defer conn.Release()
+
+		_, err = ListByOrg(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			uuid.New(),
+			0,
+			0,
+		)
+
+		require.Error(t, err, "zero limit")
+		require.Equal(t, model.ErrLimit, err, "limit err")
+
+		_, err = ListByOrg(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			uuid.New(),
+			-1,
+			1,
+		)
+		require.Equal(t, model.ErrCursor, err, "cursor err")
+	})
+
+	t.Run("MaxInsertOrder", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		n, err := MaxInsertOrder(context.Background(), conn.Conn(), org)
+		require.NoError(t, err, "empty org")
+		require.Equal(t, int64(0), n, "no users")
+
+		ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+		last := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+		n, err = MaxInsertOrder(context.Background(), conn.Conn(), org)
+		require.NoError(t, err, "max")
+		require.Equal(t, last.InsertOrder, n, "high-water mark")
+
+		// Nothing follows the high-water mark until another insert.
+		page, err := ListByOrg(context.Background(), conn.Conn(), st.EncryptionKeys, org, n, 10)
+		require.NoError(t, err, "after mark")
+		require.Empty(t, page.Items, "none after mark")
+		require.Equal(t, n, page.Next, "cursor kept")
+
+		next := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+		page, err = ListByOrg(context.Background(), conn.Conn(), st.EncryptionKeys, org, n, 10)
+		require.NoError(t, err, "after mark")
+		require.Len(t, page.Items, 1, "new user")
+		require.Equal(t, next.ID, page.Items[0].ID, "new user id")
+	})
+
+	t.Run("Traverse", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		var inserted []uuid.UUID
+		for range 30 {
+			u := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+			inserted = append(inserted, u.ID)
+		}
+		// A user in another org is never listed.
+		ForTest(context.Background(), conn.Conn(), *versionKey, uuid.New(), status.Active)
+
+		// 7 does not divide 30, so the last page is short.
+		var listed []uuid.UUID
+		var after int64
+		pages := 0
+		for {
+			page, err := ListByOrg(context.Background(), conn.Conn(), st.EncryptionKeys, org, after, 7)
+			require.NoError(t, err, "list")
+			pages++
+			for _, u := range page.Items {
+				require.Greater(t, u.InsertOrder, after, "ascending")
+				require.NotEmpty(t, u.Email, "decrypted")
+				listed = append(listed, u.ID)
+			}
+			after = page.Next
+			if !page.HasMore {
+				break
+			}
+		}
+		require.Equal(t, 5, pages, "pages")
+		require.Equal(t, inserted, listed, "no duplicates or gaps")
+	})
+}
+
+func TestValidateToken(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+
+		tokenStr, err := jwt.EncodeClaims(
+			user.ID,
+			user.Signature,
+			uuid.Nil, // org owner
+			st.Issuer,
+			st.SigningKey,
+		)
+		require.NoError(t, err, "encode claims")
+
+		tokenUser, err := ValidateToken(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			tokenStr,
+			st.Issuer,
+			st.SigningKey,
+		)
+
+		require.NoError(t, err, "validate token")
+		require.Equal(t, *user, *tokenUser, "token user")
+	})
+
+	t.Run("Revoked", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+
+		tokenStr, err := jwt.EncodeClaims(
+			user.ID,
+			user.Signature,
+			uuid.Nil, // org owner
+			st.Issuer,
+			st.SigningKey,
+		)
+		require.NoError(t, err, "encode claims")
+
+		err = user.RotateSignature(context.Background(), conn.Conn(), uuid.New())
+		require.NoError(t, err, "rotate signature")
+
+		_, err = ValidateToken(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			tokenStr,
+			st.Issuer,
+			st.SigningKey,
+		)
+
+		require.Error(t, err, "validate token")
+		require.Equal(t, ErrTokenRevoked, err, "revoked")
 	})
 
+	t.Run("RevokedByUpdate", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+
+		tokenStr, err := jwt.EncodeClaims(
+			user.ID,
+			user.Signature,
+			uuid.Nil, // org owner
+			st.Issuer,
+			st.SigningKey,
+		)
+		require.NoError(t, err, "encode claims")
+
+		// Any update rotates the signature, so a profile edit revokes
+		// the token too.
+		_, err = user.UpdateDisplayName(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			st.EncryptionKeys,
+			uuid.NewString(),
+		)
+		require.NoError(t, err, "update display name")
+
+		_, err = ValidateToken(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			tokenStr,
+			st.Issuer,
+			st.SigningKey,
+		)
+		require.Equal(t, ErrTokenRevoked, err, "revoked")
+	})
+
+	t.Run("NoSignature", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+
+		tokenStr, err := jwt.Encode(user.ID, st.Issuer, st.SigningKey)
+		require.NoError(t, err, "encode")
+
+		_, err = ValidateToken(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			tokenStr,
+			st.Issuer,
+			st.SigningKey,
+		)
+		require.ErrorIs(t, err, ErrTokenRevoked, "no sig")
+	})
+}
+
+func TestListAdmins(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		insertAdmin := func(userStatus int) *User {
+			ed25519PublicPEM, _, err := ed25519.Random()
+			require.NoError(t, err, "generate ed25519")
+			user, err := Insert(
+				context.Background(),
+				conn.Conn(),
+				st.RateLimiter,
+				*versionKey,
+				uuid.NewString(), // display name
+				ed25519PublicPEM,
+				uuid.NewString(), // email
+				org,
+				password.Random(), // password
+				role.Admin,
+				SchemaVersion,
+				userStatus,
+			)
+			require.NoError(t, err, "insert admin")
+			return user
+		}
+
+		admin := insertAdmin(status.Active)
+		_ = insertAdmin(status.Inactive)
+		_ = ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			org,
+			status.Active,
+		)
+
+		admins, err := ListAdmins(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			org,
+		)
+
+		require.NoError(t, err, "list admins")
+		require.Equal(t, []User{*admin}, admins, "admins")
+	})
+}
+
+func TestShred(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+
+		id := user.ID
+		signature := user.Signature
+		email := user.Email
+		emailDigest := user.EmailDigest
+		displayName := user.DisplayName
+		displayNameDigest := user.DisplayNameDigest
+
+		err = user.Shred(context.Background(), conn.Conn(), st.StatusSink, *versionKey)
+
+		require.NoError(t, err, "shred")
+		require.Equal(t, id, user.ID, "id kept")
+		require.Equal(t, status.Deleted, user.Status, "status")
+		require.NotEqual(t, signature, user.Signature, "signature")
+		require.NotEqual(t, emailDigest, user.EmailDigest, "email digest")
+		require.NotEqual(t, email, user.Email, "email")
+		require.NotEqual(t, displayName, user.DisplayName, "display name")
+		require.Equal(t, shreddedPassword, user.Password, "password")
+
+		// The tombstone still decrypts, to the placeholders.
+		readUser, err := Read(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+		)
+		require.NoError(t, err, "read")
+		require.Equal(t, *user, *readUser, "read tombstone")
+
+		// Old digests are not left in the audit log.
+		var count int
+		err = conn.QueryRow(
+			context.Background(),
+			`select count(*) from audit_log
+			where audit_id = $1 and details::text like '%' || $2 || '%'`,
+			user.ID,
+			displayNameDigest,
+		).Scan(&count)
+		require.NoError(t, err, "audit log")
+		require.Equal(t, 0, count, "audit log digests")
+	})
+
+	t.Run("Password", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+		oldPassword := user.Password
+		newPassword, err := password.Encode(password.Random(), argon2.DefaultConfig())
+		require.NoError(t, err, "encode")
+		_, err = user.UpdatePassword(context.Background(), conn.Conn(), uuid.New(), newPassword)
+		require.NoError(t, err, "update password")
+
+		err = user.Shred(context.Background(), conn.Conn(), st.StatusSink, *versionKey)
+		require.NoError(t, err, "shred")
+
+		// Neither hash is left in the row or the audit log.
+		for _, hash := range []string{oldPassword, newPassword} {
+			var count int
+			err = conn.QueryRow(
+				context.Background(),
+				`select count(*) from audit_log
+				where audit_id = $1 and details::text like '%' || $2 || '%'`,
+				user.ID,
+				hash,
+			).Scan(&count)
+			require.NoError(t, err, "audit log")
+			require.Equal(t, 0, count, "audit log hashes")
+		}
+		readUser, err := Read(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+		)
+		require.NoError(t, err, "read")
+		require.Equal(t, shreddedPassword, readUser.Password, "password")
+	})
+
+	t.Run("ListByOrg", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		member := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+		shredded := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+		err = shredded.Shred(context.Background(), conn.Conn(), st.StatusSink, *versionKey)
+		require.NoError(t, err, "shred")
+
+		page, err := ListByOrg(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			org,
+			0,
+			10,
+		)
+		require.NoError(t, err, "list by org")
+		require.Len(t, page.Items, 2, "members")
+		require.Equal(t, *member, page.Items[0], "member")
+		require.Equal(t, *shredded, page.Items[1], "shredded")
+
+		failures, err := IntegrityScan(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			org,
+		)
+		require.NoError(t, err, "integrity scan")
+		require.Empty(t, failures, "no failures")
+	})
+}
+
+func TestDelete(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+		_, err = user.UpdateStatus(context.Background(), conn.Conn(), st.StatusSink, uuid.New(), status.Inactive)
+		require.NoError(t, err, "update status")
+
+		err = Delete(context.Background(), conn.Conn(), user.ID)
+		require.NoError(t, err, "delete")
+
+		_, err = Read(context.Background(), conn.Conn(), st.EncryptionKeys, user.ID)
+		require.ErrorIs(t, err, pgx.ErrNoRows, "read deleted")
+
+		var history int
+		err = conn.QueryRow(
+			context.Background(),
+			`select count(*) from users_history where id = $1`,
+			user.ID,
+		).Scan(&history)
+		require.NoError(t, err, "count history")
+		require.Zero(t, history, "history deleted")
+
+		err = Delete(context.Background(), conn.Conn(), user.ID)
+		require.ErrorIs(t, err, pgx.ErrNoRows, "delete twice")
+	})
+
+	t.Run("Owner", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		owner := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			org,
+			status.Active,
+		)
+		_, err = conn.Exec(
+			context.Background(),
+			`insert into orgs (id, name, owner, role, status)
+			values ($1, $2, $3, $4, $5)`,
+			org,
+			uuid.NewString(),
+			owner.ID,
+			role.OrgTest,
+			status.Active,
+		)
+		require.NoError(t, err, "insert org")
+
+		err = Delete(context.Background(), conn.Conn(), owner.ID)
+		require.ErrorIs(t, err, ErrOwnerDelete, "owner")
+
+		_, err = Read(context.Background(), conn.Conn(), st.EncryptionKeys, owner.ID)
+		require.NoError(t, err, "owner kept")
+	})
+}
+
+func TestVerifyWithHistory(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		ed25519PublicPEM, ed25519PrivatePEM, err := ed25519.Random()
+		require.NoError(t, err, "generate ed25519")
+		privateKey, err := ed25519.ImportPrivatePEM(ed25519PrivatePEM)
+		require.NoError(t, err, "private pem")
+
+		user, err := Insert(
+			context.Background(),
+			conn.Conn(),
+			st.RateLimiter,
+			*versionKey,
+			uuid.NewString(), // display name
+			ed25519PublicPEM,
+			uuid.NewString(), // email
+			uuid.New(),       // org
+			password.Random(),
+			role.Test,
+			SchemaVersion,
+			status.Active,
+		)
+		require.NoError(t, err, "insert")
+
+		msg := []byte(uuid.NewString())
+		sig := crypto_ed25519.Sign(privateKey, msg)
+
+		ok, err := VerifyWithHistory(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+			msg,
+			sig,
+		)
+		require.NoError(t, err, "verify current")
+		require.True(t, ok, "current key")
+
+		// Rotate twice; the original key is still in history.
+		for range 2 {
+			newEd25519PublicPEM, _, err := ed25519.Random()
+			require.NoError(t, err, "generate ed25519")
+			err = user.NewEd25519(
+				context.Background(),
+				conn.Conn(),
+				uuid.New(),
+				st.EncryptionKeys,
+				newEd25519PublicPEM,
+			)
+			require.NoError(t, err, "new ed25519")
+		}
+
+		ok, err = VerifyWithHistory(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+			msg,
+			sig,
+		)
+		require.NoError(t, err, "verify history")
+		require.True(t, ok, "historical key")
+
+		ok, err = VerifyWithHistory(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+			[]byte(uuid.NewString()),
+			sig,
+		)
+		require.NoError(t, err, "verify other msg")
+		require.False(t, ok, "no key")
+	})
+
+	// rotated returns a user whose original key, which signed `msg` with
+	// the returned signature, is the oldest of two archived keys.
+	rotated := func(t *testing.T, conn *pgx.Conn) (*User, []byte, []byte) {
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		ed25519PublicPEM, ed25519PrivatePEM, err := ed25519.Random()
+		require.NoError(t, err, "generate ed25519")
+		privateKey, err := ed25519.ImportPrivatePEM(ed25519PrivatePEM)
+		require.NoError(t, err, "private pem")
+
+		user, err := Insert(
+			context.Background(),
+			conn,
+			st.RateLimiter,
+			*versionKey,
+			uuid.NewString(), // display name
+			ed25519PublicPEM,
+			uuid.NewString(), // email
+			uuid.New(),       // org
+			password.Random(),
+			role.Test,
+			SchemaVersion,
+			status.Active,
+		)
+		require.NoError(t, err, "insert")
+
+		for range 2 {
+			newEd25519PublicPEM, _, err := ed25519.Random()
+			require.NoError(t, err, "generate ed25519")
+			err = user.NewEd25519(
+				context.Background(),
+				conn,
+				uuid.New(),
+				st.EncryptionKeys,
+				newEd25519PublicPEM,
+			)
+			require.NoError(t, err, "new ed25519")
+		}
+
+		msg := []byte(uuid.NewString())
+		return user, msg, crypto_ed25519.Sign(privateKey, msg)
+	}
+
+	t.Run("SkipUnusable", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		user, msg, sig := rotated(t, conn.Conn())
+
+		// Corrupt the newer archived key; the older one is still tried.
+		_, err = conn.Exec(
+			context.Background(),
+			`update ed25519_public_history set ed25519_public = 'corrupt'
+			where insert_order = (select max(insert_order)
+				from ed25519_public_history where user_id = $1)`,
+			user.ID,
+		)
+		require.NoError(t, err, "corrupt")
+
+		ok, err := VerifyWithHistory(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+			msg,
+			sig,
+		)
+		require.NoError(t, err, "verify history")
+		require.True(t, ok, "older key")
+
+		ok, err = VerifyWithHistory(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+			[]byte(uuid.NewString()),
+			sig,
+		)
+		require.NoError(t, err, "verify other msg")
+		require.False(t, ok, "no key")
+	})
+
+	t.Run("ReEncrypt", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		user, msg, sig := rotated(t, conn.Conn())
+
+		var newKey *key.Versioned
+		for _, version := range st.EncryptionKeys.SortedVersions() {
+			if version != user.KeyVersion {
+				newKey, err = st.EncryptionKeys.Get(version)
+				require.NoError(t, err, "new key")
+				break
+			}
+		}
+		require.NotNil(t, newKey, "new key")
+
+		err = user.ReEncrypt(context.Background(), conn.Conn(), uuid.New(), st.EncryptionKeys, *newKey)
+		require.NoError(t, err, "re-encrypt")
+
+		// Archived keys are readable once the old key is retired.
+		ok, err := VerifyWithHistory(
+			context.Background(),
+			conn.Conn(),
+			key.NewMemoryProvider(*newKey),
+			user.ID,
+			msg,
+			sig,
+		)
+		require.NoError(t, err, "verify history")
+		require.True(t, ok, "historical key")
+	})
+}
+
+func TestReadReplica(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+
+		readUser, err := ReadReplica(context.Background(), st, user.ID)
+		require.NoError(t, err, "read replica")
+		require.Equal(t, *user, *readUser, "round trip")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		t.Parallel()
+		_, err := ReadReplica(context.Background(), st, uuid.New())
+		require.Error(t, err, "read replica")
+		require.Equal(t, ErrNotFound, err, "not found")
+	})
+}
+
+func BenchmarkInsert(b *testing.B) {
+	conn, err := st.Master.Acquire(context.Background())
+	require.NoError(b, err, "master conn")
+	defer conn.Release()
+
+	versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+	require.NoError(b, err, "versionKey")
+	ed25519PublicPEM, _, err := ed25519.Random()
+	require.NoError(b, err, "generate ed25519")
+
+	insert := func() *User {
+		// ed25519 public is unique per org, so each user needs a new org.
+		user, err := Insert(
+			context.Background(),
+			conn.Conn(),
+			st.RateLimiter,
+			*versionKey,
+			uuid.NewString(), // display name
+			ed25519PublicPEM,
+			uuid.NewString(), // email
+			uuid.New(),       // org
+			password.Random(),
+			role.Test,
+			SchemaVersion,
+			status.Active,
+		)
+		require.NoError(b, err, "insert")
+		return user
+	}
+
+	b.Run("Insert", func(b *testing.B) {
+		for b.Loop() {
+			insert()
+		}
+	})
+
+	// The cost of Insert before it stopped reading the row back.
+	b.Run("InsertRead", func(b *testing.B) {
+		for b.Loop() {
+			user := insert()
+			_, err := Read(
+				context.Background(),
+				conn.Conn(),
+				st.EncryptionKeys,
+				user.ID,
+			)
+			require.NoError(b, err, "read")
+		}
+	})
+}
+
+func TestVerifyAndMaybeRehash(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+
+		// Store a hash made with outdated parameters.
+		guess := uuid.NewString()
+		cfg := argon2.DefaultConfig()
+		oldCfg := cfg
+		oldCfg.TimeCost++
+		oldPassword, err := password.Encode(guess, oldCfg)
+		require.NoError(t, err, "encode")
+		_, err = user.UpdatePassword(context.Background(), conn.Conn(), user.ID, oldPassword)
+		require.NoError(t, err, "update password")
+
+		ok, err := user.VerifyAndMaybeRehash(
+			context.Background(),
+			conn.Conn(),
+			st.PasswordLimiter,
+			"wrong",
+			cfg,
+		)
+		require.NoError(t, err, "verify wrong")
+		require.False(t, ok, "wrong guess")
+		require.Equal(t, oldPassword, user.Password, "not rehashed")
+
+		ok, err = user.VerifyAndMaybeRehash(
+			context.Background(),
+			conn.Conn(),
+			st.PasswordLimiter,
+			guess,
+			cfg,
+		)
+		require.NoError(t, err, "verify")
+		require.True(t, ok, "guess")
+		require.NotEqual(t, oldPassword, user.Password, "rehashed")
+		needsRehash, err := password.NeedsRehash(user.Password, cfg)
+		require.NoError(t, err, "needs rehash")
+		require.False(t, needsRehash, "current config")
+
+		readUser, err := Read(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+		)
+		require.NoError(t, err, "read")
+		require.Equal(t, *user, *readUser, "round trip")
+	})
+}
+
+func TestReadQuorum(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+
+		// One replica reads from the master.
+		readUser, err := ReadQuorum(context.Background(), st, st.EncryptionKeys, user.ID)
+		require.NoError(t, err, "read quorum")
+		require.Equal(t, *user, *readUser, "round trip")
+
+		// Two replicas that agree.
+		quorumSt := *st
+		quorumSt.Replicas = []*pgxpool.Pool{st.Master, st.Master}
+		readUser, err = ReadQuorum(context.Background(), &quorumSt, st.EncryptionKeys, user.ID)
+		require.NoError(t, err, "read quorum")
+		require.Equal(t, *user, *readUser, "round trip")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		t.Parallel()
+		quorumSt := *st
+		quorumSt.Replicas = []*pgxpool.Pool{st.Master, st.Master}
+		_, err := ReadQuorum(context.Background(), &quorumSt, st.EncryptionKeys, uuid.New())
+		require.Error(t, err, "read quorum")
+		require.Equal(t, ErrNotFound, err, "not found")
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+		signature := user.Signature
+
+		displayName := RandomHandle()
+		email := uuid.NewString() + "@example.com"
+		inactive := status.Inactive
+		actor := uuid.New()
+		result, err := user.Update(
+			context.Background(),
+			conn.Conn(),
+			st.StatusSink,
+			actor,
+			st.EncryptionKeys,
+			UserPatch{
+				DisplayName: &displayName,
+				Email:       &email,
+				Status:      &inactive,
+			},
+		)
+
+		require.NoError(t, err, "update")
+		require.Equal(t, model.MutationResult{
+			Modified:  true,
+			Mtime:     user.Mtime,
+			Signature: user.Signature,
+		}, result, "result")
+		require.Equal(t, displayName, user.DisplayName, "display name")
+		require.Equal(t, digest.SHA256Hex(displayName), user.DisplayNameDigest, "display name digest")
+		require.Equal(t, email, user.Email, "email")
+		require.Equal(t, digest.SHA256Hex(email), user.EmailDigest, "email digest")
+		require.Equal(t, status.Inactive, user.Status, "status")
+		require.Equal(t, &actor, user.UpdatedBy, "updated by")
+		require.NotEqual(t, signature, user.Signature, "signature")
+
+		readUser, err := Read(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+		)
+		require.NoError(t, err, "read")
+		require.Equal(t, *user, *readUser, "round trip")
+
+		// An empty patch does nothing.
+		signature = user.Signature
+		result, err = user.Update(
+			context.Background(),
+			conn.Conn(),
+			st.StatusSink,
+			actor,
+			st.EncryptionKeys,
+			UserPatch{},
+		)
+		require.NoError(t, err, "empty update")
+		require.False(t, result.Modified, "not modified")
+		require.Equal(t, signature, result.Signature, "result signature")
+		require.Equal(t, signature, user.Signature, "unchanged")
+	})
+
+	t.Run("Taken", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		user := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+		other := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+
+		email := uuid.NewString() + "@example.com"
+		_, err = other.Update(
+			context.Background(),
+			conn.Conn(),
+			st.StatusSink,
+			uuid.New(),
+			st.EncryptionKeys,
+			UserPatch{Email: &email},
+		)
+		require.NoError(t, err, "update other")
+
+		_, err = user.Update(
+			context.Background(),
+			conn.Conn(),
+			st.StatusSink,
+			uuid.New(),
+			st.EncryptionKeys,
+			UserPatch{Email: &email},
+		)
+		require.True(t, postgresql.UniqueConstraint(err), "err")
+		require.ErrorIs(t, err, ErrEmailTaken, "email taken")
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		t.Parallel()
+		empty := ""
+		require.Equal(t, ErrDisplayName, UserPatch{DisplayName: &empty}.validate(), "display name")
+
+		for _, email := range []string{"", "not an email", "Name <user@example.com>"} {
+			require.Equal(t, ErrEmail, UserPatch{Email: &email}.validate(), "email %q", email)
+		}
+
+		for _, s := range []int{0, status.Deleted, 99} {
+			require.Equal(t, ErrStatus, UserPatch{Status: &s}.validate(), "status %d", s)
+		}
+
+		email := "user@example.com"
+		active := status.Active
+		require.NoError(t, UserPatch{Email: &email, Status: &active}.validate(), "valid")
+	})
+}
+
+func TestUpdateRoleAuthorized(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		insertAdmin := func(org uuid.UUID) *User {
+			ed25519PublicPEM, _, err := ed25519.Random()
+			require.NoError(t, err, "generate ed25519")
+			admin, err := Insert(
+				context.Background(),
+				conn.Conn(),
+				st.RateLimiter,
+				*versionKey,
+				uuid.NewString(), // display name
+				ed25519PublicPEM,
+				uuid.NewString(), // email
+				org,
+				password.Random(),
+				role.Admin,
+				SchemaVersion,
+				status.Active,
+			)
+			require.NoError(t, err, "insert admin")
+			return admin
+		}
+
+		org := uuid.New()
+		admin := insertAdmin(org)
+		target := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			org,
+			status.Active,
+		)
+
+		err = UpdateRoleAuthorized(
+			context.Background(),
+			conn.Conn(),
+			admin,
+			target,
+			role.Normal,
+		)
+		require.NoError(t, err, "update role")
+		require.Equal(t, role.Normal, target.Role, "role")
+		require.Equal(t, &admin.ID, target.UpdatedBy, "updated by")
+
+		readTarget, err := Read(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			target.ID,
+		)
+		require.NoError(t, err, "read")
+		require.Equal(t, *target, *readTarget, "round trip")
+
+		// A non-admin in the org cannot change roles.
+		err = UpdateRoleAuthorized(
+			context.Background(),
+			conn.Conn(),
+			target,
+			admin,
+			role.Normal,
+		)
+		require.Equal(t, ErrUnauthorized, err, "not admin")
+
+		// Nor can an admin of another org.
+		err = UpdateRoleAuthorized(
+			context.Background(),
+			conn.Conn(),
+			insertAdmin(uuid.New()),
+			target,
+			role.Admin,
+		)
+		require.Equal(t, ErrUnauthorized, err, "other org")
+
+		err = UpdateRoleAuthorized(
+			context.Background(),
+			conn.Conn(),
+			admin,
+			target,
+			99,
+		)
+		require.Equal(t, ErrRole, err, "role err")
+	})
+
+	t.Run("Owner", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		var admins []*User
+		for range 2 {
+			ed25519PublicPEM, _, err := ed25519.Random()
+			require.NoError(t, err, "generate ed25519")
+			admin, err := Insert(
+				context.Background(),
+				conn.Conn(),
+				st.RateLimiter,
+				*versionKey,
+				uuid.NewString(), // display name
+				ed25519PublicPEM,
+				uuid.NewString(), // email
+				org,
+				password.Random(),
+				role.Admin,
+				SchemaVersion,
+				status.Active,
+			)
+			require.NoError(t, err, "insert admin")
+			admins = append(admins, admin)
+		}
+		owner, other := admins[0], admins[1]
+
+		_, err = conn.Exec(
+			context.Background(),
+			`insert into orgs (id, name, owner, role, status)
+			values ($1, $2, $3, $4, $5)`,
+			org,
+			uuid.NewString(),
+			owner.ID,
+			role.OrgTenant,
+			status.Active,
+		)
+		require.NoError(t, err, "insert org")
+
+		err = UpdateRoleAuthorized(
+			context.Background(),
+			conn.Conn(),
+			other,
+			owner,
+			role.Normal,
+		)
+		require.Equal(t, ErrOwnerMustBeAdmin, err, "demote owner")
+		require.Equal(t, role.Admin, owner.Role, "role unchanged")
+
+		readOwner, err := Read(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			owner.ID,
+		)
+		require.NoError(t, err, "read")
+		require.Equal(t, role.Admin, readOwner.Role, "role not written")
+
+		// Other admins may still be demoted.
+		err = UpdateRoleAuthorized(
+			context.Background(),
+			conn.Conn(),
+			owner,
+			other,
+			role.Normal,
+		)
+		require.NoError(t, err, "demote other")
+		require.Equal(t, role.Normal, other.Role, "other role")
+	})
+}
+
+func TestSetRoleForUsers(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		var ids []uuid.UUID
+		for range 3 {
+			u := ForTest(
+				context.Background(),
+				conn.Conn(),
+				*versionKey,
+				org,
+				status.Active,
+			)
+			ids = append(ids, u.ID)
+		}
+
+		// A user in another org is not affected.
+		other := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+		ids = append(ids, other.ID, uuid.New())
+
+		n, err := SetRoleForUsers(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			org,
+			ids,
+			role.Normal,
+		)
+		require.NoError(t, err, "set role")
+		require.Equal(t, int64(3), n, "affected")
+
+		for _, id := range ids[:3] {
+			u, err := Read(
+				context.Background(),
+				conn.Conn(),
+				st.EncryptionKeys,
+				id,
+			)
+			require.NoError(t, err, "read")
+			require.Equal(t, role.Normal, u.Role, "role")
+		}
+
+		readOther, err := Read(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			other.ID,
+		)
+		require.NoError(t, err, "read other")
+		require.Equal(t, other.Role, readOther.Role, "other role")
+
+		_, err = SetRoleForUsers(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			org,
+			ids,
+			99,
+		)
+		require.Equal(t, ErrRole, err, "role err")
+	})
+
+	t.Run("Owner", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		owner := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+		member := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+		_, err = conn.Exec(
+			context.Background(),
+			`insert into orgs (id, name, owner, role, status)
+			values ($1, $2, $3, $4, $5)`,
+			org,
+			uuid.NewString(),
+			owner.ID,
+			role.OrgTenant,
+			status.Active,
+		)
+		require.NoError(t, err, "insert org")
+		ids := []uuid.UUID{owner.ID, member.ID}
+
+		_, err = SetRoleForUsers(context.Background(), conn.Conn(), uuid.New(), org, ids, role.Normal)
+		require.Equal(t, ErrOwnerMustBeAdmin, err, "demote owner")
+
+		readMember, err := Read(context.Background(), conn.Conn(), st.EncryptionKeys, member.ID)
+		require.NoError(t, err, "read member")
+		require.Equal(t, member.Role, readMember.Role, "member unchanged")
+
+		n, err := SetRoleForUsers(context.Background(), conn.Conn(), uuid.New(), org, ids, role.Admin)
+		require.NoError(t, err, "owner role")
+		require.Equal(t, int64(2), n, "affected")
+
+		// Without the owner, any role may be set.
+		n, err = SetRoleForUsers(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			org,
+			[]uuid.UUID{member.ID},
+			role.Normal,
+		)
+		require.NoError(t, err, "member only")
+		require.Equal(t, int64(1), n, "affected")
+	})
+}
+
+func TestEd25519Private(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+		_, err = user.Ed25519Private(st.EncryptionKeys)
+		require.Equal(t, ErrNoEd25519Private, err, "no key")
+
+		ed25519PublicPEM, ed25519PrivatePEM, err := ed25519.Random()
+		require.NoError(t, err, "generate ed25519")
+
+		err = user.SetEd25519Private(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			*versionKey,
+			ed25519PublicPEM,
+		)
+		require.Equal(t, ErrEd25519Private, err, "public key")
+
+		err = user.SetEd25519Private(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			key.Versioned{Version: uuid.New(), Key: key.Random()},
+			ed25519PrivatePEM,
+		)
+		require.Equal(t, ErrKeyVersion, err, "key version")
+
+		err = user.SetEd25519Private(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			*versionKey,
+			ed25519PrivatePEM,
+		)
+		require.NoError(t, err, "set")
+
+		// Read leaves the private key encrypted.
+		readUser, err := Read(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+		)
+		require.NoError(t, err, "read")
+		require.NotNil(t, readUser.Ed25519PrivateEncrypted, "encrypted")
+		require.NotEqual(t, ed25519PrivatePEM, *readUser.Ed25519PrivateEncrypted, "not plaintext")
+		require.Equal(t, user.Ed25519PrivateEncrypted, readUser.Ed25519PrivateEncrypted, "ciphertext")
+
+		// Re-encryption needs the decrypted key.
+		var newKeyVersion uuid.UUID
+		for keyVersion := range st.EncryptionKeys {
+			if keyVersion != readUser.KeyVersion {
+				newKeyVersion = keyVersion
+				break
+			}
+		}
+		newVersionKey, err := st.EncryptionKeys.Get(newKeyVersion)
+		require.NoError(t, err, "newVersionKey")
+		err = readUser.ReEncrypt(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			st.EncryptionKeys,
+			*newVersionKey,
+		)
+		require.Equal(t, ErrEd25519PrivateLocked, err, "locked")
+
+		decrypted, err := readUser.Ed25519Private(st.EncryptionKeys)
+		require.NoError(t, err, "decrypt")
+		require.Equal(t, ed25519PrivatePEM, decrypted, "round trip")
+
+		err = readUser.ReEncrypt(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			st.EncryptionKeys,
+			*newVersionKey,
+		)
+		require.NoError(t, err, "re-encrypt")
+
+		readUser, err = Read(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+		)
+		require.NoError(t, err, "read")
+		require.Equal(t, newKeyVersion, readUser.KeyVersion, "key version")
+		decrypted, err = readUser.Ed25519Private(st.EncryptionKeys)
+		require.NoError(t, err, "decrypt")
+		require.Equal(t, ed25519PrivatePEM, decrypted, "re-encrypted")
+
+		err = readUser.Shred(context.Background(), conn.Conn(), st.StatusSink, *versionKey)
+		require.NoError(t, err, "shred")
+		require.Nil(t, readUser.Ed25519PrivateEncrypted, "shredded")
+		_, err = readUser.Ed25519Private(st.EncryptionKeys)
+		require.Equal(t, ErrNoEd25519Private, err, "shredded key")
+	})
+}
+
+func TestDisplayNameDigestCount(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		a := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+		b := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+
+		n, err := DisplayNameDigestCount(context.Background(), conn.Conn(), org, a.DisplayNameDigest)
+		require.NoError(t, err, "count")
+		require.Equal(t, int64(1), n, "unique")
+
+		_, err = b.UpdateDisplayName(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			st.EncryptionKeys,
+			a.DisplayName,
+		)
+		require.NoError(t, err, "update display name")
+
+		n, err = DisplayNameDigestCount(context.Background(), conn.Conn(), org, a.DisplayNameDigest)
+		require.NoError(t, err, "count")
+		require.Equal(t, int64(2), n, "duplicate")
+
+		n, err = DisplayNameDigestCount(context.Background(), conn.Conn(), uuid.New(), a.DisplayNameDigest)
+		require.NoError(t, err, "count")
+		require.Equal(t, int64(0), n, "other org")
+	})
+}
+
+func TestOrphans(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		// ForTest does not create the org, so this user is an orphan.
+		orphan := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+
+		ids, err := Orphans(context.Background(), conn.Conn(), 1)
+		require.NoError(t, err, "orphans")
+		require.Len(t, ids, 1, "limit")
+
+		// An org for the orphan to be reassigned to.
+		var org uuid.UUID
+		err = conn.QueryRow(
+			context.Background(),
+			`insert into orgs (name, owner, role, status)
+			values ($1, $2, $3, $4) returning id`,
+			uuid.NewString(),
+			uuid.New(),
+			role.Test,
+			status.Active,
+		).Scan(&org)
+		require.NoError(t, err, "insert org")
+
+		_, err = ReassignOrDelete{Org: uuid.New()}.Apply(
+			context.Background(),
+			conn.Conn(),
+			st.StatusSink,
+			*versionKey,
+			[]uuid.UUID{orphan.ID},
+		)
+		require.Equal(t, ErrOrgNotFound, err, "missing org")
+
+		n, err := ReassignOrDelete{Org: org}.Apply(
+			context.Background(),
+			conn.Conn(),
+			st.StatusSink,
+			*versionKey,
+			[]uuid.UUID{orphan.ID},
+		)
+		require.NoError(t, err, "reassign")
+		require.Equal(t, int64(1), n, "reassigned")
+
+		readUser, err := Read(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			orphan.ID,
+		)
+		require.NoError(t, err, "read")
+		require.Equal(t, org, readUser.Org, "org")
+
+		// No longer an orphan, so a delete policy leaves it alone.
+		n, err = ReassignOrDelete{}.Apply(
+			context.Background(),
+			conn.Conn(),
+			st.StatusSink,
+			*versionKey,
+			[]uuid.UUID{orphan.ID},
+		)
+		require.NoError(t, err, "delete")
+		require.Zero(t, n, "not orphan")
+
+		orphan = ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+		n, err = ReassignOrDelete{}.Apply(
+			context.Background(),
+			conn.Conn(),
+			st.StatusSink,
+			*versionKey,
+			[]uuid.UUID{orphan.ID},
+		)
+		require.NoError(t, err, "delete")
+		require.Equal(t, int64(1), n, "deleted")
+
+		// The orphan is shredded, not only marked deleted.
+		var userStatus int
+		var emailDigest string
+		err = conn.QueryRow(
+			context.Background(),
+			`select status, email_digest from users where id = $1`,
+			orphan.ID,
+		).Scan(&userStatus, &emailDigest)
+		require.NoError(t, err, "select")
+		require.Equal(t, status.Deleted, userStatus, "status")
+		require.NotEqual(t, orphan.EmailDigest, emailDigest, "email digest")
+		_, err = Read(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			orphan.ID,
+		)
+		require.Error(t, err, "read")
+
+		// A shredded orphan is not shredded again.
+		n, err = ReassignOrDelete{}.Apply(
+			context.Background(),
+			conn.Conn(),
+			st.StatusSink,
+			*versionKey,
+			[]uuid.UUID{orphan.ID},
+		)
+		require.NoError(t, err, "delete again")
+		require.Zero(t, n, "already shredded")
+	})
+}
+
+func TestReadByEmail(t *testing.T) {
+	t.Run("Normalize", func(t *testing.T) {
+		t.Parallel()
+		require.Equal(t, "user@example.com", NormalizeEmail(" User@Example.COM\n"), "normalize")
+	})
+
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		ed25519PublicPEM, _, err := ed25519.Random()
+		require.NoError(t, err, "generate ed25519")
+
+		local := uuid.NewString()
+		org := uuid.New()
+		u, err := Insert(
+			context.Background(),
+			conn.Conn(),
+			st.RateLimiter,
+			*versionKey,
+			uuid.NewString(),
+			ed25519PublicPEM,
+			" "+strings.ToUpper(local)+"@Example.com",
+			org,
+			password.Random(),
+			role.Test,
+			SchemaVersion,
+			status.Active,
+		)
+		require.NoError(t, err, "insert")
+		require.Equal(t, local+"@example.com", u.Email, "normalized")
+
+		// The normalized form is what is stored.
+		readUser, err := Read(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			u.ID,
+		)
+		require.NoError(t, err, "read")
+		require.Equal(t, local+"@example.com", readUser.Email, "stored normalized")
+
+		readUser, err = ReadByEmail(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			org,
+			local+"@EXAMPLE.com",
+		)
+		require.NoError(t, err, "read by email")
+		require.Equal(t, u.ID, readUser.ID, "id")
+		require.Equal(t, u.Email, readUser.Email, "email")
+
+		_, err = ReadByEmail(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			uuid.New(),
+			u.Email,
+		)
+		require.Equal(t, ErrNotFound, err, "other org")
+	})
+}
+
+func TestNormalizeEmails(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		// legacy stores `email` as it was before emails were normalized.
+		legacy := func(u *User, email string) {
+			encrypted, err := crypt.Encrypt(
+				email,
+				key.DeriveField(versionKey.Key, emailLabel),
+			)
+			require.NoError(t, err, "encrypt")
+			_, err = conn.Exec(
+				context.Background(),
+				`update users set email = $1, email_digest = $2 where id = $3`,
+				encrypted,
+				digest.SHA256Hex(email),
+				u.ID,
+			)
+			require.NoError(t, err, "legacy email")
+		}
+
+		org := uuid.New()
+		local := uuid.NewString()
+		mixed := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+		legacy(mixed, strings.ToUpper(local)+"@Example.com")
+
+		// Normalizes to the primary email of `taken`.
+		takenEmail := uuid.NewString() + "@example.com"
+		taken := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+		err = taken.UpdateEmail(context.Background(), conn.Conn(), uuid.New(), st.EncryptionKeys, takenEmail)
+		require.NoError(t, err, "update email")
+		conflict := ForTest(context.Background(), conn.Conn(), *versionKey, org, status.Active)
+		legacy(conflict, strings.ToUpper(takenEmail))
+
+		_, err = ReadByEmail(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			org,
+			local+"@example.com",
+		)
+		require.Equal(t, ErrNotFound, err, "legacy not found")
+
+		actor := uuid.New()
+		changed, skipped, err := NormalizeEmails(
+			context.Background(),
+			conn.Conn(),
+			actor,
+			st.EncryptionKeys,
+			org,
+		)
+		require.NoError(t, err, "normalize emails")
+		require.Equal(t, 1, changed, "changed")
+		require.Equal(t, []uuid.UUID{conflict.ID}, skipped, "skipped")
+
+		readUser, err := ReadByEmail(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			org,
+			strings.ToUpper(local)+"@Example.com",
+		)
+		require.NoError(t, err, "read by email")
+		require.Equal(t, mixed.ID, readUser.ID, "id")
+		require.Equal(t, local+"@example.com", readUser.Email, "normalized")
+		require.Equal(t, &actor, readUser.UpdatedBy, "updated by")
+
+		// A second run changes nothing.
+		changed, skipped, err = NormalizeEmails(
+			context.Background(),
+			conn.Conn(),
+			actor,
+			st.EncryptionKeys,
+			org,
+		)
+		require.NoError(t, err, "normalize emails again")
+		require.Zero(t, changed, "nothing changed")
+		require.Equal(t, []uuid.UUID{conflict.ID}, skipped, "still skipped")
+	})
+}
+
+func TestFindByEmailDigest(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		// The same email in two orgs.
+		email := uuid.NewString() + "@example.com"
+		orgA, orgB := uuid.New(), uuid.New()
+		userA := ForTest(context.Background(), conn.Conn(), *versionKey, orgA, status.Active)
+		userB := ForTest(context.Background(), conn.Conn(), *versionKey, orgB, status.Active)
+		for _, u := range []*User{userA, userB} {
+			err = u.UpdateEmail(context.Background(), conn.Conn(), uuid.New(), st.EncryptionKeys, email)
+			require.NoError(t, err, "update email")
+		}
+
+		found, err := FindByEmailDigest(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			orgA,
+			digest.SHA256Hex(email),
+		)
+		require.NoError(t, err, "find")
+		require.Equal(t, *userA, *found, "round trip")
+
+		found, err = FindByEmailDigest(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			orgB,
+			digest.SHA256Hex(email),
+		)
+		require.NoError(t, err, "find other org")
+		require.Equal(t, userB.ID, found.ID, "other org user")
+
+		_, err = FindByEmailDigest(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			uuid.New(),
+			digest.SHA256Hex(email),
+		)
+		require.ErrorIs(t, err, pgx.ErrNoRows, "wrong org")
+
+		_, err = FindByEmailDigest(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			orgA,
+			digest.SHA256Hex(uuid.NewString()),
+		)
+		require.ErrorIs(t, err, pgx.ErrNoRows, "not found")
+	})
+}
+
+func TestReadAsOf(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		user := ForTest(
+			context.Background(),
+			conn.Conn(),
+			*versionKey,
+			uuid.New(),
+			status.Active,
+		)
+		before := *user
+
+		_, err = ReadAsOf(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+			time.Unix(user.Ctime-1, 0),
+		)
+		require.Equal(t, ErrNotFound, err, "before insert")
+
+		// Mtime resolves to the second, so the update must be in a
+		// later second than the insert to be told apart.
+		time.Sleep(1100 * time.Millisecond)
+
+		_, err = user.UpdateDisplayName(
+			context.Background(),
+			conn.Conn(),
+			uuid.New(),
+			st.EncryptionKeys,
+			uuid.NewString(),
+		)
+		require.NoError(t, err, "update display name")
+		require.Greater(t, user.Mtime, before.Mtime, "mtime")
+
+		oldUser, err := ReadAsOf(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+			time.Unix(before.Mtime, 0),
+		)
+		require.NoError(t, err, "read old")
+		require.Equal(t, before, *oldUser, "old state")
+
+		newUser, err := ReadAsOf(
+			context.Background(),
+			conn.Conn(),
+			st.EncryptionKeys,
+			user.ID,
+			time.Now(),
+		)
+		require.NoError(t, err, "read new")
+		require.Equal(t, *user, *newUser, "new state")
+	})
+}
+
+func TestInsertRateLimited(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := st.Master.Acquire(context.Background())
+		require.NoError(t, err, "master conn")
+		defer conn.Release()
+
+		versionKey, err := st.EncryptionKeys.Get(st.EncryptionKeyVersion)
+		require.NoError(t, err, "versionKey")
+
+		org := uuid.New()
+		insert := func(l runtime.RateLimiter) error {
+			ed25519PublicPEM, _, err := ed25519.Random()
+			require.NoError(t, err, "generate ed25519")
+			_, err = Insert(
+				context.Background(),
+				conn.Conn(),
+				l,
+				*versionKey,
+				uuid.NewString(), // display name
+				ed25519PublicPEM,
+				uuid.NewString(), // email
+				org,
+				password.Random(),
+				role.Test,
+				SchemaVersion,
+				status.Active,
+			)
+			return err
+		}
+		l := runtime.NewTokenBucket(0.001, 1)
+		require.NoError(t, insert(l), "first insert")
+		require.Equal(t, runtime.ErrRateLimited, insert(l), "limited")
+
+		// A missing limiter is an error, not a bypass.
+		require.Equal(t, runtime.ErrNoRateLimiter, insert(nil), "no limiter")
+	})
+}
+
+func TestCheckEnums(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		u := User{Status: status.Active, Role: role.Normal}
+		require.NoError(t, u.checkEnums(), "valid")
+
+		u.Status = 99
+		err := u.checkEnums()
+		require.ErrorIs(t, err, model.ErrCorruptEnum, "status")
+		require.Equal(t, &model.CorruptEnumError{Field: "status", Value: 99}, err, "status field")
+
+		u.Status = status.Deleted
+		u.Role = 0
+		err = u.checkEnums()
+		require.Equal(t, &model.CorruptEnumError{Field: "role", Value: 0}, err, "role field")
+	})
+}
+
+// errDB is a `postgresql.DB` on which every call fails with `err`.
+type errDB struct {
+	err error
+}
+
+func (d errDB) Begin(context.Context) (pgx.Tx, error) {
+	return nil, d.err
+}
+
+func (d errDB) Exec(context.Context, string, ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, d.err
+}
+
+func (d errDB) Query(context.Context, string, ...any) (pgx.Rows, error) {
+	return nil, d.err
+}
+
+func (d errDB) QueryRow(context.Context, string, ...any) pgx.Row {
+	return errRow(d)
+}
+
+func (d errDB) SendBatch(context.Context, *pgx.Batch) pgx.BatchResults {
+	return errBatch(d)
+}
+
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(...any) error {
+	return r.err
+}
+
+type errBatch struct {
+	err error
+}
+
+func (b errBatch) Exec() (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, b.err
+}
+
+func (b errBatch) Query() (pgx.Rows, error) {
+	return nil, b.err
+}
+
+func (b errBatch) QueryRow() pgx.Row {
+	return errRow(b)
+}
+
+func (b errBatch) Close() error {
+	return b.err
+}
+
+func TestDBErrors(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		t.Parallel()
+		dbErr := errors.New("connection reset")
+		db := errDB{err: dbErr}
+		u := &User{ID: uuid.New(), Status: status.Active}
+		before := *u
+
+		_, err := u.UpdateStatus(context.Background(), db, st.StatusSink, uuid.New(), status.Inactive)
+		require.Equal(t, dbErr, err, "update status")
+		require.Equal(t, before, *u, "receiver unchanged")
+
+		err = u.Shred(context.Background(), db, st.StatusSink, key.Versioned{Version: uuid.New(), Key: key.Random()})
+		require.Equal(t, dbErr, err, "shred")
+
+		_, err = SetRoleForUsers(context.Background(), db, uuid.New(), uuid.New(), []uuid.UUID{u.ID}, role.Normal)
+		require.Equal(t, dbErr, err, "set role")
+
+		_, err = Read(context.Background(), db, st.EncryptionKeys, u.ID)
+		require.Equal(t, dbErr, err, "read")
+	})
 }